@@ -31,7 +31,6 @@ func NewConfig() Config {
 		fmt.Fprintf(os.Stderr, "Can't load config file:%v error: %v\n", configFile, err)
 		os.Exit(1)
 	}
-	fmt.Println("Config:", config)
 	return config
 }
 
@@ -1,25 +1,71 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/cronnoss/avitotech/internal/app"
 	"github.com/cronnoss/avitotech/internal/logger"
+	"github.com/cronnoss/avitotech/internal/rates"
 	internalhttp "github.com/cronnoss/avitotech/internal/server/http"
 	"github.com/cronnoss/avitotech/internal/storage"
+	"github.com/cronnoss/avitotech/internal/tracing"
 )
 
 func main() {
 	conf := NewConfig().AvitotechConf
 	storage := storage.NewStorage(conf.Storage)
-	logger := logger.NewLogger(conf.Logger.Level, os.Stdout)
-	avitotech := app.NewAvitotech(logger, conf, storage)
-	httpsrv := internalhttp.NewServer(logger, avitotech, conf.HTTP.Host, conf.HTTP.Port)
+	logger := logger.New(conf.Logger, os.Stdout)
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.Tracing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't set up tracing:%v\n", err)
+		os.Exit(1)
+	}
+	rates, err := rates.NewProvider(conf.Rates)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't create rates provider:%v\n", err)
+		os.Exit(1)
+	}
+	avitotech := app.NewAvitotech(logger, conf, storage, rates)
+	if flag.Arg(0) == "create-api-key" {
+		createAPIKey(avitotech, flag.Args()[1:])
+		return
+	}
+	httpsrv := internalhttp.NewServer(logger, avitotech, conf.HTTP.Host, conf.HTTP.Port,
+		conf.HTTP.LegacyRoutes, conf.Auth.Enabled)
 
 	avitotech.Run(httpsrv)
 
+	// the spans of the last requests are sent before the exit
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't flush traces:%v\n", err)
+	}
+
 	filename := filepath.Base(os.Args[0])
 	fmt.Printf("%s stopped\n", filename)
 }
+
+// createAPIKey creates the key of a service, the arguments are its name and scopes.
+// The key is printed once, only its hash is stored.
+func createAPIKey(avitotech *app.Avitotech, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s create-api-key NAME SCOPE...\n", filepath.Base(os.Args[0]))
+		os.Exit(1)
+	}
+	key, apiKey, err := avitotech.CreateAPIKey(context.Background(), args[0], args[1:])
+	avitotech.Close(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't create API key:%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("API key %d %q with scopes %q:\n%s\n", apiKey.ID, apiKey.Name, apiKey.Scopes, key)
+	if apiKey.SigningSecret != "" {
+		fmt.Printf("Signing secret:\n%s\n", apiKey.SigningSecret)
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+// maxReasonLength is the length of the reasons of account status changes the storage keeps.
+const maxReasonLength = 255
+
+// SetAccountStatus freezes or unfreezes the account of c.UserID, the reason is kept in the audit trail.
+func (a *Avitotech) SetAccountStatus(ctx context.Context, c *model.AccountStatusChange) (_ *model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.SetAccountStatus")
+	defer endSpan(span, &err)
+	if err := authorizeService(ctx); err != nil {
+		return nil, err
+	}
+	change := *c
+	change.Reason = strings.TrimSpace(c.Reason)
+	switch {
+	case c.Status == model.AccountClosed:
+		return nil, model.Errorf(model.ErrInvalidArgument, "accounts are closed by the closure")
+	case !slices.Contains(model.AccountStatuses, c.Status):
+		return nil, model.Errorf(model.ErrInvalidArgument, "unknown account status %q, expected one of: %s",
+			c.Status, strings.Join(model.AccountStatuses, ", "))
+	case change.Reason == "":
+		return nil, model.Errorf(model.ErrInvalidArgument, "reason is required")
+	case utf8.RuneCountInString(change.Reason) > maxReasonLength:
+		return nil, model.Errorf(model.ErrInvalidArgument, "reason must be at most %d characters", maxReasonLength)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.SetAccountStatus(ctx, &change)
+}
+
+// GetAccountStatusChanges returns the audit trail of the account status of the user.
+func (a *Avitotech) GetAccountStatusChanges(ctx context.Context,
+	userID int64,
+) (_ []model.AccountStatusChange, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetAccountStatusChanges")
+	defer endSpan(span, &err)
+	if err := authorizeService(ctx); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := a.storage.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	return a.storage.ListAccountStatusChanges(ctx, userID)
+}
+
+// CloseAccount closes the account of c.UserID for good, the money left is paid out to c.PayoutUserID.
+func (a *Avitotech) CloseAccount(ctx context.Context, c *model.AccountClosure) (_ *model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.CloseAccount")
+	defer endSpan(span, &err)
+	if err := authorizeService(ctx); err != nil {
+		return nil, err
+	}
+	closure := *c
+	closure.Reason = strings.TrimSpace(c.Reason)
+	switch {
+	case closure.Reason == "":
+		return nil, model.Errorf(model.ErrInvalidArgument, "reason is required")
+	case utf8.RuneCountInString(closure.Reason) > maxReasonLength:
+		return nil, model.Errorf(model.ErrInvalidArgument, "reason must be at most %d characters", maxReasonLength)
+	case c.PayoutUserID != nil && *c.PayoutUserID == c.UserID:
+		return nil, model.Errorf(model.ErrInvalidArgument, "money can't be paid out to the closed account")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.CloseAccount(ctx, &closure)
+}
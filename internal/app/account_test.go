@@ -0,0 +1,23 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvitotech_AccountReasonTooLong(t *testing.T) {
+	a := &Avitotech{}
+	reason := strings.Repeat("я", maxReasonLength+1)
+
+	_, err := a.SetAccountStatus(context.Background(), &model.AccountStatusChange{
+		UserID: 7, Status: model.AccountFrozen, Reason: reason,
+	})
+	require.ErrorIs(t, err, model.ErrInvalidArgument)
+
+	_, err = a.CloseAccount(context.Background(), &model.AccountClosure{UserID: 7, Reason: reason})
+	require.ErrorIs(t, err, model.ErrInvalidArgument)
+}
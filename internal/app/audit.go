@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+// RecordAudit appends the record of a state-changing call to the audit log.
+func (a *Avitotech) RecordAudit(ctx context.Context, r *model.AuditRecord) (err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.RecordAudit")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.RecordAudit(ctx, r)
+}
+
+// GetAuditLog returns a page of audit records matching the filter, from newest to oldest.
+func (a *Avitotech) GetAuditLog(ctx context.Context, f *model.AuditFilter, cur string) (_ *model.AuditPage, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetAuditLog")
+	defer endSpan(span, &err)
+	if err := authorizeService(ctx); err != nil {
+		return nil, err
+	}
+	filter := *f
+	if cur != "" {
+		var err error
+		if filter.AfterID, err = decodeAuditCursor(cur); err != nil {
+			return nil, err
+		}
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, model.Errorf(model.ErrInvalidArgument, "from must be before to")
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	limit := min(filter.Limit, maxPageSize)
+	filter.Limit = limit + 1
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	ans, err := a.storage.ListAudit(ctx, &filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.AuditPage{Records: ans}
+	if len(ans) > limit {
+		page.Records = ans[:limit]
+		if page.NextCursor, err = encodeAuditCursor(&ans[limit-1]); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
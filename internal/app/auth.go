@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	apiKeyPrefix           = "ak_"
+	defaultSignatureWindow = 5 * time.Minute
+	// signingInfo binds the secrets derived from the pepper to the signatures of requests.
+	signingInfo = "avitotech api key signing"
+)
+
+// CreateAPIKey creates a key of the service with the scopes. The key and its signing secret
+// are returned only here, the service stores the hash of the key.
+func (a *Avitotech) CreateAPIKey(ctx context.Context, name string,
+	scopes []string,
+) (_ string, _ *model.APIKey, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.CreateAPIKey")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, model.Errorf(model.ErrInvalidArgument, "name of the key is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(model.Scopes, scope) {
+			return "", nil, model.Errorf(model.ErrInvalidArgument, "unknown scope %q, expected one of %s",
+				scope, strings.Join(model.Scopes, ", "))
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+
+	ans, err := a.storage.CreateAPIKey(ctx, &model.APIKey{
+		Name:    name,
+		KeyHash: hashSecret(key),
+		Scopes:  strings.Join(scopes, " "),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(a.signingPepper) > 0 {
+		if ans.SigningSecret, err = a.signingSecret(ans.KeyHash); err != nil {
+			return "", nil, err
+		}
+	}
+	return key, ans, nil
+}
+
+// AuthenticateKey returns the API key presented by the caller as is.
+func (a *Avitotech) AuthenticateKey(ctx context.Context, key string) (_ *model.APIKey, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.AuthenticateKey")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	ans, err := a.storage.FindAPIKey(ctx, hashSecret(key))
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, model.Errorf(model.ErrUnauthorized, "unknown API key")
+	}
+	return ans, err
+}
+
+// AuthenticateSignature returns the API key that signed the request. The signature is the hex HMAC-SHA256
+// of the payload with the signing secret of the key, it is accepted once within the signature window.
+func (a *Avitotech) AuthenticateSignature(ctx context.Context, r *model.SignedRequest) (_ *model.APIKey, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.AuthenticateSignature")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	window := a.conf.Auth.SignatureWindow
+	if window <= 0 {
+		window = defaultSignatureWindow
+	}
+	if skew := time.Since(r.Timestamp).Abs(); skew > window {
+		return nil, model.Errorf(model.ErrUnauthorized, "timestamp of the request is outside of %s", window)
+	}
+	if r.Nonce == "" {
+		return nil, model.Errorf(model.ErrUnauthorized, "nonce is required")
+	}
+
+	key, err := a.storage.GetAPIKey(ctx, r.KeyID)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, model.Errorf(model.ErrUnauthorized, "unknown API key")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(a.signingPepper) == 0 {
+		return nil, model.Errorf(model.ErrUnauthorized, "signed requests are disabled")
+	}
+	secret, err := a.signingSecret(key.KeyHash)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(r.Payload)
+	signature, err := hex.DecodeString(r.Signature)
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, model.Errorf(model.ErrUnauthorized, "wrong signature")
+	}
+
+	// the nonce outlives the window on both sides of the timestamp, so a replay can't pass either check
+	if err = a.storage.UseNonce(ctx, key.ID, r.Nonce, 2*window); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// signingSecret returns the hex signing secret of the API key. It is derived by HKDF from the pepper
+// of the service, so the stored hash of the key alone doesn't let anyone sign requests.
+func (a *Avitotech) signingSecret(keyHash string) (string, error) {
+	secret := make([]byte, sha256.Size)
+	r := hkdf.New(sha256.New, a.signingPepper, []byte(keyHash), []byte(signingInfo))
+	if _, err := io.ReadFull(r, secret); err != nil {
+		return "", fmt.Errorf("failed to derive signing secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// hashSecret returns the hex SHA-256 of an API key or a refresh token, only it is stored.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
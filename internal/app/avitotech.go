@@ -2,19 +2,23 @@ package app
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/cronnoss/avitotech/internal/logger"
 	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/rates"
 	"github.com/cronnoss/avitotech/internal/server"
 	"github.com/cronnoss/avitotech/internal/storage"
+	"github.com/cronnoss/avitotech/internal/tracing"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -24,24 +28,123 @@ type AvitotechConf struct {
 	HTTP    struct {
 		Host string `toml:"host"`
 		Port string `toml:"port"`
+		// LegacyRoutes keeps the routes before /api/v1 for the deprecation period.
+		LegacyRoutes bool `toml:"legacy_routes"`
 	} `toml:"http-server"`
+	Idempotency struct {
+		TTL time.Duration `toml:"ttl"`
+	} `toml:"idempotency"`
+	Reservation struct {
+		TTL time.Duration `toml:"ttl"`
+	} `toml:"reservation"`
+	Rates rates.Conf `toml:"rates"`
+	FX    struct {
+		// Spread is the part of the money exchanged between wallets kept by the service, like 0.01.
+		Spread decimal.Decimal `toml:"spread"`
+		// QuoteTTL is how long a quoted rate is kept for the purchase.
+		QuoteTTL time.Duration `toml:"quote_ttl"`
+		// MaxRateAge is how old the rates of the provider may be to move money, it should exceed the rates TTL.
+		MaxRateAge time.Duration `toml:"max_rate_age"`
+	} `toml:"fx"`
+	Auth struct {
+		// Enabled requires an API key or a signature of one on every route but the health checks.
+		Enabled bool `toml:"enabled"`
+		// SignatureWindow is how far the timestamp of a signed request may be from now.
+		SignatureWindow time.Duration `toml:"signature_window"`
+		// JWTSecret signs access tokens of users, AVITOTECH_JWT_SECRET overrides it.
+		JWTSecret string `toml:"jwt_secret"`
+		// SigningPepper derives the signing secrets of API keys, AVITOTECH_SIGNING_PEPPER overrides it.
+		// Requests can't be signed without it.
+		SigningPepper string        `toml:"signing_pepper"`
+		AccessTTL     time.Duration `toml:"access_ttl"`
+		RefreshTTL    time.Duration `toml:"refresh_ttl"`
+	} `toml:"auth"`
+	Tracing tracing.Conf `toml:"tracing"`
+}
+
+const (
+	defaultPageSize       = 20
+	maxPageSize           = 100
+	defaultIdempotencyTTL = 24 * time.Hour
+	defaultReservationTTL = 15 * time.Minute
+	defaultQuoteTTL       = time.Minute
+	defaultMaxRateAge     = 2 * time.Hour
+	cleanupInterval       = time.Minute
+	envJWTSecret          = "AVITOTECH_JWT_SECRET"
+	envSigningPepper      = "AVITOTECH_SIGNING_PEPPER"
+)
+
+// tracer makes the spans of the methods, the storage and the rates provider make the child spans.
+var tracer = tracing.Tracer("github.com/cronnoss/avitotech/internal/app")
+
+// endSpan ends the span of the method, the error the method returns fails the span.
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		tracing.Fail(span, *err)
+	}
+	span.End()
 }
 
 type Avitotech struct {
-	conf    AvitotechConf
-	log     server.Logger
-	storage Storage
+	conf      AvitotechConf
+	log       server.Logger
+	storage   Storage
+	rates     RateProvider
+	jwtSecret []byte
+	// signingPepper is the server-side secret the signing secrets of API keys are derived with.
+	signingPepper []byte
+	// snapshotAt is when the provider fetched the rates of the last snapshot.
+	snapshotAt time.Time
 }
 
 type Storage interface {
 	Connect(context.Context) error
 	Close(context.Context) error
 	GetBalance(context.Context, *model.Balance) (*model.Balance, error)
-	TopUp(context.Context, int64, decimal.Decimal, string) (*model.Balance, error)
-	Debit(context.Context, int64, decimal.Decimal, string) (*model.Balance, error)
-	GetTransactions(context.Context, int64) ([]model.Transaction, error)
-	GetTransactionsByDate(context.Context, int64) ([]model.Transaction, error)
-	GetTransactionsByAmount(context.Context, int64) ([]model.Transaction, error)
+	ListWallets(context.Context, int64) ([]model.Balance, error)
+	TopUp(context.Context, *model.Transaction) (*model.Balance, error)
+	Debit(context.Context, *model.Transaction) (*model.Balance, error)
+	Transfer(context.Context, *model.Transfer) (*model.Transfer, error)
+	GetTransfer(context.Context, int64) (*model.Transfer, error)
+	ListTransfers(context.Context, *model.TransferFilter) ([]model.Transfer, error)
+	ListTransactions(context.Context, *model.TransactionFilter) ([]model.Transaction, error)
+	GetTransaction(context.Context, int64) (*model.Transaction, error)
+	Refund(context.Context, *model.Refund) (*model.Transaction, error)
+	AcquireIdempotencyKey(context.Context, string, string, time.Duration) (*model.IdempotencyKey, error)
+	SaveIdempotencyResponse(context.Context, string, int, []byte) error
+	ReleaseIdempotencyKey(context.Context, string) error
+	DeleteExpiredIdempotencyKeys(context.Context) (int64, error)
+	Reserve(context.Context, *model.Reservation, time.Duration) (*model.Reservation, error)
+	CaptureReservation(context.Context, *model.Reservation, decimal.Decimal) (*model.Balance, error)
+	ReleaseReservation(context.Context, *model.Reservation) (*model.Balance, error)
+	ReleaseExpiredReservations(context.Context) (int64, error)
+	SaveExchangeRates(context.Context, []model.ExchangeRate) ([]model.ExchangeRate, error)
+	GetExchangeRate(context.Context, string, time.Time) (*model.ExchangeRate, error)
+	ListExchangeRates(context.Context, time.Time) ([]model.ExchangeRate, error)
+	CreateQuote(context.Context, *model.Quote, time.Duration) (*model.Quote, error)
+	CreateAPIKey(context.Context, *model.APIKey) (*model.APIKey, error)
+	GetAPIKey(context.Context, int64) (*model.APIKey, error)
+	FindAPIKey(context.Context, string) (*model.APIKey, error)
+	UseNonce(context.Context, int64, string, time.Duration) error
+	DeleteExpiredNonces(context.Context) (int64, error)
+	CreateUser(context.Context, *model.User) (*model.User, error)
+	GetUser(context.Context, int64) (*model.User, error)
+	UpdateUser(context.Context, int64, *model.UserUpdate) (*model.User, error)
+	SearchUsers(context.Context, *model.UserFilter) ([]model.User, error)
+	DeleteUser(context.Context, int64) (*model.User, error)
+	FindUser(context.Context, string) (*model.User, error)
+	SetAccountStatus(context.Context, *model.AccountStatusChange) (*model.User, error)
+	ListAccountStatusChanges(context.Context, int64) ([]model.AccountStatusChange, error)
+	CloseAccount(context.Context, *model.AccountClosure) (*model.User, error)
+	RecordAudit(context.Context, *model.AuditRecord) error
+	ListAudit(context.Context, *model.AuditFilter) ([]model.AuditRecord, error)
+	SaveRefreshToken(context.Context, int64, string, time.Time) error
+	UseRefreshToken(context.Context, string) (int64, error)
+}
+
+// RateProvider returns the exchange rates used to convert balances.
+type RateProvider interface {
+	Rates(context.Context) (*model.Rates, error)
 }
 
 type Server interface {
@@ -49,129 +152,453 @@ type Server interface {
 	Stop(context.Context) error
 }
 
-var (
-	purchase = "purchase"
-	bankCard = "bank_card"
-	transfer = "transfer"
-)
-
-func (a *Avitotech) GetBalance(ctx context.Context, b *model.Balance) (*model.Balance, error) {
+// GetBalance returns the balance of the b.Currency wallet of the user, the ruble one by default.
+func (a *Avitotech) GetBalance(ctx context.Context, b *model.Balance) (_ *model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetBalance")
+	defer endSpan(span, &err)
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
-	return a.storage.GetBalance(ctx, b)
+
+	if err := authorizeUser(ctx, b.UserID); err != nil {
+		return nil, err
+	}
+	currency, err := walletCurrency(b.Currency)
+	if err != nil {
+		return nil, err
+	}
+	wallet := *b
+	wallet.Currency = currency
+	return a.storage.GetBalance(ctx, &wallet)
 }
 
-func (a *Avitotech) TopUp(ctx context.Context, userID int64, amount decimal.Decimal) (*model.Balance, error) {
+// GetWallets returns the balances of all wallets of the user.
+func (a *Avitotech) GetWallets(ctx context.Context, userID int64) (_ []model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetWallets")
+	defer endSpan(span, &err)
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
-	return a.storage.TopUp(ctx, userID, amount, bankCard)
+
+	if err := authorizeUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	ans, err := a.storage.ListWallets(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if ans == nil {
+		ans = []model.Balance{}
+	}
+	return ans, nil
 }
 
-func (a *Avitotech) Debit(ctx context.Context, userID int64, amount decimal.Decimal) (*model.Balance, error) {
+// TopUp credits the user from a bank card. The caller fills UserID, Amount and optionally
+// Currency of the wallet, rubles by default, ServiceID, OrderID and Comment of t.
+func (a *Avitotech) TopUp(ctx context.Context, t *model.Transaction) (_ *model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.TopUp")
+	defer endSpan(span, &err)
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
-	return a.storage.Debit(ctx, userID, amount.Neg(), purchase)
-}
 
-func (a *Avitotech) GetTransactions(ctx context.Context, userID int64, sort string) ([]model.Transaction, error) {
-	switch sort {
-	case "":
-		return a.storage.GetTransactions(ctx, userID)
-	case "date":
-		return a.storage.GetTransactionsByDate(ctx, userID)
-	case "amount":
-		return a.storage.GetTransactionsByAmount(ctx, userID)
-	default:
-		return nil, errors.New("wrong sort")
+	if t.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "amount must be greater than zero")
+	}
+
+	currency, err := walletCurrency(t.Currency)
+	if err != nil {
+		return nil, err
 	}
+
+	topUp := *t
+	topUp.Currency = currency
+	topUp.Kind = model.TransactionTopUp
+	topUp.Source = model.SourceBankCard
+	topUp.CounterpartyUserID = nil
+	// only refunds made by Refund point to the transaction they return,
+	// only the legs made by Transfer point to their transfer
+	topUp.RefundOf = nil
+	topUp.TransferID = nil
+	// quotes price purchases, a top-up holding one would use it up
+	topUp.QuoteID = nil
+	return a.storage.TopUp(ctx, &topUp)
 }
 
-func (a *Avitotech) Transfer(ctx context.Context, fromID, toID int64, amount decimal.Decimal) (*model.Balance, error) {
+// Debit charges the user for a purchase. The caller fills UserID, positive Amount and optionally
+// Currency of the wallet, rubles by default, ServiceID, OrderID and Comment of t.
+// With QuoteID the quoted rubles are charged and Amount may be omitted.
+func (a *Avitotech) Debit(ctx context.Context, t *model.Transaction) (_ *model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Debit")
+	defer endSpan(span, &err)
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	if amount.LessThanOrEqual(decimal.Zero) {
-		return nil, errors.New("amount must be greater than zero")
+	if t.Amount.LessThanOrEqual(decimal.Zero) && (t.QuoteID == nil || !t.Amount.IsZero()) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "amount must be greater than zero")
 	}
 
-	balance, err := a.storage.GetBalance(ctx, &model.Balance{UserID: fromID})
+	currency, err := walletCurrency(t.Currency)
 	if err != nil {
 		return nil, err
 	}
+	if t.QuoteID != nil && currency != model.CurrencyRUB {
+		return nil, model.Errorf(model.ErrInvalidArgument, "quoted purchases are charged from the %s wallet",
+			model.CurrencyRUB)
+	}
+
+	debit := *t
+	debit.Currency = currency
+	debit.Amount = t.Amount.Neg()
+	debit.Kind = model.TransactionPurchase
+	debit.Source = model.SourcePurchase
+	debit.CounterpartyUserID = nil
+	debit.RefundOf = nil
+	debit.TransferID = nil
+	return a.storage.Debit(ctx, &debit)
+}
+
+// GetTransactions returns a page of transactions matching the filter. The page size is limited by maxPageSize,
+// the next page is requested with the cursor returned in the previous one.
+// Without the user the filter must have the request, such pages are given only to services.
+func (a *Avitotech) GetTransactions(ctx context.Context, f *model.TransactionFilter,
+	cur string,
+) (_ *model.TransactionPage, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetTransactions")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if f.UserID == 0 {
+		// support looks up the transactions of a call without knowing its users
+		if err := authorizeService(ctx); err != nil {
+			return nil, err
+		}
+		if f.RequestID == "" {
+			return nil, model.Errorf(model.ErrBadRequest, "request_id is required without the user")
+		}
+	} else if err := authorizeUser(ctx, f.UserID); err != nil {
+		return nil, err
+	}
+	if err := normalizeFilter(f); err != nil {
+		return nil, err
+	}
 
-	if balance.Amount.LessThan(amount) {
-		return nil, errors.New("insufficient funds")
+	if cur != "" {
+		var err error
+		if f.After, err = decodeCursor(f.SortBy+" "+f.Order, cur); err != nil {
+			return nil, err
+		}
 	}
 
-	_, err = a.storage.Debit(ctx, fromID, amount.Neg(), transfer)
+	// one more row tells whether there is a next page
+	limit := f.Limit
+	f.Limit++
+	ans, err := a.storage.ListTransactions(ctx, f)
 	if err != nil {
 		return nil, err
 	}
 
-	ans, err := a.storage.TopUp(ctx, toID, amount, transfer)
+	for i := range ans {
+		ans[i].Operation = ans[i].Describe()
+	}
+	if f.Currency != "" {
+		if err = a.convertTransactions(ctx, ans, f.Currency); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &model.TransactionPage{Transactions: ans}
+	if len(ans) > limit {
+		page.Transactions = ans[:limit]
+		if page.NextCursor, err = encodeCursor(f.SortBy+" "+f.Order, &ans[limit-1]); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func (a *Avitotech) GetTransaction(ctx context.Context, id int64) (_ *model.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetTransaction")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	ans, err := a.storage.GetTransaction(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	// transactions of other users are hidden from end users
+	if authorizeUser(ctx, ans.UserID) != nil {
+		return nil, model.Errorf(model.ErrNotFound, "transaction with ID %d does not exist", id)
+	}
+	describe(ans)
+	return ans, nil
+}
+
+// Refund returns money of the transaction, zero amount refunds the rest of it.
+// The refunded transaction is returned with all its refunds.
+func (a *Avitotech) Refund(ctx context.Context, r *model.Refund) (_ *model.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Refund")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
 
+	if r.Amount.LessThan(decimal.Zero) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "amount must not be negative")
+	}
+
+	ans, err := a.storage.Refund(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	describe(ans)
 	return ans, nil
 }
 
-func (a *Avitotech) ConvertBalance(ctx context.Context, b *model.Balance, currency string) (*model.Balance, error) {
-	type Currency struct {
-		Base  string `json:"base"` // base = EUR
-		Rates struct {
-			Rub float64 `json:"RUB"`
-			Usd float64 `json:"USD"`
-		} `json:"rates"`
+// describe fills the operations of the transaction and its refunds.
+func describe(t *model.Transaction) {
+	t.Operation = t.Describe()
+	for i := range t.Refunds {
+		t.Refunds[i].Operation = t.Refunds[i].Describe()
 	}
+}
 
-	var cur Currency
+// Transfer moves money between wallets of users and returns the created transfer.
+func (a *Avitotech) Transfer(ctx context.Context, t *model.Transfer) (_ *model.Transfer, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Transfer")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
 
-	endpoint := "http://api.exchangeratesapi.io/v1/latest?access_key=e532701035ed3f4040b2660e6b7a8a3d"
-	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
-	if err != nil {
+	if err := authorizeUser(ctx, t.FromID); err != nil {
 		return nil, err
 	}
+	if t.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "amount must be greater than zero")
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	transfer := *t
+	if transfer.Currency, err = walletCurrency(t.Currency); err != nil {
+		return nil, err
+	}
+	// the recipient gets the money in the same currency by default
+	transfer.ToCurrency = transfer.Currency
+	if t.ToCurrency != "" {
+		if transfer.ToCurrency, err = currencyCode(t.ToCurrency); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.FromID == t.ToID && transfer.Currency == transfer.ToCurrency {
+		return nil, model.Errorf(model.ErrInvalidArgument, "can't transfer to the same wallet")
+	}
+
+	if err = a.exchange(ctx, &transfer); err != nil {
+		return nil, err
+	}
+	return a.storage.Transfer(ctx, &transfer)
+}
+
+func (a *Avitotech) GetTransfer(ctx context.Context, id int64) (_ *model.Transfer, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetTransfer")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	ans, err := a.storage.GetTransfer(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	// transfers of other users are hidden from end users
+	if authorizeUser(ctx, ans.FromID) != nil && authorizeUser(ctx, ans.ToID) != nil {
+		return nil, model.Errorf(model.ErrNotFound, "transfer with ID %d does not exist", id)
+	}
+	return ans, nil
+}
 
-	defer resp.Body.Close()
-	err = json.NewDecoder(resp.Body).Decode(&cur)
+// GetTransfers returns a page of transfers sent or received by the user, from newest to oldest.
+func (a *Avitotech) GetTransfers(ctx context.Context, f *model.TransferFilter,
+	cur string,
+) (_ *model.TransferPage, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetTransfers")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if err := authorizeUser(ctx, f.UserID); err != nil {
+		return nil, err
+	}
+	if cur != "" {
+		var err error
+		if f.AfterID, err = decodeTransferCursor(cur); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = defaultPageSize
+	}
+	limit := min(f.Limit, maxPageSize)
+	f.Limit = limit + 1
+	ans, err := a.storage.ListTransfers(ctx, f)
 	if err != nil {
-		return nil, errors.New("failed to decode response from exchangeratesapi.io")
+		return nil, err
 	}
 
-	// balance in eur, because it is the base in api.exchangeratesapi.io
-	beur := b.Amount.Div(decimal.NewFromFloat(cur.Rates.Rub)) // b.Amount / cur.Rates.Rub
+	page := &model.TransferPage{Transfers: ans}
+	if len(ans) > limit {
+		page.Transfers = ans[:limit]
+		if page.NextCursor, err = encodeTransferCursor(&ans[limit-1]); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func (a *Avitotech) Reserve(ctx context.Context, r *model.Reservation) (_ *model.Reservation, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Reserve")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
 
-	switch currency {
-	case "USD":
-		busd := beur.Mul(decimal.NewFromFloat(cur.Rates.Usd)) // beur * cur.Rates.Usd
-		b.Amount = busd
-	case "EUR":
-		b.Amount = beur
+	if r.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "amount must be greater than zero")
 	}
-	return b, nil
+
+	ttl := a.conf.Reservation.TTL
+	if ttl <= 0 {
+		ttl = defaultReservationTTL
+	}
+	return a.storage.Reserve(ctx, r, ttl)
 }
 
-func (a *Avitotech) Close(ctx context.Context) error {
+// CaptureReservation charges amount of the reservation, zero amount captures it in full.
+func (a *Avitotech) CaptureReservation(ctx context.Context, r *model.Reservation,
+	amount decimal.Decimal,
+) (_ *model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.CaptureReservation")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if amount.LessThan(decimal.Zero) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "amount must not be negative")
+	}
+	return a.storage.CaptureReservation(ctx, r, amount)
+}
+
+func (a *Avitotech) ReleaseReservation(ctx context.Context, r *model.Reservation) (_ *model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.ReleaseReservation")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.ReleaseReservation(ctx, r)
+}
+
+func (a *Avitotech) AcquireIdempotencyKey(ctx context.Context, key,
+	fingerprint string,
+) (_ *model.IdempotencyKey, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.AcquireIdempotencyKey")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	ttl := a.conf.Idempotency.TTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	return a.storage.AcquireIdempotencyKey(ctx, key, fingerprint, ttl)
+}
+
+func (a *Avitotech) SaveIdempotencyResponse(ctx context.Context, key string, status int, response []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.SaveIdempotencyResponse")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.SaveIdempotencyResponse(ctx, key, status, response)
+}
+
+func (a *Avitotech) ReleaseIdempotencyKey(ctx context.Context, key string) (err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.ReleaseIdempotencyKey")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.ReleaseIdempotencyKey(ctx, key)
+}
+
+// cleanup periodically removes expired data until ctx is done.
+func (a *Avitotech) cleanup(ctx context.Context) error {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			n, err := a.storage.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				a.log.Errorf("failed to delete expired idempotency keys:%v\n", err)
+			} else if n > 0 {
+				a.log.Debugf("deleted %d expired idempotency keys\n", n)
+			}
+
+			n, err = a.storage.ReleaseExpiredReservations(ctx)
+			if err != nil {
+				a.log.Errorf("failed to release expired reservations:%v\n", err)
+			} else if n > 0 {
+				a.log.Infof("released %d expired reservations\n", n)
+			}
+
+			n, err = a.storage.DeleteExpiredNonces(ctx)
+			if err != nil {
+				a.log.Errorf("failed to delete expired nonces:%v\n", err)
+			} else if n > 0 {
+				a.log.Debugf("deleted %d expired nonces\n", n)
+			}
+		}
+	}
+}
+
+func (a *Avitotech) Close(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Close")
+	defer endSpan(span, &err)
 	a.log.Infof("App closed\n")
 	return a.storage.Close(ctx)
 }
 
-func NewAvitotech(log server.Logger, conf AvitotechConf, storage Storage) *Avitotech {
+func NewAvitotech(log server.Logger, conf AvitotechConf, storage Storage, rates RateProvider) *Avitotech {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := storage.Connect(ctx); err != nil {
 		server.Exitfail(fmt.Sprintf("Can't connect to storage:%v", err))
 	}
+	if conf.FX.Spread.IsNegative() || conf.FX.Spread.GreaterThanOrEqual(decimal.NewFromInt(1)) {
+		server.Exitfail(fmt.Sprintf("Wrong fx spread %s, expected a part from 0 to 1", conf.FX.Spread))
+	}
+
+	if secret := os.Getenv(envJWTSecret); secret != "" {
+		conf.Auth.JWTSecret = secret
+	}
+	jwtSecret := []byte(conf.Auth.JWTSecret)
+	if len(jwtSecret) == 0 {
+		// tokens of users live until the restart then
+		log.Warningf("JWT secret is not set, using a random one\n")
+		jwtSecret = make([]byte, 32)
+		if _, err := rand.Read(jwtSecret); err != nil {
+			server.Exitfail(fmt.Sprintf("Can't generate JWT secret:%v", err))
+		}
+	}
 
-	return &Avitotech{log: log, conf: conf, storage: storage}
+	if pepper := os.Getenv(envSigningPepper); pepper != "" {
+		conf.Auth.SigningPepper = pepper
+	}
+	if conf.Auth.SigningPepper == "" {
+		log.Warningf("signing pepper is not set, requests can be authenticated by API keys only\n")
+	}
+
+	return &Avitotech{
+		log: log, conf: conf, storage: storage, rates: rates,
+		jwtSecret: jwtSecret, signingPepper: []byte(conf.Auth.SigningPepper),
+	}
 }
 
 func (a Avitotech) Run(httpsrv Server) {
@@ -204,6 +631,12 @@ func (a Avitotech) Run(httpsrv Server) {
 	}()
 
 	g.Go(func1)
+	g.Go(func() error {
+		return a.cleanup(ctxEG)
+	})
+	g.Go(func() error {
+		return a.snapshotRates(ctxEG)
+	})
 
 	if err := g.Wait(); err != nil {
 		if !errors.Is(err, http.ErrServerClosed) &&
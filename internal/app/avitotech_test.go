@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+type transactionsStorage struct {
+	Storage
+	filter *model.TransactionFilter
+}
+
+func (s *transactionsStorage) ListTransactions(_ context.Context,
+	f *model.TransactionFilter,
+) ([]model.Transaction, error) {
+	s.filter = f
+	return []model.Transaction{{ID: 4, UserID: 1, RequestID: f.RequestID}}, nil
+}
+
+func TestAvitotech_GetTransactionsOfRequest(t *testing.T) {
+	storage := &transactionsStorage{}
+	a := &Avitotech{storage: storage}
+
+	page, err := a.GetTransactions(context.Background(), &model.TransactionFilter{RequestID: "billing-42"}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+	require.Equal(t, int64(0), storage.filter.UserID)
+	require.Equal(t, "billing-42", storage.filter.RequestID)
+
+	// the transactions of all users are looked up only by the request
+	_, err = a.GetTransactions(context.Background(), &model.TransactionFilter{}, "")
+	require.ErrorIs(t, err, model.ErrBadRequest)
+
+	// and only by services
+	ctx := model.ContextWithUser(context.Background(), 1)
+	_, err = a.GetTransactions(ctx, &model.TransactionFilter{RequestID: "billing-42"}, "")
+	require.ErrorIs(t, err, model.ErrForbidden)
+}
+
+type ledgerStorage struct {
+	Storage
+	recorded []model.Transaction
+}
+
+func (s *ledgerStorage) TopUp(_ context.Context, t *model.Transaction) (*model.Balance, error) {
+	s.recorded = append(s.recorded, *t)
+	return &model.Balance{UserID: t.UserID, Amount: t.Amount}, nil
+}
+
+func (s *ledgerStorage) Debit(_ context.Context, t *model.Transaction) (*model.Balance, error) {
+	s.recorded = append(s.recorded, *t)
+	return &model.Balance{UserID: t.UserID, Amount: t.Amount}, nil
+}
+
+func TestAvitotech_ServerOwnedFields(t *testing.T) {
+	storage := &ledgerStorage{}
+	a := &Avitotech{storage: storage}
+	other := int64(42)
+
+	// the fields the server sets itself are dropped from the body of the caller
+	_, err := a.TopUp(context.Background(), &model.Transaction{
+		UserID: 7, Amount: decimal.NewFromInt(10), RefundOf: &other, TransferID: &other, QuoteID: &other,
+	})
+	require.NoError(t, err)
+	_, err = a.Debit(context.Background(), &model.Transaction{
+		UserID: 7, Amount: decimal.NewFromInt(5), RefundOf: &other, TransferID: &other,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, storage.recorded, 2)
+	for _, tr := range storage.recorded {
+		require.Nil(t, tr.RefundOf)
+		require.Nil(t, tr.TransferID)
+	}
+	require.Nil(t, storage.recorded[0].QuoteID)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+var ErrCursor = model.Errorf(model.ErrInvalidArgument, "wrong cursor")
+
+// transfersCursor is the sort of transfer cursors, transfers are listed from newest to oldest only.
+const transfersCursor = "transfers"
+
+// auditCursor is the sort of audit log cursors, records are listed from newest to oldest only.
+const auditCursor = "audit"
+
+// cursor points to the last transaction or transfer of a page. Clients get it base64-encoded and pass it back as is.
+type cursor struct {
+	Sort   string          `json:"s"`
+	ID     int64           `json:"i"`
+	Date   string          `json:"d,omitempty"`
+	Amount decimal.Decimal `json:"a"`
+}
+
+func encodeCursor(sort string, last *model.Transaction) (string, error) {
+	return cursor{Sort: sort, ID: last.ID, Date: last.Date, Amount: last.Amount}.encode()
+}
+
+// decodeCursor returns the transaction the next page starts after, the cursor must be issued for the same sort.
+func decodeCursor(sort, s string) (*model.Transaction, error) {
+	c, err := parseCursor(sort, s)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Transaction{ID: c.ID, Date: c.Date, Amount: c.Amount}, nil
+}
+
+func encodeTransferCursor(last *model.Transfer) (string, error) {
+	return cursor{Sort: transfersCursor, ID: last.ID}.encode()
+}
+
+// decodeTransferCursor returns the ID of the transfer the next page starts after.
+func decodeTransferCursor(s string) (int64, error) {
+	c, err := parseCursor(transfersCursor, s)
+	if err != nil {
+		return 0, err
+	}
+	return c.ID, nil
+}
+
+func encodeAuditCursor(last *model.AuditRecord) (string, error) {
+	return cursor{Sort: auditCursor, ID: last.ID}.encode()
+}
+
+// decodeAuditCursor returns the ID of the audit record the next page starts after.
+func decodeAuditCursor(s string) (int64, error) {
+	c, err := parseCursor(auditCursor, s)
+	if err != nil {
+		return 0, err
+	}
+	return c.ID, nil
+}
+
+func (c cursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func parseCursor(sort, s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrCursor
+	}
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+		return nil, ErrCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,62 @@
+package app
+
+import "github.com/cronnoss/avitotech/internal/model"
+
+// defaultOrders keeps the orders the list had before sort order became configurable:
+// by default transactions go from oldest to newest, by date from newest and by amount from highest.
+var defaultOrders = map[string]string{
+	model.SortByID:     model.OrderAsc,
+	model.SortByDate:   model.OrderDesc,
+	model.SortByAmount: model.OrderDesc,
+}
+
+// normalizeFilter checks the filter and fills the defaults.
+func normalizeFilter(f *model.TransactionFilter) error {
+	if f.SortBy == "" {
+		f.SortBy = model.SortByID
+	}
+	defaultOrder, ok := defaultOrders[f.SortBy]
+	if !ok {
+		return model.Errorf(model.ErrInvalidArgument, "unknown sort %q, expected %s, %s or %s",
+			f.SortBy, model.SortByID, model.SortByDate, model.SortByAmount)
+	}
+
+	switch f.Order {
+	case "":
+		f.Order = defaultOrder
+	case model.OrderAsc, model.OrderDesc:
+	default:
+		return model.Errorf(model.ErrInvalidArgument, "unknown order %q, expected %s or %s", f.Order, model.OrderAsc, model.OrderDesc)
+	}
+
+	switch f.Type {
+	case "", model.TransactionTopUp, model.TransactionPurchase,
+		model.TransactionTransferIn, model.TransactionTransferOut, model.TransactionRefund, model.TransactionReversal:
+	default:
+		return model.Errorf(model.ErrInvalidArgument, "unknown type %q, expected %s, %s, %s, %s, %s or %s", f.Type, model.TransactionTopUp,
+			model.TransactionPurchase, model.TransactionTransferIn, model.TransactionTransferOut,
+			model.TransactionRefund, model.TransactionReversal)
+	}
+
+	switch f.Direction {
+	case "", model.DirectionIn, model.DirectionOut:
+	default:
+		return model.Errorf(model.ErrInvalidArgument, "unknown direction %q, expected %s or %s", f.Direction, model.DirectionIn, model.DirectionOut)
+	}
+
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return model.Errorf(model.ErrInvalidArgument, "from must be before to")
+	}
+	if f.MinAmount != nil && f.MinAmount.IsNegative() {
+		return model.Errorf(model.ErrInvalidArgument, "min_amount must not be negative")
+	}
+	if f.MinAmount != nil && f.MaxAmount != nil && f.MinAmount.GreaterThan(*f.MaxAmount) {
+		return model.Errorf(model.ErrInvalidArgument, "min_amount must not be greater than max_amount")
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = defaultPageSize
+	}
+	f.Limit = min(f.Limit, maxPageSize)
+	return nil
+}
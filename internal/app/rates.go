@@ -0,0 +1,344 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+const defaultSnapshotInterval = time.Hour
+
+// ConvertBalance converts the balance of a wallet to the currency by its ISO 4217 code
+// at the current rates, or at the stored rates valid at the time at.
+func (a *Avitotech) ConvertBalance(ctx context.Context, b *model.Balance, currency string,
+	at *time.Time,
+) (_ *model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.ConvertBalance")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	currency, err = currencyCode(currency)
+	if err != nil {
+		return nil, err
+	}
+	from, err := walletCurrency(b.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, err := a.rate(ctx, from, currency, at)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := *b
+	ans.Currency = currency
+	ans.Amount = b.Amount.Mul(rate)
+	ans.Reserved = b.Reserved.Mul(rate)
+	return &ans, nil
+}
+
+// convertTransactions fills the amounts of the transactions in the currency at the rates of their dates.
+// The transactions made before the first stored rate stay without them.
+func (a *Avitotech) convertTransactions(ctx context.Context, ts []model.Transaction, currency string) error {
+	currency, err := currencyCode(currency)
+	if err != nil {
+		return err
+	}
+
+	rates := make(map[string]*decimal.Decimal)
+	for i := range ts {
+		from, err := walletCurrency(ts[i].Currency)
+		if err != nil {
+			return err
+		}
+		key := from + " " + ts[i].Date
+		rate, ok := rates[key]
+		if !ok {
+			date, err := time.Parse(time.RFC3339Nano, ts[i].Date)
+			if err != nil {
+				return fmt.Errorf("wrong date of transaction %d: %w", ts[i].ID, err)
+			}
+			r, err := a.rate(ctx, from, currency, &date)
+			switch {
+			case err == nil:
+				rate = &r
+			case !errors.Is(err, model.ErrNotFound):
+				return err
+			}
+			rates[key] = rate
+		}
+
+		ts[i].ConvertedCurrency = currency
+		if rate != nil {
+			amount := ts[i].Amount.Mul(*rate)
+			ts[i].ConvertedAmount = &amount
+		}
+	}
+	return nil
+}
+
+// rate returns the price of one unit of from in units of to at the current provider rates,
+// or at the stored rates valid at the time at.
+func (a *Avitotech) rate(ctx context.Context, from, to string, at *time.Time) (decimal.Decimal, error) {
+	if at == nil {
+		rates, err := a.rates.Rates(ctx)
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("%w: failed to get rates: %w", model.ErrUnavailable, err)
+		}
+		return rates.Convert(decimal.NewFromInt(1), from, to)
+	}
+
+	fromPrice, err := a.storedPrice(ctx, from, *at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	toPrice, err := a.storedPrice(ctx, to, *at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return fromPrice.Div(toPrice), nil
+}
+
+// liveRate returns the current rate to move money at. The cache keeps the last rates while the provider fails,
+// rates older than the max rate age are only shown, the money waits for the provider.
+func (a *Avitotech) liveRate(ctx context.Context, from, to string) (decimal.Decimal, error) {
+	rates, err := a.rates.Rates(ctx)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: failed to get rates: %w", model.ErrUnavailable, err)
+	}
+	maxAge := a.conf.FX.MaxRateAge
+	if maxAge <= 0 {
+		maxAge = defaultMaxRateAge
+	}
+	if !rates.FetchedAt.IsZero() && time.Since(rates.FetchedAt) > maxAge {
+		return decimal.Zero, model.Errorf(model.ErrUnavailable, "exchange rates are older than %s", maxAge)
+	}
+	return rates.Convert(decimal.NewFromInt(1), from, to)
+}
+
+// storedPrice returns the price of one unit of the currency in rubles valid at the time.
+func (a *Avitotech) storedPrice(ctx context.Context, currency string, at time.Time) (decimal.Decimal, error) {
+	if currency == model.CurrencyRUB {
+		return decimal.NewFromInt(1), nil
+	}
+	rate, err := a.storage.GetExchangeRate(ctx, currency, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return rate.Rate, nil
+}
+
+// GetExchangeRates returns the rates of all currencies valid at the time, now by default.
+func (a *Avitotech) GetExchangeRates(ctx context.Context, at *time.Time) (_ []model.ExchangeRate, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetExchangeRates")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if at == nil {
+		now := time.Now()
+		at = &now
+	}
+	ans, err := a.storage.ListExchangeRates(ctx, *at)
+	if err != nil {
+		return nil, err
+	}
+	if ans == nil {
+		ans = []model.ExchangeRate{}
+	}
+	return ans, nil
+}
+
+// AddExchangeRate stores a manual rate, it overrides the provider rates from ValidFrom, now by default,
+// until ValidTo if it is set.
+func (a *Avitotech) AddExchangeRate(ctx context.Context, r *model.ExchangeRate) (_ *model.ExchangeRate, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.AddExchangeRate")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	currency, err := currencyCode(r.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if currency == model.CurrencyRUB {
+		return nil, model.Errorf(model.ErrInvalidArgument, "rate of %s can't be changed", model.CurrencyRUB)
+	}
+	if !r.Rate.IsPositive() {
+		return nil, model.Errorf(model.ErrInvalidArgument, "rate must be greater than zero")
+	}
+
+	rate := *r
+	rate.Currency = currency
+	rate.Source = model.RateSourceManual
+	if rate.ValidFrom.IsZero() {
+		rate.ValidFrom = time.Now()
+	}
+	if rate.ValidTo != nil && !rate.ValidTo.After(rate.ValidFrom) {
+		return nil, model.Errorf(model.ErrInvalidArgument, "valid_to must be after valid_from")
+	}
+
+	ans, err := a.storage.SaveExchangeRates(ctx, []model.ExchangeRate{rate})
+	if err != nil {
+		return nil, err
+	}
+	return &ans[0], nil
+}
+
+// snapshotRates periodically stores the provider rates until ctx is done,
+// so amounts can be converted at the rates of past dates.
+func (a *Avitotech) snapshotRates(ctx context.Context) error {
+	interval := a.conf.Rates.SnapshotInterval
+	if interval <= 0 {
+		interval = defaultSnapshotInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.saveRates(ctx); err != nil {
+			a.log.Errorf("failed to save exchange rates:%v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Avitotech) saveRates(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rates, err := a.rates.Rates(ctx)
+	if err != nil {
+		return err
+	}
+	// the cache keeps answering with the last rates while the provider fails,
+	// they are stored once as of their fetch, not as fresh rates of every snapshot
+	validFrom := rates.FetchedAt
+	if validFrom.IsZero() {
+		validFrom = time.Now()
+	} else if !validFrom.After(a.snapshotAt) {
+		a.log.Debugf("exchange rates are not refreshed since %s\n", a.snapshotAt.Format(time.RFC3339))
+		return nil
+	}
+
+	codes := []string{rates.Base}
+	for code := range rates.Rates {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	snapshot := make([]model.ExchangeRate, 0, len(codes))
+	for i, code := range codes {
+		if code == model.CurrencyRUB || (i > 0 && code == codes[i-1]) {
+			continue
+		}
+		price, err := rates.Convert(decimal.NewFromInt(1), code, model.CurrencyRUB)
+		if err != nil {
+			return err
+		}
+		snapshot = append(snapshot, model.ExchangeRate{
+			Currency:  code,
+			Rate:      price,
+			Source:    model.RateSourceProvider,
+			ValidFrom: validFrom,
+		})
+	}
+
+	if _, err = a.storage.SaveExchangeRates(ctx, snapshot); err != nil {
+		return err
+	}
+	a.snapshotAt = rates.FetchedAt
+	a.log.Debugf("saved %d exchange rates\n", len(snapshot))
+	return nil
+}
+
+// currencyCode normalizes the ISO 4217 code of the currency.
+func currencyCode(currency string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	if len(code) != 3 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", model.Errorf(model.ErrInvalidArgument, "unknown currency %q, expected ISO 4217 code", currency)
+	}
+	return code, nil
+}
+
+// exchange fills the amount the recipient of the transfer gets. Money of different currencies
+// is exchanged at the current rate, the recipient gets it less the spread.
+func (a *Avitotech) exchange(ctx context.Context, t *model.Transfer) error {
+	if t.Currency == t.ToCurrency {
+		t.ToAmount, t.Rate, t.Spread = t.Amount, nil, nil
+		return nil
+	}
+
+	rate, err := a.liveRate(ctx, t.Currency, t.ToCurrency)
+	if err != nil {
+		return err
+	}
+	spread := a.conf.FX.Spread
+
+	t.ToAmount = t.Amount.Mul(rate).Mul(decimal.NewFromInt(1).Sub(spread)).RoundDown(2)
+	if !t.ToAmount.IsPositive() {
+		return model.Errorf(model.ErrInvalidAmount, "amount is too small to exchange")
+	}
+	t.Rate, t.Spread = &rate, &spread
+	return nil
+}
+
+// CreateQuote locks the current ruble price of q.Amount in q.Currency for a purchase of q.UserID.
+// The price includes the spread and is rounded up to kopecks, a debit with the quote charges it.
+func (a *Avitotech) CreateQuote(ctx context.Context, q *model.Quote) (_ *model.Quote, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.CreateQuote")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if q.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "amount must be greater than zero")
+	}
+	currency, err := currencyCode(q.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if currency == model.CurrencyRUB {
+		return nil, model.Errorf(model.ErrInvalidArgument, "quotes are made for currencies other than %s",
+			model.CurrencyRUB)
+	}
+
+	rate, err := a.liveRate(ctx, currency, model.CurrencyRUB)
+	if err != nil {
+		return nil, err
+	}
+	spread := a.conf.FX.Spread
+
+	quote := *q
+	quote.Currency = currency
+	quote.Rate = rate
+	quote.Spread = spread
+	quote.QuotedAmount = q.Amount.Mul(rate).Mul(decimal.NewFromInt(1).Add(spread)).RoundUp(2)
+
+	ttl := a.conf.FX.QuoteTTL
+	if ttl <= 0 {
+		ttl = defaultQuoteTTL
+	}
+	return a.storage.CreateQuote(ctx, &quote, ttl)
+}
+
+// walletCurrency normalizes the currency of a wallet, rubles by default.
+func walletCurrency(currency string) (string, error) {
+	if currency == "" {
+		return model.CurrencyRUB, nil
+	}
+	return currencyCode(currency)
+}
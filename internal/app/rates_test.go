@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/server/mocks"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type stubRates struct {
+	rates *model.Rates
+}
+
+func (p *stubRates) Rates(_ context.Context) (*model.Rates, error) {
+	return p.rates, nil
+}
+
+// ratesStorage keeps the saved exchange rates, the other methods of Storage aren't used.
+type ratesStorage struct {
+	Storage
+	saved []model.ExchangeRate
+}
+
+func (s *ratesStorage) SaveExchangeRates(_ context.Context, rates []model.ExchangeRate) ([]model.ExchangeRate, error) {
+	s.saved = append(s.saved, rates...)
+	return rates, nil
+}
+
+func TestAvitotech_SaveRates(t *testing.T) {
+	log := mocks.NewLogger(t)
+	log.On("Debugf", mock.Anything, mock.Anything).Maybe()
+	fetchedAt := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	provider := &stubRates{rates: &model.Rates{
+		Base:      "EUR",
+		Rates:     map[string]decimal.Decimal{"RUB": decimal.NewFromInt(100)},
+		FetchedAt: fetchedAt,
+	}}
+	storage := &ratesStorage{}
+	a := &Avitotech{log: log, storage: storage, rates: provider}
+
+	require.NoError(t, a.saveRates(context.Background()))
+	require.Len(t, storage.saved, 1)
+	require.Equal(t, "EUR", storage.saved[0].Currency)
+	require.Equal(t, fetchedAt, storage.saved[0].ValidFrom)
+
+	// the cache answers with the same rates while the provider is down, they aren't history
+	require.NoError(t, a.saveRates(context.Background()))
+	require.Len(t, storage.saved, 1)
+
+	provider.rates = &model.Rates{
+		Base:      "EUR",
+		Rates:     map[string]decimal.Decimal{"RUB": decimal.NewFromInt(101)},
+		FetchedAt: fetchedAt.Add(time.Hour),
+	}
+	require.NoError(t, a.saveRates(context.Background()))
+	require.Len(t, storage.saved, 2)
+	require.Equal(t, fetchedAt.Add(time.Hour), storage.saved[1].ValidFrom)
+}
+
+func TestAvitotech_ExchangeStaleRates(t *testing.T) {
+	provider := &stubRates{rates: &model.Rates{
+		Base:      "EUR",
+		Rates:     map[string]decimal.Decimal{"RUB": decimal.NewFromInt(100)},
+		FetchedAt: time.Now().Add(-time.Hour),
+	}}
+	a := &Avitotech{rates: provider}
+	a.conf.FX.MaxRateAge = 2 * time.Hour
+
+	transfer := &model.Transfer{Amount: decimal.NewFromInt(2), Currency: "EUR", ToCurrency: model.CurrencyRUB}
+	require.NoError(t, a.exchange(context.Background(), transfer))
+	require.True(t, transfer.ToAmount.Equal(decimal.NewFromInt(200)), transfer.ToAmount.String())
+
+	// the cache still has the rates hours after the provider went down, money isn't moved at them
+	provider.rates.FetchedAt = time.Now().Add(-3 * time.Hour)
+	err := a.exchange(context.Background(), transfer)
+	require.ErrorIs(t, err, model.ErrUnavailable)
+}
+
+func TestAvitotech_CreateQuoteStaleRates(t *testing.T) {
+	provider := &stubRates{rates: &model.Rates{
+		Base:      "EUR",
+		Rates:     map[string]decimal.Decimal{"RUB": decimal.NewFromInt(100)},
+		FetchedAt: time.Now().Add(-3 * time.Hour),
+	}}
+	a := &Avitotech{rates: provider}
+
+	_, err := a.CreateQuote(context.Background(), &model.Quote{UserID: 1, Amount: decimal.NewFromInt(10), Currency: "EUR"})
+	require.ErrorIs(t, err, model.ErrUnavailable)
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+// jwtHeader is the only header of the tokens issued and accepted by the service.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// tokenClaims are the claims of an access token, Subject is the ID of the user.
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// signToken returns the JWT of the user signed with HMAC-SHA256.
+func signToken(secret []byte, userID int64, now time.Time, ttl time.Duration) (string, error) {
+	claims, err := json.Marshal(tokenClaims{
+		Subject:   strconv.FormatInt(userID, 10),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return unsigned + "." + tokenSignature(secret, unsigned), nil
+}
+
+// parseToken returns the ID of the user of the JWT if its signature is valid and it isn't expired.
+func parseToken(secret []byte, token string, now time.Time) (int64, error) {
+	errInvalid := model.Errorf(model.ErrUnauthorized, "invalid access token")
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return 0, errInvalid
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(tokenSignature(secret, parts[0]+"."+parts[1]))) {
+		return 0, errInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, errInvalid
+	}
+	var claims tokenClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return 0, errInvalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return 0, model.Errorf(model.ErrUnauthorized, "access token is expired")
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, errInvalid
+	}
+	return userID, nil
+}
+
+func tokenSignature(secret []byte, unsigned string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	token, err := signToken(secret, 7, now, 15*time.Minute)
+	require.NoError(t, err)
+
+	userID, err := parseToken(secret, token, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), userID)
+
+	parts := strings.Split(token, ".")
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+		now    time.Time
+	}{
+		{name: "Expired", secret: secret, token: token, now: now.Add(15 * time.Minute)},
+		{name: "Other secret", secret: []byte("other"), token: token, now: now},
+		{name: "Unsigned", secret: secret, token: parts[0] + "." + parts[1] + ".", now: now},
+		{
+			name:   "Algorithm none",
+			secret: secret,
+			token:  "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + ".",
+			now:    now,
+		},
+		{name: "Garbage", secret: secret, token: "token", now: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseToken(tt.secret, tt.token, tt.now)
+			require.True(t, errors.Is(err, model.ErrUnauthorized), "got %v", err)
+		})
+	}
+}
+
+func TestSigningSecret(t *testing.T) {
+	keyHash := hashSecret("ak_key")
+	a := &Avitotech{signingPepper: []byte("pepper")}
+
+	secret, err := a.signingSecret(keyHash)
+	require.NoError(t, err)
+	require.Len(t, secret, 64)
+	require.NotEqual(t, keyHash, secret)
+
+	again, err := a.signingSecret(keyHash)
+	require.NoError(t, err)
+	require.Equal(t, secret, again)
+
+	other, err := (&Avitotech{signingPepper: []byte("other")}).signingSecret(keyHash)
+	require.NoError(t, err)
+	require.NotEqual(t, secret, other)
+}
+
+// usersStorage knows the users that aren't deleted, the other methods of Storage aren't used.
+type usersStorage struct {
+	Storage
+	users map[int64]bool
+}
+
+func (s *usersStorage) GetUser(_ context.Context, id int64) (*model.User, error) {
+	if !s.users[id] {
+		return nil, model.Errorf(model.ErrNotFound, "user with ID %d does not exist", id)
+	}
+	return &model.User{ID: id}, nil
+}
+
+func TestAvitotech_AuthenticateToken(t *testing.T) {
+	secret := []byte("secret")
+	a := &Avitotech{jwtSecret: secret, storage: &usersStorage{users: map[int64]bool{7: true}}}
+
+	token, err := signToken(secret, 7, time.Now(), time.Minute)
+	require.NoError(t, err)
+	userID, err := a.AuthenticateToken(context.Background(), token)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), userID)
+
+	// the token of a deleted user is still signed and not expired
+	token, err = signToken(secret, 8, time.Now(), time.Minute)
+	require.NoError(t, err)
+	_, err = a.AuthenticateToken(context.Background(), token)
+	require.ErrorIs(t, err, model.ErrUnauthorized)
+}
+
+func TestAvitotech_SpanError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	a := &Avitotech{jwtSecret: []byte("secret"), storage: &usersStorage{}}
+	_, err := a.AuthenticateToken(context.Background(), "token")
+	require.ErrorIs(t, err, model.ErrUnauthorized)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "Avitotech.AuthenticateToken", spans[0].Name())
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Len(t, spans[0].Events(), 1)
+	require.Equal(t, "exception", spans[0].Events()[0].Name)
+}
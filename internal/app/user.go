@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	refreshTokenPrefix = "rt_"
+	defaultAccessTTL   = 15 * time.Minute
+	defaultRefreshTTL  = 30 * 24 * time.Hour
+	minPasswordLength  = 8
+	// bcrypt ignores the bytes after 72
+	maxPasswordLength = 72
+)
+
+// dummyPasswordHash is compared with the password of an unknown user,
+// so the answer doesn't tell the existing logins by its time.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// Register creates the user with the bcrypt hash of the password.
+func (a *Avitotech) Register(ctx context.Context, u *model.User) (_ *model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Register")
+	defer endSpan(span, &err)
+	return a.createUser(ctx, u, true)
+}
+
+// CreateUser creates the user on behalf of a service. The password is optional,
+// a user without it can't log in.
+func (a *Avitotech) CreateUser(ctx context.Context, u *model.User) (_ *model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.CreateUser")
+	defer endSpan(span, &err)
+	if err := authorizeService(ctx); err != nil {
+		return nil, err
+	}
+	return a.createUser(ctx, u, false)
+}
+
+func (a *Avitotech) createUser(ctx context.Context, u *model.User, passwordRequired bool) (*model.User, error) {
+	user := *u
+	user.Name = strings.TrimSpace(u.Name)
+	user.Username = strings.TrimSpace(u.Username)
+	user.Email = strings.TrimSpace(u.Email)
+	switch {
+	case user.Name == "" || user.Username == "":
+		return nil, model.Errorf(model.ErrInvalidArgument, "name and username are required")
+	case !validEmail(user.Email):
+		return nil, model.Errorf(model.ErrInvalidArgument, "wrong email %q", u.Email)
+	case (passwordRequired || u.Password != "") &&
+		(len(u.Password) < minPasswordLength || len(u.Password) > maxPasswordLength):
+		return nil, model.Errorf(model.ErrInvalidArgument, "password must be from %d to %d bytes long",
+			minPasswordLength, maxPasswordLength)
+	}
+
+	user.Password, user.PasswordHash = "", ""
+	if u.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = string(hash)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.CreateUser(ctx, &user)
+}
+
+// GetUser returns the profile of the user.
+func (a *Avitotech) GetUser(ctx context.Context, id int64) (_ *model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.GetUser")
+	defer endSpan(span, &err)
+	if err := authorizeUser(ctx, id); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.GetUser(ctx, id)
+}
+
+// UpdateUser changes the name, the username or the email of the user.
+func (a *Avitotech) UpdateUser(ctx context.Context, id int64, u *model.UserUpdate) (_ *model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.UpdateUser")
+	defer endSpan(span, &err)
+	if err := authorizeUser(ctx, id); err != nil {
+		return nil, err
+	}
+
+	update := &model.UserUpdate{Name: trimField(u.Name), Username: trimField(u.Username), Email: trimField(u.Email)}
+	switch {
+	case update.Name == nil && update.Username == nil && update.Email == nil:
+		return nil, model.Errorf(model.ErrInvalidArgument, "nothing to update")
+	case update.Name != nil && *update.Name == "" || update.Username != nil && *update.Username == "":
+		return nil, model.Errorf(model.ErrInvalidArgument, "name and username can't be empty")
+	case update.Email != nil && !validEmail(*update.Email):
+		return nil, model.Errorf(model.ErrInvalidArgument, "wrong email %q", *u.Email)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.UpdateUser(ctx, id, update)
+}
+
+// SearchUsers finds users by the beginning of the username and the email, only services search users.
+func (a *Avitotech) SearchUsers(ctx context.Context, f *model.UserFilter) (_ []model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.SearchUsers")
+	defer endSpan(span, &err)
+	if err := authorizeService(ctx); err != nil {
+		return nil, err
+	}
+	filter := *f
+	filter.Username, filter.Email = strings.TrimSpace(f.Username), strings.TrimSpace(f.Email)
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	filter.Limit = min(filter.Limit, maxPageSize)
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.SearchUsers(ctx, &filter)
+}
+
+// DeleteUser deletes the user softly. Its balances and history are kept,
+// but it can't log in or move money any more.
+func (a *Avitotech) DeleteUser(ctx context.Context, id int64) (_ *model.User, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.DeleteUser")
+	defer endSpan(span, &err)
+	if err := authorizeUser(ctx, id); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return a.storage.DeleteUser(ctx, id)
+}
+
+// Login issues tokens to the user with the username or the email and the password.
+func (a *Avitotech) Login(ctx context.Context, c *model.Credentials) (_ *model.Tokens, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Login")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	user, err := a.storage.FindUser(ctx, strings.TrimSpace(c.Login))
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		return nil, err
+	}
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+	if err = bcrypt.CompareHashAndPassword(hash, []byte(c.Password)); err != nil || user == nil {
+		return nil, model.Errorf(model.ErrUnauthorized, "wrong login or password")
+	}
+	return a.issueTokens(ctx, user.ID)
+}
+
+// Refresh exchanges the refresh token for new tokens, the old one can't be used again.
+func (a *Avitotech) Refresh(ctx context.Context, refreshToken string) (_ *model.Tokens, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.Refresh")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	userID, err := a.storage.UseRefreshToken(ctx, hashSecret(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(ctx, userID)
+}
+
+// AuthenticateToken returns the ID of the user of the access token. Tokens of deleted users are rejected
+// before they expire.
+func (a *Avitotech) AuthenticateToken(ctx context.Context, token string) (_ int64, err error) {
+	ctx, span := tracer.Start(ctx, "Avitotech.AuthenticateToken")
+	defer endSpan(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	userID, err := parseToken(a.jwtSecret, token, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if _, err = a.storage.GetUser(ctx, userID); err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			return 0, model.Errorf(model.ErrUnauthorized, "user of the access token is deleted")
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+func (a *Avitotech) issueTokens(ctx context.Context, userID int64) (*model.Tokens, error) {
+	accessTTL := a.conf.Auth.AccessTTL
+	if accessTTL <= 0 {
+		accessTTL = defaultAccessTTL
+	}
+	refreshTTL := a.conf.Auth.RefreshTTL
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTTL
+	}
+
+	now := time.Now()
+	access, err := signToken(a.jwtSecret, userID, now, accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+	refresh := refreshTokenPrefix + hex.EncodeToString(b)
+	if err = a.storage.SaveRefreshToken(ctx, userID, hashSecret(refresh), now.Add(refreshTTL)); err != nil {
+		return nil, err
+	}
+
+	return &model.Tokens{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// authorizeUser forbids end users to act for other users, services act for anyone.
+func authorizeUser(ctx context.Context, userID int64) error {
+	if id, ok := model.UserFromContext(ctx); ok && id != userID {
+		return model.Errorf(model.ErrForbidden, "access to user %d is forbidden", userID)
+	}
+	return nil
+}
+
+// authorizeService forbids end users the operations of services.
+func authorizeService(ctx context.Context) error {
+	if _, ok := model.UserFromContext(ctx); ok {
+		return model.Errorf(model.ErrForbidden, "access is forbidden to users")
+	}
+	return nil
+}
+
+func trimField(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*s)
+	return &t
+}
+
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
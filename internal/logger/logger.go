@@ -1,12 +1,18 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/server"
 )
 
 const (
@@ -16,38 +22,99 @@ const (
 	LevelDebug
 )
 
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// redacted replaces the values of the sensitive fields.
+const redacted = "[REDACTED]"
+
 var ErrLogLevel = errors.New("unrecognized log_level")
 
+// sensitiveKeys are the fields that are never written as they are,
+// keys are compared in lower case with "-" as "_".
+var sensitiveKeys = []string{
+	"password", "password_hash", "token", "access_token", "refresh_token", "secret", "jwt_secret",
+	"api_key", "key", "authorization", "signature",
+}
+
 type Conf struct {
 	Level string `toml:"level"`
+	// Format is "text" for lines like "INFO:message key=value" or "json" for a JSON object per line.
+	Format string `toml:"format"`
+	// Redact is the fields redacted besides the passwords, tokens, secrets, keys and signatures.
+	Redact []string `toml:"redact"`
 }
 
 type Logger struct {
 	level  int
+	json   bool
 	writer io.Writer
 	mu     *sync.Mutex
+	// fields are the keys and values of the child logger written on every line.
+	fields []interface{}
+	redact map[string]bool
+	now    func() time.Time
 }
 
+// NewLogger returns the text logger of the level.
 func NewLogger(level string, writer io.Writer) *Logger {
-	switch strings.ToUpper(level) {
+	return New(Conf{Level: level}, writer)
+}
+
+// New returns the logger of the config, it exits on an unknown level or format.
+func New(conf Conf, writer io.Writer) *Logger {
+	l := &Logger{mu: &sync.Mutex{}, writer: writer, redact: map[string]bool{}, now: time.Now}
+	switch strings.ToUpper(conf.Level) {
 	case "ERROR":
-		return &Logger{level: LevelError, mu: &sync.Mutex{}, writer: writer}
+		l.level = LevelError
 	case "WARN":
-		return &Logger{level: LevelWarn, mu: &sync.Mutex{}, writer: writer}
+		l.level = LevelWarn
 	case "INFO":
-		return &Logger{level: LevelInfo, mu: &sync.Mutex{}, writer: writer}
+		l.level = LevelInfo
 	case "DEBUG":
-		return &Logger{level: LevelDebug, mu: &sync.Mutex{}, writer: writer}
+		l.level = LevelDebug
 	default:
 		fmt.Fprintln(os.Stderr, "unrecognized log_level")
 		os.Exit(1)
 	}
-	return nil
+	switch strings.ToLower(conf.Format) {
+	case "", FormatText:
+	case FormatJSON:
+		l.json = true
+	default:
+		fmt.Fprintln(os.Stderr, "unrecognized log format")
+		os.Exit(1)
+	}
+	for _, key := range append(sensitiveKeys, conf.Redact...) {
+		l.redact[normalizeKey(key)] = true
+	}
+	return l
 }
 
-func (l *Logger) printf(format string, a ...interface{}) {
+// With returns the child logger writing the keys and values after the fields of l,
+// a key without a value is written with an empty one.
+func (l *Logger) With(keyvals ...interface{}) server.Logger {
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "")
+	}
+	child := *l
+	child.fields = append(append(make([]interface{}, 0, len(l.fields)+len(keyvals)), l.fields...), keyvals...)
+	return &child
+}
+
+func (l *Logger) printf(level, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	var line []byte
+	if l.json {
+		line = l.jsonLine(level, msg)
+	} else {
+		line = l.textLine(level, msg)
+	}
+
 	l.mu.Lock()
-	_, err := fmt.Fprintf(l.writer, format, a...)
+	_, err := l.writer.Write(line)
 	l.mu.Unlock()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Fatal: Fprintf : %v", err)
@@ -55,31 +122,102 @@ func (l *Logger) printf(format string, a ...interface{}) {
 	}
 }
 
+// textLine returns "LEVEL:msg" with the fields before the trailing newline of msg.
+func (l *Logger) textLine(level, msg string) []byte {
+	var b bytes.Buffer
+	b.WriteString(level)
+	b.WriteByte(':')
+	body, newline := strings.CutSuffix(msg, "\n")
+	b.WriteString(body)
+	for i := 0; i < len(l.fields); i += 2 {
+		key := fmt.Sprint(l.fields[i])
+		b.WriteByte(' ')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(quote(fmt.Sprint(l.value(key, l.fields[i+1]))))
+	}
+	if newline {
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// jsonLine returns the JSON object of the message with the time, the level and the fields.
+func (l *Logger) jsonLine(level, msg string) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, l.now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, strings.ToLower(level))
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, strings.TrimSuffix(msg, "\n"))
+	for i := 0; i < len(l.fields); i += 2 {
+		key := fmt.Sprint(l.fields[i])
+		b.WriteByte(',')
+		writeJSON(&b, key)
+		b.WriteByte(':')
+		writeJSON(&b, l.value(key, l.fields[i+1]))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// value returns the value of the field as it is written, errors are written by their messages.
+func (l *Logger) value(key string, v interface{}) interface{} {
+	if l.redact[normalizeKey(key)] {
+		return redacted
+	}
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return v
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// quote quotes the text values that can't be read back without quotes.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
 func (l *Logger) Fatalf(format string, a ...interface{}) {
-	l.printf("Fatal:"+format, a)
+	l.printf("Fatal", format, a...)
 	os.Exit(1)
 }
 
 func (l *Logger) Errorf(format string, a ...interface{}) {
 	if l.level >= LevelError {
-		l.printf("ERROR:"+format, a...)
+		l.printf("ERROR", format, a...)
 	}
 }
 
 func (l *Logger) Warningf(format string, a ...interface{}) {
 	if l.level >= LevelWarn {
-		l.printf("WARN:"+format, a...)
+		l.printf("WARN", format, a...)
 	}
 }
 
 func (l *Logger) Infof(format string, a ...interface{}) {
 	if l.level >= LevelInfo {
-		l.printf("INFO:"+format, a...)
+		l.printf("INFO", format, a...)
 	}
 }
 
 func (l *Logger) Debugf(format string, a ...interface{}) {
 	if l.level >= LevelDebug {
-		l.printf("DEBUG:"+format, a...)
+		l.printf("DEBUG", format, a...)
 	}
 }
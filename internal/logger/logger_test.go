@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -86,16 +87,65 @@ func TestLogger(t *testing.T) {
 	}
 }
 
+func TestLogger_With(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Conf{Level: "INFO"}, &buf)
+	child := l.With("request_id", "req-1", "route", "/api/v1/users/{id}")
+
+	child.With("user_id", 7, "Password", "qwerty").Infof("user %s logged in\n", "alice")
+	l.Infof("no fields\n")
+	child.Warningf("retry in %d s", 5)
+	require.Equal(t, `INFO:user alice logged in request_id=req-1 route=/api/v1/users/{id} user_id=7 Password=[REDACTED]
+INFO:no fields
+WARN:retry in 5 s request_id=req-1 route=/api/v1/users/{id}`, buf.String())
+}
+
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(Conf{Level: "DEBUG", Format: FormatJSON, Redact: []string{"email"}}, &buf)
+	l.now = func() time.Time { return time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC) }
+
+	l.With("request_id", "req-1", "error", errors.New("no rows"), "access-token", "eyJ", "email", "a@b.c",
+		"msg text", "with \"quotes\"", "odd").Errorf("Can't %s:%v\n", "get balance", "no rows")
+	require.Equal(t, `{"time":"2024-05-01T10:00:00Z","level":"error","msg":"Can't get balance:no rows",`+
+		`"request_id":"req-1","error":"no rows","access-token":"[REDACTED]","email":"[REDACTED]",`+
+		`"msg text":"with \"quotes\"","odd":""}`+"\n", buf.String())
+}
+
+func TestLogger_TextQuoting(t *testing.T) {
+	var buf bytes.Buffer
+	New(Conf{Level: "DEBUG"}, &buf).With("agent", "curl/8.0 (linux)", "empty", "").Debugf("served\n")
+	require.Equal(t, `DEBUG:served agent="curl/8.0 (linux)" empty=""`+"\n", buf.String())
+}
+
 func TestFatalf(t *testing.T) {
 	if os.Getenv("BE_CRASHER") == "1" {
 		var b bytes.Buffer
 		_ = NewLogger("WRONG DB TYPE", &b)
 		return
 	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestFatalf")
-	cmd.Env = append(os.Environ(), "BE_CRASHER=1")
-	err := cmd.Run()
+	if os.Getenv("BE_CRASHER") == "2" {
+		var b bytes.Buffer
+		_ = New(Conf{Level: "INFO", Format: "xml"}, &b)
+		return
+	}
+	if os.Getenv("BE_CRASHER") == "3" {
+		NewLogger("ERROR", os.Stdout).Fatalf("can't listen on %s:%d\n", "localhost", 8090)
+		return
+	}
+	for _, crasher := range []string{"1", "2"} {
+		cmd := exec.Command(os.Args[0], "-test.run=TestFatalf")
+		cmd.Env = append(os.Environ(), "BE_CRASHER="+crasher)
+		err := cmd.Run()
+
+		var e *exec.ExitError
+		require.True(t, err != nil && errors.As(err, &e), "process ran with err %v, want exit status 1", err)
+	}
 
+	cmd := exec.Command(os.Args[0], "-test.run=TestFatalf")
+	cmd.Env = append(os.Environ(), "BE_CRASHER=3")
+	out, err := cmd.Output()
 	var e *exec.ExitError
 	require.True(t, err != nil && errors.As(err, &e), "process ran with err %v, want exit status 1", err)
+	require.Equal(t, "Fatal:can't listen on localhost:8090\n", string(out))
 }
@@ -0,0 +1,251 @@
+// Package metrics keeps counters and histograms of the service and writes them
+// in the text exposition format of Prometheus.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of the histogram buckets of durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry of the metrics of the service, served by Handler.
+var Default = NewRegistry()
+
+// Registry is a set of metrics with unique names.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// NewCounter registers a counter in the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewHistogram registers a histogram in the default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Handler serves the metrics of the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		Default.Write(w)
+	})
+}
+
+// NewCounter registers a counter with the names of its labels, a name may be registered once.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name: name, help: help, kind: "counter", labels: labels}, values: map[string]*counterValue{}}
+	r.register(name, c)
+	return c
+}
+
+// NewHistogram registers a histogram with the ascending upper bounds of its buckets
+// and the names of its labels, a name may be registered once.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of histogram %s are not sorted", name))
+	}
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		values:  map[string]*histogramValue{},
+	}
+	r.register(name, h)
+	return h
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in the order of their registration.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// desc describes a metric, its series are told apart by the values of the labels.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// key returns the key of the series with the label values.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.kind)
+}
+
+// writeSample writes a line of the series, the histograms add the le label of the bucket.
+func (d *desc) writeSample(w io.Writer, suffix string, values []string, le string, v float64) {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escape.Replace(value)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	labels := ""
+	if len(pairs) > 0 {
+		labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(w, "%s%s%s %s\n", d.name, suffix, labels, formatFloat(v))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of the series in a stable order for the output.
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a sum that only grows, like the number of requests.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// Inc adds one to the series of the label values.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v to the series of the label values, v must not be negative.
+func (c *Counter) Add(v float64, labels ...string) {
+	key := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: slices.Clone(labels)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.values) {
+		cv := c.values[key]
+		c.writeSample(w, "", cv.labels, "", cv.value)
+	}
+}
+
+// Histogram counts observations like durations in buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	// counts are the observations of each bucket only, they are summed up on the output
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds v to the series of the label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{labels: slices.Clone(labels), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	// the first bucket with the upper bound not less than v, values above all bounds go only to +Inf
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Since observes the seconds passed from start.
+func (h *Histogram) Since(start time.Time, labels ...string) {
+	h.Observe(time.Since(start).Seconds(), labels...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.values) {
+		hv := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hv.counts[i]
+			h.writeSample(w, "_bucket", hv.labels, formatFloat(bound), float64(cumulative))
+		}
+		h.writeSample(w, "_bucket", hv.labels, "+Inf", float64(hv.count))
+		h.writeSample(w, "_sum", hv.labels, "", hv.sum)
+		h.writeSample(w, "_count", hv.labels, "", float64(hv.count))
+	}
+}
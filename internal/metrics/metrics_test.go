@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests by route.", "route", "status")
+	duration := r.NewHistogram("duration_seconds", "Duration of requests.", []float64{0.1, 1})
+	r.NewCounter("empty_total", "Nothing counted.")
+
+	requests.Inc("/b", "200")
+	requests.Inc("/a", "500")
+	requests.Add(2, "/b", "200")
+	requests.Inc(`/"c"`, "200")
+	duration.Observe(0.05)
+	duration.Observe(0.1)
+	duration.Observe(0.5)
+	duration.Observe(3)
+
+	var out strings.Builder
+	require.NoError(t, r.Write(&out))
+	require.Equal(t, `# HELP requests_total Requests by route.
+# TYPE requests_total counter
+requests_total{route="/\"c\"",status="200"} 1
+requests_total{route="/a",status="500"} 1
+requests_total{route="/b",status="200"} 3
+# HELP duration_seconds Duration of requests.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 2
+duration_seconds_bucket{le="1"} 3
+duration_seconds_bucket{le="+Inf"} 4
+duration_seconds_sum 3.65
+duration_seconds_count 4
+# HELP empty_total Nothing counted.
+# TYPE empty_total counter
+`, out.String())
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests.", "route")
+
+	require.Panics(t, func() { r.NewCounter("requests_total", "Again.") })
+	require.Panics(t, func() { c.Inc() })
+	require.Panics(t, func() { r.NewHistogram("duration_seconds", "Duration.", []float64{1, 0.1}) })
+}
+
+func TestHandler(t *testing.T) {
+	NewCounter("metrics_test_total", "Counter of the test.").Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, contentType, w.Header().Get("Content-Type"))
+	require.Contains(t, w.Body.String(), "\nmetrics_test_total 1\n")
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// Statuses of user accounts, they limit the money movement on the wallets of the user.
+const (
+	// AccountActive lets money in and out.
+	AccountActive = "active"
+	// AccountDebitFrozen lets money in, but not out.
+	AccountDebitFrozen = "debit_frozen"
+	// AccountFrozen stops all money movement.
+	AccountFrozen = "frozen"
+	// AccountClosed is final, a closed account is left with empty wallets.
+	AccountClosed = "closed"
+)
+
+// AccountStatuses lists the statuses set by admins, an account is closed only by AccountClosure.
+var AccountStatuses = []string{AccountActive, AccountDebitFrozen, AccountFrozen}
+
+// AccountStatusChange is a record of the audit trail of account statuses. APIKeyID is the service
+// that changed the status.
+type AccountStatusChange struct {
+	ID             int64     `json:"id" db:"id"`
+	UserID         int64     `json:"user_id" db:"user_id"`
+	Status         string    `json:"status" db:"status"`
+	PreviousStatus string    `json:"previous_status" db:"previous_status"`
+	Reason         string    `json:"reason" db:"reason"`
+	APIKeyID       *int64    `json:"api_key_id,omitempty" db:"api_key_id"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// AccountClosure closes the account of the user. The money left goes to the wallets of PayoutUserID
+// in the same currencies, without it the wallets must be empty.
+type AccountClosure struct {
+	UserID       int64  `json:"user_id"`
+	Reason       string `json:"reason"`
+	PayoutUserID *int64 `json:"payout_user_id,omitempty"`
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Scopes of API keys, each route requires one of them.
+const (
+	ScopeBalanceRead   = "balance:read"
+	ScopeBalanceCredit = "balance:credit"
+	ScopeBalanceDebit  = "balance:debit"
+	ScopeTransfer      = "transfer"
+	ScopeRatesWrite    = "rates:write"
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeAccountsAdmin = "accounts:admin"
+	ScopeAuditRead     = "audit:read"
+	ScopeMetricsRead   = "metrics:read"
+)
+
+// Scopes lists all known scopes.
+var Scopes = []string{
+	ScopeBalanceRead, ScopeBalanceCredit, ScopeBalanceDebit, ScopeTransfer, ScopeRatesWrite,
+	ScopeUsersRead, ScopeUsersWrite, ScopeAccountsAdmin, ScopeAuditRead, ScopeMetricsRead,
+}
+
+// APIKey identifies a service calling the API. Only the SHA-256 of the key is stored in KeyHash to look
+// the key up. Scopes are separated by spaces. SigningSecret signs requests of the key, it isn't stored
+// and is filled only when the key is created.
+type APIKey struct {
+	ID            int64      `json:"id" db:"id"`
+	Name          string     `json:"name" db:"name"`
+	KeyHash       string     `json:"-" db:"key_hash"`
+	Scopes        string     `json:"scopes" db:"scopes"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	SigningSecret string     `json:"-" db:"-"`
+}
+
+// HasScope tells whether the key grants the scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Fields(k.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// SignedRequest is a request signed by the HMAC-SHA256 of Payload with the key KeyID.
+// The signature is valid once per Nonce and only near Timestamp.
+type SignedRequest struct {
+	KeyID     int64
+	Timestamp time.Time
+	Nonce     string
+	Payload   []byte
+	Signature string
+}
+
+type callerKey struct{}
+
+// ContextWithCaller returns ctx carrying the key of the authenticated caller.
+func ContextWithCaller(ctx context.Context, k *APIKey) context.Context {
+	return context.WithValue(ctx, callerKey{}, k)
+}
+
+// CallerFromContext returns the key of the authenticated caller, nil if the request isn't authenticated.
+func CallerFromContext(ctx context.Context) *APIKey {
+	k, _ := ctx.Value(callerKey{}).(*APIKey)
+	return k
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// AuditResultOK is the result of successful calls, failed ones have the code of the error.
+const AuditResultOK = "ok"
+
+// AuditRecord is an entry of the append-only audit log of state-changing calls. The actor is the service
+// of APIKeyID or the end user ActorUserID. PayloadHash is the hex SHA-256 of the request body.
+// UserID, Balance and Currency are the resulting balance of the calls answering with one.
+type AuditRecord struct {
+	ID          int64            `json:"id" db:"id"`
+	APIKeyID    *int64           `json:"api_key_id,omitempty" db:"api_key_id"`
+	ActorUserID *int64           `json:"actor_user_id,omitempty" db:"actor_user_id"`
+	Route       string           `json:"route" db:"route"`
+	Path        string           `json:"path" db:"path"`
+	PayloadHash string           `json:"payload_hash" db:"payload_hash"`
+	Status      int              `json:"status" db:"status"`
+	Result      string           `json:"result" db:"result"`
+	UserID      *int64           `json:"user_id,omitempty" db:"user_id"`
+	Balance     *decimal.Decimal `json:"balance,omitempty" db:"balance"`
+	Currency    *string          `json:"currency,omitempty" db:"currency"`
+	RequestID   string           `json:"request_id" db:"request_id"`
+	CreatedAt   time.Time        `json:"created_at" db:"created_at"`
+}
+
+// AuditPage is a page of audit records, NextCursor is empty on the last page.
+type AuditPage struct {
+	Records    []AuditRecord `json:"records"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// AuditFilter selects a page of audit records from newest to oldest, zero fields match any record.
+// The page starts after the record AfterID.
+type AuditFilter struct {
+	APIKeyID    int64
+	ActorUserID int64
+	UserID      int64
+	Route       string
+	Result      string
+	RequestID   string
+	From        *time.Time
+	To          *time.Time
+	AfterID     int64
+	Limit       int
+}
@@ -2,8 +2,12 @@ package model
 
 import "github.com/shopspring/decimal"
 
+// Balance of a wallet of a user, a user has a wallet per currency. Amount is available for spending,
+// Reserved is held by reservations that are not captured or released yet.
 type Balance struct {
-	ID     int64           `json:"id" db:"id"`
-	UserID int64           `json:"user_id" db:"user_id"`
-	Amount decimal.Decimal `json:"amount" db:"amount"`
+	ID       int64           `json:"id" db:"id"`
+	UserID   int64           `json:"user_id" db:"user_id"`
+	Currency string          `json:"currency" db:"currency"`
+	Amount   decimal.Decimal `json:"amount" db:"amount"`
+	Reserved decimal.Decimal `json:"reserved" db:"reserved"`
 }
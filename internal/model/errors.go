@@ -0,0 +1,40 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of domain errors, match them with errors.Is.
+var (
+	ErrBadRequest        = errors.New("bad request")
+	ErrInvalidArgument   = errors.New("invalid argument")
+	ErrInvalidAmount     = errors.New("invalid amount")
+	ErrNotFound          = errors.New("not found")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrForbidden         = errors.New("forbidden")
+	ErrConflict          = errors.New("conflict")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrAccountFrozen     = errors.New("account frozen")
+	ErrUnavailable       = errors.New("service unavailable")
+)
+
+// Error is a domain error of one of the kinds above. Its message is meant for clients,
+// so it must not contain details of the infrastructure.
+type Error struct {
+	Kind    error
+	Message string
+}
+
+// Errorf returns a domain error of the kind with the formatted message.
+func Errorf(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// IdempotencyKey is a stored result of a request made with the Idempotency-Key header.
+// StatusCode is zero while the first request is still being processed.
+type IdempotencyKey struct {
+	Key         string    `db:"key"`
+	Fingerprint string    `db:"fingerprint"`
+	StatusCode  int       `db:"status_code"`
+	Response    []byte    `db:"response"`
+	CreatedAt   time.Time `db:"created_at"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+func (k *IdempotencyKey) Pending() bool {
+	return k.StatusCode == 0
+}
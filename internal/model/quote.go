@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Quote locks the ruble price of Amount in Currency for a purchase of the user until ExpiresAt.
+// QuotedAmount is Amount at Rate with the Spread part kept by the service, a debit with the quote
+// charges exactly it from the ruble wallet. A quote is used once.
+type Quote struct {
+	ID           int64           `json:"id" db:"id"`
+	UserID       int64           `json:"user_id" db:"user_id"`
+	Amount       decimal.Decimal `json:"amount" db:"amount"`
+	Currency     string          `json:"currency" db:"currency"`
+	Rate         decimal.Decimal `json:"rate" db:"rate"`
+	Spread       decimal.Decimal `json:"spread" db:"spread"`
+	QuotedAmount decimal.Decimal `json:"quoted_amount" db:"quoted_amount"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	ExpiresAt    time.Time       `json:"expires_at" db:"expires_at"`
+	UsedAt       *time.Time      `json:"used_at,omitempty" db:"used_at"`
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CurrencyRUB is the currency of balances.
+const CurrencyRUB = "RUB"
+
+// Rates of currencies by their ISO 4217 codes: one unit of Base costs Rates[code] units of code.
+// FetchedAt is when the provider answered with the rates, it is zero for the rates of a file that don't age.
+type Rates struct {
+	Base      string                     `json:"base"`
+	Date      string                     `json:"date,omitempty"`
+	Rates     map[string]decimal.Decimal `json:"rates"`
+	FetchedAt time.Time                  `json:"-"`
+}
+
+// Rate returns the price of one unit of Base in units of currency.
+func (r *Rates) Rate(currency string) (decimal.Decimal, bool) {
+	if strings.EqualFold(currency, r.Base) {
+		return decimal.NewFromInt(1), true
+	}
+	rate, ok := r.Rates[strings.ToUpper(currency)]
+	if !ok || !rate.IsPositive() {
+		return decimal.Zero, false
+	}
+	return rate, true
+}
+
+// Convert converts amount from one currency to another through the base currency.
+func (r *Rates) Convert(amount decimal.Decimal, from, to string) (decimal.Decimal, error) {
+	fromRate, ok := r.Rate(from)
+	if !ok {
+		return decimal.Zero, Errorf(ErrInvalidArgument, "unknown currency %q", from)
+	}
+	toRate, ok := r.Rate(to)
+	if !ok {
+		return decimal.Zero, Errorf(ErrInvalidArgument, "unknown currency %q", to)
+	}
+	return amount.Div(fromRate).Mul(toRate), nil
+}
+
+// Sources of exchange rates.
+const (
+	RateSourceProvider = "provider"
+	RateSourceManual   = "manual"
+)
+
+// ExchangeRate is the price of one unit of the currency in rubles since ValidFrom.
+// A provider rate is valid until the next one, a manual rate overrides them until ValidTo.
+type ExchangeRate struct {
+	ID        int64           `json:"id" db:"id"`
+	Currency  string          `json:"currency" db:"currency"`
+	Rate      decimal.Decimal `json:"rate" db:"rate"`
+	Source    string          `json:"source" db:"source"`
+	ValidFrom time.Time       `json:"valid_from" db:"valid_from"`
+	ValidTo   *time.Time      `json:"valid_to,omitempty" db:"valid_to"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
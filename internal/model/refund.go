@@ -0,0 +1,10 @@
+package model
+
+import "github.com/shopspring/decimal"
+
+// Refund returns money of the transaction TransactionID, zero Amount refunds the rest of it.
+type Refund struct {
+	TransactionID int64           `json:"-"`
+	Amount        decimal.Decimal `json:"amount"`
+	Comment       string          `json:"comment,omitempty"`
+}
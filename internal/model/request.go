@@ -0,0 +1,17 @@
+package model
+
+import "context"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns ctx carrying the X-Request-ID of the call, it correlates the logs,
+// the audit log and the transactions of the call with the caller.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID of the call, empty out of requests.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ReservationHeld     = "held"
+	ReservationCaptured = "captured"
+	ReservationReleased = "released"
+	ReservationExpired  = "expired"
+)
+
+// Reservation holds money of a user for an order of a service until it is captured or released.
+type Reservation struct {
+	ID          int64           `json:"id" db:"id"`
+	UserID      int64           `json:"user_id" db:"user_id"`
+	ServiceID   int64           `json:"service_id" db:"service_id"`
+	OrderID     int64           `json:"order_id" db:"order_id"`
+	Amount      decimal.Decimal `json:"amount" db:"amount"`
+	Captured    decimal.Decimal `json:"captured" db:"captured"`
+	Status      string          `json:"status" db:"status"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time       `json:"expires_at" db:"expires_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty" db:"completed_at"`
+}
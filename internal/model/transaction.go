@@ -1,11 +1,141 @@
 package model
 
-import "github.com/shopspring/decimal"
+import (
+	"fmt"
+	"time"
 
+	"github.com/shopspring/decimal"
+)
+
+// Kinds of transactions.
+const (
+	TransactionTopUp       = "top_up"
+	TransactionPurchase    = "purchase"
+	TransactionTransferIn  = "transfer_in"
+	TransactionTransferOut = "transfer_out"
+	TransactionRefund      = "refund"
+	TransactionReversal    = "reversal"
+)
+
+// Sources of money in transactions.
+const (
+	SourceBankCard = "bank_card"
+	SourcePurchase = "purchase"
+	SourceTransfer = "transfer"
+)
+
+// Directions of transactions for filtering.
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Sort fields and orders of transactions.
+const (
+	SortByID     = "id"
+	SortByDate   = "date"
+	SortByAmount = "amount"
+	OrderAsc     = "asc"
+	OrderDesc    = "desc"
+)
+
+// Transaction is a change of the user balance. Operation is a human-readable description made by Describe,
+// it isn't stored. Refunded is the part of the amount returned by refunds, the refunds themselves
+// point to the transaction with RefundOf. QuoteID is the FX quote a purchase was charged at.
+// APIKeyID is the key of the service that made the transaction, RequestID is the X-Request-ID of its call.
+// ConvertedAmount is the amount in ConvertedCurrency at the rate of the transaction date,
+// it is filled only when the list is requested in another currency.
 type Transaction struct {
-	ID        int64           `json:"id" db:"id"`
-	UserID    int64           `json:"user_id" db:"user_id"`
-	Amount    decimal.Decimal `json:"amount" db:"amount"`
-	Operation string          `json:"operation" db:"operation"`
-	Date      string          `json:"date" db:"date"`
+	ID                 int64            `json:"id" db:"id"`
+	UserID             int64            `json:"user_id" db:"user_id"`
+	Amount             decimal.Decimal  `json:"amount" db:"amount"`
+	Currency           string           `json:"currency" db:"currency"`
+	Kind               string           `json:"kind" db:"kind"`
+	Source             string           `json:"source" db:"source"`
+	CounterpartyUserID *int64           `json:"counterparty_user_id,omitempty" db:"counterparty_user_id"`
+	TransferID         *int64           `json:"transfer_id,omitempty" db:"transfer_id"`
+	ServiceID          *int64           `json:"service_id,omitempty" db:"service_id"`
+	OrderID            *int64           `json:"order_id,omitempty" db:"order_id"`
+	Comment            string           `json:"comment,omitempty" db:"comment"`
+	RefundOf           *int64           `json:"refund_of,omitempty" db:"refund_of"`
+	QuoteID            *int64           `json:"quote_id,omitempty" db:"quote_id"`
+	APIKeyID           *int64           `json:"api_key_id,omitempty" db:"api_key_id"`
+	RequestID          string           `json:"request_id,omitempty" db:"request_id"`
+	Refunded           decimal.Decimal  `json:"refunded" db:"refunded"`
+	Refunds            []Transaction    `json:"refunds,omitempty" db:"-"`
+	Operation          string           `json:"operation" db:"-"`
+	ConvertedCurrency  string           `json:"converted_currency,omitempty" db:"-"`
+	ConvertedAmount    *decimal.Decimal `json:"converted_amount,omitempty" db:"-"`
+	Date               string           `json:"date" db:"date"`
+}
+
+// Describe returns a human-readable description of the transaction.
+func (t *Transaction) Describe() string {
+	currency := t.Currency
+	if currency == "" {
+		currency = CurrencyRUB
+	}
+
+	var d string
+	switch t.Kind {
+	case TransactionTopUp:
+		d = fmt.Sprintf("Top-up by %s %s%s", t.Source, t.Amount.StringFixed(2), currency)
+	case TransactionPurchase:
+		d = fmt.Sprintf("Debit by %s %s%s", t.Source, t.Amount.StringFixed(2), currency)
+	case TransactionTransferIn:
+		d = fmt.Sprintf("Top-up by transfer %s%s", t.Amount.StringFixed(2), currency)
+		if t.CounterpartyUserID != nil {
+			d += fmt.Sprintf(" from user %d", *t.CounterpartyUserID)
+		}
+	case TransactionTransferOut:
+		d = fmt.Sprintf("Debit by transfer %s%s", t.Amount.StringFixed(2), currency)
+		if t.CounterpartyUserID != nil {
+			d += fmt.Sprintf(" to user %d", *t.CounterpartyUserID)
+		}
+	case TransactionRefund, TransactionReversal:
+		d = fmt.Sprintf("Refund by %s %s%s", t.Source, t.Amount.StringFixed(2), currency)
+		if t.Kind == TransactionReversal {
+			d = fmt.Sprintf("Reversal of transfer %s%s", t.Amount.StringFixed(2), currency)
+		}
+		if t.RefundOf != nil {
+			d += fmt.Sprintf(" of transaction %d", *t.RefundOf)
+		}
+	default:
+		d = fmt.Sprintf("%s %s%s", t.Kind, t.Amount.StringFixed(2), currency)
+	}
+
+	if t.OrderID != nil && t.ServiceID != nil {
+		d += fmt.Sprintf(" for order %d of service %d", *t.OrderID, *t.ServiceID)
+	}
+	if t.Comment != "" {
+		d += ": " + t.Comment
+	}
+	return d
+}
+
+// TransactionPage is a page of transactions, NextCursor is empty on the last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
+
+// TransactionFilter selects a page of transactions of the user. Empty fields don't filter.
+// MinAmount and MaxAmount are compared with the absolute amount, use Direction for the sign.
+// The page starts after the After transaction in the SortBy/Order order.
+// RequestID selects the transactions made by the call with this X-Request-ID, without UserID of all users.
+type TransactionFilter struct {
+	UserID    int64
+	From      *time.Time
+	To        *time.Time
+	Type      string
+	RequestID string
+	Direction string
+	MinAmount *decimal.Decimal
+	MaxAmount *decimal.Decimal
+	SortBy    string
+	Order     string
+	After     *Transaction
+	Limit     int
+	// Currency converts the amounts, it doesn't filter.
+	Currency string
 }
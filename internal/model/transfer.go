@@ -1,9 +1,48 @@
 package model
 
-import "github.com/shopspring/decimal"
+import (
+	"time"
 
+	"github.com/shopspring/decimal"
+)
+
+const (
+	TransferCompleted = "completed"
+	TransferReversed  = "reversed"
+)
+
+// Transfer moves Amount from the Currency wallet of FromID to the ToCurrency wallet of ToID,
+// the recipient gets ToAmount. Between wallets of different currencies the money is exchanged
+// at Rate, the Spread part of it is kept by the service. It is reversed when all its money
+// is returned to the sender. ToBalance is the wallet of the recipient after the transfer,
+// the legacy route answers with it.
 type Transfer struct {
-	FromID int64           `json:"user_id" db:"user_id"`
-	ToID   int64           `json:"to_id" db:"user_id"`
-	Amount decimal.Decimal `json:"amount" db:"amount"`
+	ID          int64            `json:"id" db:"id"`
+	FromID      int64            `json:"user_id" db:"from_user_id"`
+	ToID        int64            `json:"to_id" db:"to_user_id"`
+	Amount      decimal.Decimal  `json:"amount" db:"amount"`
+	Currency    string           `json:"currency" db:"currency"`
+	ToAmount    decimal.Decimal  `json:"to_amount" db:"to_amount"`
+	ToCurrency  string           `json:"to_currency" db:"to_currency"`
+	Rate        *decimal.Decimal `json:"rate,omitempty" db:"rate"`
+	Spread      *decimal.Decimal `json:"spread,omitempty" db:"spread"`
+	Comment     string           `json:"comment,omitempty" db:"comment"`
+	Status      string           `json:"status" db:"status"`
+	CreatedAt   time.Time        `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty" db:"completed_at"`
+	ToBalance   *Balance         `json:"-" db:"-"`
+}
+
+// TransferPage is a page of transfers, NextCursor is empty on the last page.
+type TransferPage struct {
+	Transfers  []Transfer `json:"transfers"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// TransferFilter selects a page of transfers sent or received by the user, from newest to oldest.
+// The page starts after the transfer AfterID, zero AfterID starts from the newest.
+type TransferFilter struct {
+	UserID  int64
+	AfterID int64
+	Limit   int
 }
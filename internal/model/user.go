@@ -1,9 +1,68 @@
 package model
 
+import (
+	"context"
+	"time"
+)
+
+// User is an end user of the service. Password is accepted on registration and login only,
+// the service stores its bcrypt hash in PasswordHash. Status of the account limits the money movement.
+// A deleted user keeps its history, but can't log in or move money.
 type User struct {
-	ID       int    `json:"-" db:"id"`
-	Name     string `json:"name" binding:"required"`
-	Username string `json:"username" binding:"required"`
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	ID           int64      `json:"id" db:"id"`
+	Name         string     `json:"name" db:"name" binding:"required"`
+	Username     string     `json:"username" db:"username" binding:"required"`
+	Email        string     `json:"email" db:"email" binding:"required"`
+	Password     string     `json:"password,omitempty" db:"-" binding:"required"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	Status       string     `json:"status" db:"status"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// UserUpdate changes the profile of a user, nil fields are left as they are.
+type UserUpdate struct {
+	Name     *string `json:"name"`
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+// UserFilter searches users by the beginning of the username and the email, empty fields match any user.
+// Deleted users are never found.
+type UserFilter struct {
+	Username string
+	Email    string
+	Limit    int
+}
+
+// Credentials log a user in by the username or the email.
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// Tokens are issued on login and refresh. AccessToken is a JWT signed by the service,
+// RefreshToken is exchanged once for new tokens.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// UserScopes are the scopes of end users, they reach only their own profiles, balances, transactions and transfers.
+var UserScopes = []string{ScopeBalanceRead, ScopeTransfer, ScopeUsersRead, ScopeUsersWrite}
+
+type userKey struct{}
+
+// ContextWithUser returns ctx carrying the ID of the end user authenticated by an access token.
+func ContextWithUser(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// UserFromContext returns the ID of the end user of the request, false for services and anonymous requests.
+func UserFromContext(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(userKey{}).(int64)
+	return id, ok
 }
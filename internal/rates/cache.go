@@ -0,0 +1,80 @@
+package rates
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"golang.org/x/sync/singleflight"
+)
+
+// retryDelay is how long the failed provider is not asked again.
+const retryDelay = 30 * time.Second
+
+// Cache keeps the rates of the provider for ttl. When the provider fails,
+// the last rates are returned however old they are and the provider is asked again after retryDelay.
+// One call at a time asks the provider, the concurrent ones wait for its answer without holding the lock.
+type Cache struct {
+	provider Provider
+	ttl      time.Duration
+	now      func() time.Time
+	fetch    singleflight.Group
+
+	mu        sync.Mutex
+	rates     *model.Rates
+	fetchedAt time.Time
+	retryAt   time.Time
+	err       error
+}
+
+func NewCache(provider Provider, ttl time.Duration) *Cache {
+	return &Cache{provider: provider, ttl: ttl, now: time.Now}
+}
+
+func (c *Cache) Rates(ctx context.Context) (*model.Rates, error) {
+	c.mu.Lock()
+	rates, err := c.rates, c.err
+	fresh := rates != nil && c.now().Sub(c.fetchedAt) < c.ttl
+	backoff := c.now().Before(c.retryAt)
+	c.mu.Unlock()
+
+	switch {
+	case fresh:
+		return rates, nil
+	case backoff && rates != nil:
+		return rates, nil
+	case backoff:
+		return nil, err
+	}
+
+	// the waiting calls share the answer, so the fetch doesn't stop with the call that started it
+	ans, err, _ := c.fetch.Do("rates", func() (interface{}, error) {
+		return c.refresh(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ans.(*model.Rates), nil
+}
+
+// refresh asks the provider and keeps its rates, on failure it returns the last rates if there are any.
+func (c *Cache) refresh(ctx context.Context) (*model.Rates, error) {
+	rates, err := c.provider.Rates(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.retryAt = c.now().Add(retryDelay)
+		c.err = err
+		if c.rates != nil {
+			return c.rates, nil
+		}
+		return nil, err
+	}
+	c.rates = rates
+	c.fetchedAt = c.now()
+	c.retryAt = time.Time{}
+	c.err = nil
+	return rates, nil
+}
@@ -0,0 +1,104 @@
+package rates
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+type stubProvider struct {
+	calls int
+	rates *model.Rates
+	err   error
+}
+
+func (p *stubProvider) Rates(_ context.Context) (*model.Rates, error) {
+	p.calls++
+	return p.rates, p.err
+}
+
+func TestCache_Rates(t *testing.T) {
+	ctx := context.Background()
+	eur := &model.Rates{Base: "EUR", Rates: map[string]decimal.Decimal{"RUB": decimal.NewFromInt(100)}}
+	stub := &stubProvider{rates: eur}
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	c := NewCache(stub, time.Minute)
+	c.now = func() time.Time { return now }
+
+	got, err := c.Rates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, eur, got)
+
+	// fresh rates come from the cache
+	now = now.Add(30 * time.Second)
+	_, err = c.Rates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stub.calls)
+
+	// stale rates are fetched again
+	now = now.Add(time.Minute)
+	usd := &model.Rates{Base: "USD", Rates: map[string]decimal.Decimal{"RUB": decimal.NewFromInt(90)}}
+	stub.rates = usd
+	got, err = c.Rates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, usd, got)
+	require.Equal(t, 2, stub.calls)
+
+	// the last rates are returned while the provider fails
+	now = now.Add(time.Hour)
+	stub.rates, stub.err = nil, errors.New("connection refused")
+	got, err = c.Rates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, usd, got)
+	require.Equal(t, 3, stub.calls)
+}
+
+func TestCache_RatesRetry(t *testing.T) {
+	ctx := context.Background()
+	eur := &model.Rates{Base: "EUR", Rates: map[string]decimal.Decimal{"RUB": decimal.NewFromInt(100)}}
+	stub := &stubProvider{rates: eur}
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	c := NewCache(stub, time.Minute)
+	c.now = func() time.Time { return now }
+	_, err := c.Rates(ctx)
+	require.NoError(t, err)
+
+	// the failing provider is asked once, the reads get the last rates until the retry
+	now = now.Add(time.Hour)
+	stub.rates, stub.err = nil, errors.New("connection refused")
+	for range 3 {
+		got, err := c.Rates(ctx)
+		require.NoError(t, err)
+		require.Equal(t, eur, got)
+	}
+	require.Equal(t, 2, stub.calls)
+
+	// after the retry delay the provider is asked again
+	now = now.Add(retryDelay)
+	usd := &model.Rates{Base: "USD", Rates: map[string]decimal.Decimal{"RUB": decimal.NewFromInt(90)}}
+	stub.rates, stub.err = usd, nil
+	got, err := c.Rates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, usd, got)
+	require.Equal(t, 3, stub.calls)
+}
+
+func TestCache_RatesError(t *testing.T) {
+	stub := &stubProvider{err: errors.New("connection refused")}
+	c := NewCache(stub, time.Minute)
+
+	_, err := c.Rates(context.Background())
+	require.Error(t, err)
+
+	// without rates the error is returned until the retry too
+	_, err = c.Rates(context.Background())
+	require.Error(t, err)
+	require.Equal(t, 1, stub.calls)
+}
@@ -0,0 +1,111 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/metrics"
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errNoRates = errors.New("no rates in the response")
+
+// Outcomes of the requests to the rates API.
+const (
+	outcomeOK          = "ok"
+	outcomeError       = "error"
+	outcomeBadStatus   = "bad_status"
+	outcomeBadResponse = "bad_response"
+)
+
+var (
+	tracer = tracing.Tracer("github.com/cronnoss/avitotech/internal/rates")
+
+	providerRequests = metrics.NewCounter("avitotech_rates_provider_requests_total",
+		"Requests to the exchange rates API by outcome: ok, error, bad_status or bad_response.", "outcome")
+	providerDuration = metrics.NewHistogram("avitotech_rates_provider_request_duration_seconds",
+		"Duration of requests to the exchange rates API.", metrics.DefaultBuckets)
+)
+
+// HTTPProvider gets the rates from an API compatible with exchangeratesapi.io.
+type HTTPProvider struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+func NewHTTPProvider(url, key string, timeout time.Duration) *HTTPProvider {
+	return &HTTPProvider{url: url, key: key, client: &http.Client{Timeout: timeout}}
+}
+
+// Rates gets the current rates, the outcome and the duration of every request are measured and traced.
+func (p *HTTPProvider) Rates(ctx context.Context) (*model.Rates, error) {
+	ctx, span := tracer.Start(ctx, "HTTPProvider.Rates", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	start := time.Now()
+
+	ans, outcome, err := p.rates(ctx)
+
+	providerDuration.Since(start)
+	providerRequests.Inc(outcome)
+	span.SetAttributes(attribute.String("rates.outcome", outcome))
+	if err != nil {
+		tracing.Fail(span, err)
+	}
+	return ans, err
+}
+
+func (p *HTTPProvider) rates(ctx context.Context) (*model.Rates, string, error) {
+	endpoint, err := url.Parse(p.url)
+	if err != nil {
+		return nil, outcomeError, fmt.Errorf("wrong rates url: %w", err)
+	}
+	if p.key != "" {
+		q := endpoint.Query()
+		q.Set("access_key", p.key)
+		endpoint.RawQuery = q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, outcomeError, err
+	}
+	// the url is not traced, its query has the key; the API is not ours, so it doesn't get traceparent
+	trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPRequestMethodKey.String(http.MethodGet),
+		semconv.ServerAddress(endpoint.Hostname()))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// the error contains the url with the key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, outcomeError, fmt.Errorf("failed to get rates: %w", urlErr.Err)
+		}
+		return nil, outcomeError, fmt.Errorf("failed to get rates: %w", err)
+	}
+	defer resp.Body.Close()
+	trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, outcomeBadStatus, fmt.Errorf("failed to get rates: status %d", resp.StatusCode)
+	}
+
+	var ans model.Rates
+	if err := json.NewDecoder(resp.Body).Decode(&ans); err != nil {
+		return nil, outcomeBadResponse, fmt.Errorf("failed to decode rates: %w", err)
+	}
+	// the API answers errors like a wrong key with 200 and no rates
+	if len(ans.Rates) == 0 || ans.Base == "" {
+		return nil, outcomeBadResponse, errNoRates
+	}
+	ans.FetchedAt = time.Now()
+	return &ans, outcomeOK, nil
+}
@@ -0,0 +1,118 @@
+package rates
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/metrics"
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHTTPProvider_Rates(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		outcome string
+		wantErr bool
+	}{
+		{
+			name:    "Rates",
+			status:  http.StatusOK,
+			body:    `{"success": true, "base": "EUR", "date": "2026-10-17", "rates": {"RUB": 98.45, "KZT": 516.3}}`,
+			outcome: outcomeOK,
+		},
+		{
+			name:    "Wrong key",
+			status:  http.StatusOK,
+			body:    `{"success": false, "error": {"code": 101, "type": "invalid_access_key"}}`,
+			outcome: outcomeBadResponse,
+			wantErr: true,
+		},
+		{
+			name:    "Server error",
+			status:  http.StatusInternalServerError,
+			outcome: outcomeBadStatus,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "secret", r.URL.Query().Get("access_key"))
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			p := NewHTTPProvider(srv.URL+"/v1/latest", "secret", time.Second)
+			got, err := p.Rates(context.Background())
+
+			// every case has its own outcome, so each of them is counted once
+			var out strings.Builder
+			require.NoError(t, metrics.Default.Write(&out))
+			require.Contains(t, out.String(), `avitotech_rates_provider_requests_total{outcome="`+tt.outcome+`"} 1`+"\n")
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "EUR", got.Base)
+			require.True(t, got.Rates["KZT"].Equal(decimal.NewFromFloat(516.3)))
+			require.False(t, got.FetchedAt.IsZero())
+		})
+	}
+}
+
+func TestHTTPProvider_TraceContext(t *testing.T) {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+
+	traceparent := "unset"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"base": "EUR", "rates": {"RUB": 98.45}}`))
+	}))
+	defer srv.Close()
+
+	_, err = NewHTTPProvider(srv.URL, "", time.Second).Rates(ctx)
+	require.NoError(t, err)
+	// the rates API is outside the service, it never learns the traces of the requests
+	require.Empty(t, traceparent)
+}
+
+func TestFileProvider_Rates(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rates.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"base": "EUR", "rates": {"RUB": 100, "USD": 1.25}}`), 0o600))
+
+	p, err := NewFileProvider(filename)
+	require.NoError(t, err)
+	rates, err := p.Rates(context.Background())
+	require.NoError(t, err)
+
+	got, err := rates.Convert(decimal.NewFromInt(1000), "RUB", "usd")
+	require.NoError(t, err)
+	require.True(t, got.Equal(decimal.NewFromFloat(12.5)), got.String())
+
+	_, err = rates.Convert(decimal.NewFromInt(1000), "RUB", "XXX")
+	require.ErrorIs(t, err, model.ErrInvalidArgument)
+
+	_, err = NewFileProvider(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
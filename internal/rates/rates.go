@@ -0,0 +1,62 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultTTL     = time.Hour
+	// keyEnv overrides the access key of the config, so the key may stay out of the files.
+	keyEnv = "AVITOTECH_RATES_KEY"
+)
+
+type Conf struct {
+	// Provider is "http" for the rates API or "file" for the rates from a JSON file.
+	Provider string        `toml:"provider"`
+	URL      string        `toml:"url"`
+	Key      string        `toml:"key"`
+	Timeout  time.Duration `toml:"timeout"`
+	File     string        `toml:"file"`
+	TTL      time.Duration `toml:"ttl"`
+	// SnapshotInterval is how often the rates are stored for conversions at past dates.
+	SnapshotInterval time.Duration `toml:"snapshot_interval"`
+}
+
+// Provider returns the current exchange rates.
+type Provider interface {
+	Rates(context.Context) (*model.Rates, error)
+}
+
+// NewProvider returns the provider of the config, its rates are cached for conf.TTL.
+func NewProvider(conf Conf) (Provider, error) {
+	ttl := conf.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	switch conf.Provider {
+	case "http", "":
+		key := conf.Key
+		if env := os.Getenv(keyEnv); env != "" {
+			key = env
+		}
+		timeout := conf.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		return NewCache(NewHTTPProvider(conf.URL, key, timeout), ttl), nil
+	case "file":
+		p, err := NewFileProvider(conf.File)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown rates provider %q", conf.Provider)
+}
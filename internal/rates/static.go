@@ -0,0 +1,39 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+// StaticProvider always returns the same rates, it works offline.
+type StaticProvider struct {
+	rates *model.Rates
+}
+
+func NewStaticProvider(rates *model.Rates) *StaticProvider {
+	return &StaticProvider{rates: rates}
+}
+
+// NewFileProvider reads the rates from a JSON file in the format of the rates API.
+func NewFileProvider(filename string) (*StaticProvider, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rates model.Rates
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("failed to decode rates file %s: %w", filename, err)
+	}
+	if len(rates.Rates) == 0 || rates.Base == "" {
+		return nil, fmt.Errorf("rates file %s: %w", filename, errNoRates)
+	}
+	return NewStaticProvider(&rates), nil
+}
+
+func (p *StaticProvider) Rates(_ context.Context) (*model.Rates, error) {
+	return p.rates, nil
+}
@@ -0,0 +1,84 @@
+package internalhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+// auditMiddleware writes every call of the state-changing route to the audit log: the caller, the hash
+// of the body, the answer and the resulting balance. It wraps authMiddleware, so calls rejected
+// by the authentication are written too. The record is written after the answer, so a failed write is only logged.
+func (s *Server) auditMiddleware(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// legacy routes accept any method
+		route := pattern
+		if !strings.Contains(route, " ") {
+			route = r.Method + " " + route
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			s.writeError(w, r, "read body", model.Errorf(model.ErrBadRequest, "%v", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		r, caller := withAuthenticated(r)
+		rw := &recordWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		sum := sha256.Sum256(body)
+		record := auditRecord(r, caller, rw.status, rw.body.Bytes())
+		record.Route, record.PayloadHash = route, hex.EncodeToString(sum[:])
+		// the record is written even if the caller is gone
+		if err := s.app.RecordAudit(context.WithoutCancel(r.Context()), record); err != nil {
+			s.logger(r).Errorf("Can't record audit of %s %s:%v\n", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+// auditRecord describes the call by its caller and the answer with the status and the body,
+// the caller of a call rejected by the authentication is unknown.
+func auditRecord(r *http.Request, caller *authenticated, status int, body []byte) *model.AuditRecord {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	record := &model.AuditRecord{
+		Path:      r.Method + " " + r.URL.Path,
+		Status:    status,
+		Result:    model.AuditResultOK,
+		RequestID: requestID(r),
+	}
+	if caller.key != nil {
+		record.APIKeyID = &caller.key.ID
+	}
+	record.ActorUserID = caller.userID
+
+	var answer struct {
+		Balance *model.Balance `json:"balance"`
+		Error   *errorResponse `json:"error"`
+	}
+	// the answers of the routes are JSON, a body that isn't leaves the record without the details
+	_ = json.Unmarshal(body, &answer)
+	if b := answer.Balance; b != nil {
+		record.UserID, record.Balance, record.Currency = &b.UserID, &b.Amount, &b.Currency
+	}
+	if status >= http.StatusBadRequest {
+		record.Result = "error"
+		if answer.Error != nil {
+			record.Result = answer.Error.Code
+			if record.RequestID == "" {
+				record.RequestID = answer.Error.RequestID
+			}
+		}
+	}
+	return record
+}
@@ -0,0 +1,220 @@
+package internalhttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/server/mocks"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Audit(t *testing.T) {
+	body := `{"amount": 10}`
+	bodyHash := sha256.Sum256([]byte(body))
+	key := &model.APIKey{ID: 3, Name: "billing", Scopes: "balance:credit"}
+
+	topUp := func(s *Server) http.HandlerFunc { return s.UserTopUp }
+
+	tests := []struct {
+		name      string
+		route     string
+		target    string
+		scope     string
+		handler   func(s *Server) http.HandlerFunc
+		headers   map[string]string
+		mock      func(app *mocks.Application)
+		wantAudit func(t *testing.T, a *model.AuditRecord)
+	}{
+		{
+			name:    "Top-up",
+			route:   "POST /api/v1/users/{id}/top-ups",
+			target:  "/api/v1/users/7/top-ups",
+			scope:   model.ScopeBalanceCredit,
+			handler: topUp,
+			headers: map[string]string{
+				headerAPIKey:    "ak_1",
+				headerRequestID: "req-1",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateKey", mock.Anything, "ak_1").Return(key, nil)
+				app.On("TopUp", mock.Anything, mock.Anything).Return(&model.Balance{
+					ID: 1, UserID: 7, Currency: model.CurrencyRUB, Amount: decimal.NewFromInt(110),
+				}, nil)
+			},
+			wantAudit: func(t *testing.T, a *model.AuditRecord) {
+				t.Helper()
+				require.Equal(t, &key.ID, a.APIKeyID)
+				require.Nil(t, a.ActorUserID)
+				require.Equal(t, http.StatusOK, a.Status)
+				require.Equal(t, model.AuditResultOK, a.Result)
+				require.Equal(t, int64(7), *a.UserID)
+				require.True(t, decimal.NewFromInt(110).Equal(*a.Balance))
+				require.Equal(t, model.CurrencyRUB, *a.Currency)
+				require.Equal(t, "req-1", a.RequestID)
+			},
+		},
+		{
+			name:    "Failed top-up",
+			route:   "POST /api/v1/users/{id}/top-ups",
+			target:  "/api/v1/users/7/top-ups",
+			scope:   model.ScopeBalanceCredit,
+			handler: topUp,
+			headers: map[string]string{
+				headerAPIKey: "ak_1",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateKey", mock.Anything, "ak_1").Return(key, nil)
+				app.On("TopUp", mock.Anything, mock.Anything).
+					Return(nil, model.Errorf(model.ErrAccountFrozen, "account of user 7 is frozen"))
+			},
+			wantAudit: func(t *testing.T, a *model.AuditRecord) {
+				t.Helper()
+				require.Equal(t, http.StatusConflict, a.Status)
+				require.Equal(t, "account_frozen", a.Result)
+				require.Nil(t, a.Balance)
+				// the request ID of the error answer is kept
+				require.NotEmpty(t, a.RequestID)
+			},
+		},
+		{
+			name:    "Transfer by user",
+			route:   "POST /api/v1/transfers",
+			target:  "/api/v1/transfers",
+			scope:   model.ScopeTransfer,
+			handler: func(s *Server) http.HandlerFunc { return s.Transfer },
+			headers: map[string]string{
+				headerAuthorization: "Bearer jwt",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateToken", mock.Anything, "jwt").Return(int64(7), nil)
+				app.On("Transfer", mock.Anything, mock.Anything).Return(&model.Transfer{
+					ID: 5, FromID: 7, ToID: 8, Currency: model.CurrencyRUB, Amount: decimal.NewFromInt(10),
+				}, nil)
+			},
+			wantAudit: func(t *testing.T, a *model.AuditRecord) {
+				t.Helper()
+				require.Nil(t, a.APIKeyID)
+				require.Equal(t, int64(7), *a.ActorUserID)
+				require.Equal(t, model.AuditResultOK, a.Result)
+			},
+		},
+		{
+			name:    "No credentials",
+			route:   "POST /api/v1/users/{id}/top-ups",
+			target:  "/api/v1/users/7/top-ups",
+			scope:   model.ScopeBalanceCredit,
+			handler: topUp,
+			mock:    func(_ *mocks.Application) {},
+			wantAudit: func(t *testing.T, a *model.AuditRecord) {
+				t.Helper()
+				require.Nil(t, a.APIKeyID)
+				require.Nil(t, a.ActorUserID)
+				require.Equal(t, http.StatusUnauthorized, a.Status)
+				require.Equal(t, "unauthorized", a.Result)
+			},
+		},
+		{
+			name:    "Bad signature",
+			route:   "POST /api/v1/users/{id}/top-ups",
+			target:  "/api/v1/users/7/top-ups",
+			scope:   model.ScopeBalanceCredit,
+			handler: topUp,
+			headers: map[string]string{
+				headerAPIKeyID:  "3",
+				headerTimestamp: "1760716800",
+				headerNonce:     "n-1",
+				headerSignature: "abc",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateSignature", mock.Anything, mock.Anything).
+					Return(nil, model.Errorf(model.ErrUnauthorized, "wrong signature"))
+			},
+			wantAudit: func(t *testing.T, a *model.AuditRecord) {
+				t.Helper()
+				require.Nil(t, a.APIKeyID)
+				require.Equal(t, http.StatusUnauthorized, a.Status)
+				require.Equal(t, "unauthorized", a.Result)
+			},
+		},
+		{
+			name:    "No scope",
+			route:   "POST /api/v1/users/{id}/top-ups",
+			target:  "/api/v1/users/7/top-ups",
+			scope:   model.ScopeBalanceCredit,
+			handler: topUp,
+			headers: map[string]string{
+				headerAPIKey: "ak_2",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateKey", mock.Anything, "ak_2").
+					Return(&model.APIKey{ID: 4, Name: "reports", Scopes: "balance:read"}, nil)
+			},
+			wantAudit: func(t *testing.T, a *model.AuditRecord) {
+				t.Helper()
+				// the key is known, it just can't call the route
+				require.Equal(t, int64(4), *a.APIKeyID)
+				require.Equal(t, http.StatusForbidden, a.Status)
+				require.Equal(t, "forbidden", a.Result)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, app := newTestServer(t)
+			s.auth = true
+			tt.mock(app)
+
+			var got *model.AuditRecord
+			app.On("RecordAudit", mock.Anything, mock.Anything).
+				Run(func(args mock.Arguments) { got = args.Get(1).(*model.AuditRecord) }).
+				Return(nil).Once()
+
+			mux := http.NewServeMux()
+			mux.Handle(tt.route, s.auditMiddleware(tt.route, s.authMiddleware(tt.scope, tt.handler(s))))
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.NotNil(t, got)
+			require.Equal(t, tt.route, got.Route)
+			require.Equal(t, "POST "+tt.target, got.Path)
+			require.Equal(t, hex.EncodeToString(bodyHash[:]), got.PayloadHash)
+			tt.wantAudit(t, got)
+		})
+	}
+}
+
+func TestServer_AuditCanceledRequest(t *testing.T) {
+	s, app := newTestServer(t)
+	app.On("TopUp", mock.Anything, mock.Anything).
+		Return(nil, model.Errorf(model.ErrUnavailable, "client is gone"))
+	var auditErr error
+	app.On("RecordAudit", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { auditErr = args.Get(0).(context.Context).Err() }).
+		Return(nil).Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	route := "POST /api/v1/users/{id}/top-ups"
+	mux := http.NewServeMux()
+	mux.Handle(route, s.auditMiddleware(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the caller disconnects while the request is served
+		cancel()
+		s.UserTopUp(w, r)
+	})))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/7/top-ups", strings.NewReader(`{"amount": 10}`))
+	mux.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+
+	require.NoError(t, auditErr)
+}
@@ -0,0 +1,175 @@
+package internalhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const (
+	headerAuthorization = "Authorization"
+	bearerPrefix        = "Bearer "
+	headerAPIKey        = "X-API-Key"
+	headerAPIKeyID      = "X-API-Key-ID"
+	headerTimestamp     = "X-Timestamp"
+	headerNonce         = "X-Nonce"
+	headerSignature     = "X-Signature"
+)
+
+// authenticated is the caller found by authMiddleware for the middlewares wrapping it,
+// they hold it in ctx before the request is authenticated and read it after the request is served.
+type authenticated struct {
+	key    *model.APIKey
+	userID *int64
+}
+
+// withAuthenticated returns the request holding the caller found by authMiddleware,
+// a request already holding one keeps it.
+func withAuthenticated(r *http.Request) (*http.Request, *authenticated) {
+	if a, ok := r.Context().Value(keyAuthenticatedID).(*authenticated); ok {
+		return r, a
+	}
+	a := &authenticated{}
+	return r.WithContext(context.WithValue(r.Context(), keyAuthenticatedID, a)), a
+}
+
+// authMiddleware lets through callers with the scope: services presenting an API key in X-API-Key
+// or signing the request with one, and end users with an access token in the Authorization header.
+// The caller is put into the context of the request.
+func (s *Server) authMiddleware(scope string, next http.Handler) http.Handler {
+	if !s.auth || scope == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.Header.Get(headerAuthorization), bearerPrefix); ok {
+			userID, err := s.app.AuthenticateToken(r.Context(), token)
+			if err != nil {
+				s.writeError(w, r, "authenticate", err)
+				return
+			}
+			if a, ok := r.Context().Value(keyAuthenticatedID).(*authenticated); ok {
+				a.userID = &userID
+			}
+			if !slices.Contains(model.UserScopes, scope) {
+				s.writeError(w, r, "authorize", model.Errorf(model.ErrForbidden, "users have no scope %s", scope))
+				return
+			}
+			r = withLogger(r, s.logger(r).With("user_id", userID))
+			next.ServeHTTP(w, r.WithContext(model.ContextWithUser(r.Context(), userID)))
+			return
+		}
+
+		var (
+			key *model.APIKey
+			err error
+		)
+		switch {
+		case r.Header.Get(headerSignature) != "":
+			key, err = s.authenticateSignature(w, r)
+		case r.Header.Get(headerAPIKey) != "":
+			key, err = s.app.AuthenticateKey(r.Context(), r.Header.Get(headerAPIKey))
+		default:
+			err = model.Errorf(model.ErrUnauthorized, "%s, %s or an access token is required",
+				headerAPIKey, headerSignature)
+		}
+		if err != nil {
+			s.writeError(w, r, "authenticate", err)
+			return
+		}
+		if a, ok := r.Context().Value(keyAuthenticatedID).(*authenticated); ok {
+			a.key = key
+		}
+		if !key.HasScope(scope) {
+			s.writeError(w, r, "authorize", model.Errorf(model.ErrForbidden, "API key has no scope %s", scope))
+			return
+		}
+		r = withLogger(r, s.logger(r).With("api_key_id", key.ID))
+		next.ServeHTTP(w, r.WithContext(model.ContextWithCaller(r.Context(), key)))
+	})
+}
+
+// authenticateSignature checks the X-Signature of the request, it signs the lines
+// of the method, the request URI, X-Timestamp in Unix seconds, X-Nonce and the hex SHA-256 of the body.
+// The body is read up to the limit of the JSON bodies.
+func (s *Server) authenticateSignature(w http.ResponseWriter, r *http.Request) (*model.APIKey, error) {
+	keyID, err := strconv.ParseInt(r.Header.Get(headerAPIKeyID), 10, 64)
+	if err != nil {
+		return nil, model.Errorf(model.ErrUnauthorized, "wrong %s", headerAPIKeyID)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(headerTimestamp), 10, 64)
+	if err != nil {
+		return nil, model.Errorf(model.ErrUnauthorized, "wrong %s", headerTimestamp)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return nil, model.Errorf(model.ErrBadRequest, "%v", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	bodyHash := sha256.Sum256(body)
+
+	payload := strings.Join([]string{
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get(headerTimestamp),
+		r.Header.Get(headerNonce),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+	return s.app.AuthenticateSignature(r.Context(), &model.SignedRequest{
+		KeyID:     keyID,
+		Timestamp: time.Unix(timestamp, 0),
+		Nonce:     r.Header.Get(headerNonce),
+		Payload:   []byte(payload),
+		Signature: r.Header.Get(headerSignature),
+	})
+}
+
+func (s *Server) Register(w http.ResponseWriter, r *http.Request) {
+	var user model.User
+	if err := s.helperDecode(r, w, &user); err != nil {
+		return
+	}
+	ans, err := s.app.Register(r.Context(), &user)
+	if err != nil {
+		s.writeError(w, r, "register", err)
+		return
+	}
+	writeJSON(w, r, "user", ans, s)
+}
+
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	var credentials model.Credentials
+	if err := s.helperDecode(r, w, &credentials); err != nil {
+		return
+	}
+	ans, err := s.app.Login(r.Context(), &credentials)
+	if err != nil {
+		s.writeError(w, r, "log in", err)
+		return
+	}
+	writeBody(w, r, ans, s)
+}
+
+func (s *Server) Refresh(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := s.helperDecode(r, w, &body); err != nil {
+		return
+	}
+	ans, err := s.app.Refresh(r.Context(), body.RefreshToken)
+	if err != nil {
+		s.writeError(w, r, "refresh tokens", err)
+		return
+	}
+	writeBody(w, r, ans, s)
+}
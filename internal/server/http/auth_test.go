@@ -0,0 +1,193 @@
+package internalhttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/server/mocks"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Auth(t *testing.T) {
+	body := `{"amount": 10}`
+	bodyHash := sha256.Sum256([]byte(body))
+	key := &model.APIKey{ID: 3, Name: "billing", Scopes: "balance:read balance:credit"}
+
+	tests := []struct {
+		name       string
+		scope      string
+		headers    map[string]string
+		body       string
+		mock       func(app *mocks.Application)
+		wantStatus int
+		wantCode   string
+		wantUser   int64
+	}{
+		{
+			name: "API key",
+			headers: map[string]string{
+				headerAPIKey: "ak_1",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateKey", mock.Anything, "ak_1").Return(key, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Signed request",
+			headers: map[string]string{
+				headerAPIKeyID:  "3",
+				headerTimestamp: "1760716800",
+				headerNonce:     "n-1",
+				headerSignature: "abc",
+			},
+			mock: func(app *mocks.Application) {
+				payload := "POST\n/api/v1/users/7/top-ups?x=1\n1760716800\nn-1\n" + hex.EncodeToString(bodyHash[:])
+				app.On("AuthenticateSignature", mock.Anything, mock.MatchedBy(func(r *model.SignedRequest) bool {
+					return r.KeyID == 3 && r.Nonce == "n-1" && r.Timestamp.Unix() == 1760716800 &&
+						string(r.Payload) == payload && r.Signature == "abc"
+				})).Return(key, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Signed request too large",
+			headers: map[string]string{
+				headerAPIKeyID:  "3",
+				headerTimestamp: "1760716800",
+				headerNonce:     "n-1",
+				headerSignature: "abc",
+			},
+			body:       strings.Repeat(" ", maxBodySize+1),
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "No credentials",
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name: "Replayed signature",
+			headers: map[string]string{
+				headerAPIKeyID:  "3",
+				headerTimestamp: "1760716800",
+				headerNonce:     "n-1",
+				headerSignature: "abc",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateSignature", mock.Anything, mock.Anything).
+					Return(nil, model.Errorf(model.ErrUnauthorized, "nonce is already used"))
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name: "No scope",
+			headers: map[string]string{
+				headerAPIKey: "ak_2",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateKey", mock.Anything, "ak_2").
+					Return(&model.APIKey{ID: 4, Name: "reports", Scopes: "balance:read"}, nil)
+			},
+			wantStatus: http.StatusForbidden,
+			wantCode:   "forbidden",
+		},
+		{
+			name:  "User token",
+			scope: model.ScopeTransfer,
+			headers: map[string]string{
+				headerAuthorization: "Bearer jwt",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateToken", mock.Anything, "jwt").Return(int64(7), nil)
+			},
+			wantStatus: http.StatusOK,
+			wantUser:   7,
+		},
+		{
+			name: "User token out of user scopes",
+			headers: map[string]string{
+				headerAuthorization: "Bearer jwt",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateToken", mock.Anything, "jwt").Return(int64(7), nil)
+			},
+			wantStatus: http.StatusForbidden,
+			wantCode:   "forbidden",
+		},
+		{
+			name: "Expired user token",
+			headers: map[string]string{
+				headerAuthorization: "Bearer jwt",
+			},
+			mock: func(app *mocks.Application) {
+				app.On("AuthenticateToken", mock.Anything, "jwt").
+					Return(int64(0), model.Errorf(model.ErrUnauthorized, "access token is expired"))
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, app := newTestServer(t)
+			s.auth = true
+			tt.mock(app)
+
+			scope := tt.scope
+			if scope == "" {
+				scope = model.ScopeBalanceCredit
+			}
+			var (
+				caller *model.APIKey
+				userID int64
+			)
+			h := s.authMiddleware(scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				caller = model.CallerFromContext(r.Context())
+				userID, _ = model.UserFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			reqBody := body
+			if tt.body != "" {
+				reqBody = tt.body
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/users/7/top-ups?x=1", strings.NewReader(reqBody))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantCode != "" {
+				var got struct {
+					Error errorResponse `json:"error"`
+				}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				require.Equal(t, tt.wantCode, got.Error.Code)
+				require.Nil(t, caller)
+				require.Zero(t, userID)
+				return
+			}
+			if tt.wantUser != 0 {
+				require.Equal(t, tt.wantUser, userID)
+				require.Nil(t, caller)
+				return
+			}
+			require.Equal(t, key, caller)
+		})
+	}
+}
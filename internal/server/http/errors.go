@@ -0,0 +1,83 @@
+package internalhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// errorResponse is the body of every error answer, Code is stable and meant for programs.
+type errorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// errorStatuses maps kinds of domain errors to the answers, other errors are internal.
+var errorStatuses = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{model.ErrBadRequest, http.StatusBadRequest, "bad_request"},
+	{model.ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{model.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{model.ErrNotFound, http.StatusNotFound, "not_found"},
+	{errMethodNotAllowed, http.StatusMethodNotAllowed, "method_not_allowed"},
+	{model.ErrConflict, http.StatusConflict, "conflict"},
+	{model.ErrAccountFrozen, http.StatusConflict, "account_frozen"},
+	{model.ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds"},
+	{model.ErrInvalidAmount, http.StatusUnprocessableEntity, "invalid_amount"},
+	{model.ErrInvalidArgument, http.StatusUnprocessableEntity, "invalid_argument"},
+	{model.ErrUnavailable, http.StatusServiceUnavailable, "unavailable"},
+}
+
+// writeError answers with the status and the code of err, op tells what failed.
+// Only messages of domain errors reach the client, the rest is logged.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	status, resp := http.StatusInternalServerError, errorResponse{
+		Code:    "internal",
+		Message: "Can't " + op,
+	}
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.kind) {
+			status, resp.Code = e.status, e.code
+			break
+		}
+	}
+	var domainErr *model.Error
+	if status < http.StatusInternalServerError && errors.As(err, &domainErr) {
+		resp.Message = "Can't " + op + ": " + domainErr.Message
+	}
+
+	l := s.logger(r)
+	resp.RequestID = requestID(r)
+	if resp.RequestID == "" {
+		resp.RequestID = newRequestID()
+		l = l.With("request_id", resp.RequestID)
+	}
+
+	if status >= http.StatusInternalServerError {
+		l.Errorf("Can't %s:%v\n", op, err)
+	} else {
+		l.Warningf("Can't %s:%v\n", op, err)
+	}
+
+	body, _ := json.Marshal(map[string]errorResponse{"error": resp})
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,100 @@
+package internalhttp
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// parseTransactionFilter reads the transaction filter from query parameters,
+// the values are checked further by the application.
+func parseTransactionFilter(q url.Values) (*model.TransactionFilter, error) {
+	f := &model.TransactionFilter{
+		Type:      q.Get("type"),
+		RequestID: q.Get("request_id"),
+		Direction: q.Get("direction"),
+		SortBy:    q.Get("sort"),
+		Order:     q.Get("order"),
+		Currency:  q.Get("currency"),
+	}
+
+	var err error
+	if l := q.Get("limit"); l != "" {
+		if f.Limit, err = strconv.Atoi(l); err != nil || f.Limit <= 0 {
+			return nil, model.Errorf(model.ErrBadRequest, "limit must be a positive number")
+		}
+	}
+	if f.From, err = parseTime(q.Get("from")); err != nil {
+		return nil, model.Errorf(model.ErrBadRequest, "wrong from: %v", err)
+	}
+	if f.To, err = parseTime(q.Get("to")); err != nil {
+		return nil, model.Errorf(model.ErrBadRequest, "wrong to: %v", err)
+	}
+	if f.MinAmount, err = parseDecimal(q.Get("min_amount")); err != nil {
+		return nil, model.Errorf(model.ErrBadRequest, "wrong min_amount: %v", err)
+	}
+	if f.MaxAmount, err = parseDecimal(q.Get("max_amount")); err != nil {
+		return nil, model.Errorf(model.ErrBadRequest, "wrong max_amount: %v", err)
+	}
+	return f, nil
+}
+
+// parseAuditFilter reads the audit log filter from query parameters.
+func parseAuditFilter(q url.Values) (*model.AuditFilter, error) {
+	f := &model.AuditFilter{
+		Route:     q.Get("route"),
+		Result:    q.Get("result"),
+		RequestID: q.Get("request_id"),
+	}
+
+	var err error
+	for name, id := range map[string]*int64{"api_key_id": &f.APIKeyID, "actor_user_id": &f.ActorUserID,
+		"user_id": &f.UserID} {
+		if v := q.Get(name); v != "" {
+			if *id, err = strconv.ParseInt(v, 10, 64); err != nil || *id <= 0 {
+				return nil, model.Errorf(model.ErrBadRequest, "%s must be a positive number", name)
+			}
+		}
+	}
+	if l := q.Get("limit"); l != "" {
+		if f.Limit, err = strconv.Atoi(l); err != nil || f.Limit <= 0 {
+			return nil, model.Errorf(model.ErrBadRequest, "limit must be a positive number")
+		}
+	}
+	if f.From, err = parseTime(q.Get("from")); err != nil {
+		return nil, model.Errorf(model.ErrBadRequest, "wrong from: %v", err)
+	}
+	if f.To, err = parseTime(q.Get("to")); err != nil {
+		return nil, model.Errorf(model.ErrBadRequest, "wrong to: %v", err)
+	}
+	return f, nil
+}
+
+// parseTime accepts RFC 3339 timestamps and plain dates.
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, s); err != nil {
+			return nil, fmt.Errorf("expected date like 2006-01-02 or 2006-01-02T15:04:05Z")
+		}
+	}
+	return &t, nil
+}
+
+func parseDecimal(s string) (*decimal.Decimal, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return nil, fmt.Errorf("expected a number")
+	}
+	return &d, nil
+}
@@ -0,0 +1,119 @@
+package internalhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// recordWriter keeps a copy of the response, so it can be replayed for retries.
+type recordWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyScope returns the prefix of the Idempotency-Key of the caller of the request,
+// so callers using the same key never get each other's responses.
+func idempotencyScope(r *http.Request) string {
+	if key := model.CallerFromContext(r.Context()); key != nil {
+		return "key:" + strconv.FormatInt(key.ID, 10) + ":"
+	}
+	if userID, ok := model.UserFromContext(r.Context()); ok {
+		return "user:" + strconv.FormatInt(userID, 10) + ":"
+	}
+	return ""
+}
+
+// idempotencyMiddleware returns the stored response for requests repeated with the same Idempotency-Key
+// by the same caller. Requests without the header are passed through as is.
+func (s *Server) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(headerIdempotencyKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			s.writeError(w, r, "process idempotency key", model.Errorf(model.ErrBadRequest, "Idempotency-Key is too long"))
+			return
+		}
+
+		key = idempotencyScope(r) + key
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			s.writeError(w, r, "read body", model.Errorf(model.ErrBadRequest, "%v", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fp := fingerprint(r, body)
+		stored, err := s.app.AcquireIdempotencyKey(r.Context(), key, fp)
+		if err != nil {
+			s.writeError(w, r, "process idempotency key", err)
+			return
+		}
+
+		if stored != nil {
+			switch {
+			case stored.Fingerprint != fp:
+				s.writeError(w, r, "process idempotency key",
+					model.Errorf(model.ErrConflict, "Idempotency-Key was already used with a different request"))
+			case stored.Pending():
+				s.writeError(w, r, "process idempotency key",
+					model.Errorf(model.ErrConflict, "request with this Idempotency-Key is still in progress"))
+			default:
+				w.Header().Set(headerIdempotentReplayed, "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Response)
+			}
+			return
+		}
+
+		rw := &recordWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		// server errors are not final, the client should be able to retry with the same key
+		if rw.status == 0 || rw.status >= http.StatusInternalServerError {
+			if err := s.app.ReleaseIdempotencyKey(r.Context(), key); err != nil {
+				s.logger(r).Errorf("Can't release idempotency key:%v\n", err)
+			}
+			return
+		}
+		if err := s.app.SaveIdempotencyResponse(r.Context(), key, rw.status, rw.body.Bytes()); err != nil {
+			s.logger(r).Errorf("Can't save idempotent response:%v\n", err)
+		}
+	})
+}
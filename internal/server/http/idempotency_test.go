@@ -0,0 +1,58 @@
+package internalhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_IdempotencyKeyScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func(r *http.Request) *http.Request
+		wantKey string
+	}{
+		{
+			name: "API key",
+			ctx: func(r *http.Request) *http.Request {
+				return r.WithContext(model.ContextWithCaller(r.Context(), &model.APIKey{ID: 3}))
+			},
+			wantKey: "key:3:k-1",
+		},
+		{
+			name: "User",
+			ctx: func(r *http.Request) *http.Request {
+				return r.WithContext(model.ContextWithUser(r.Context(), 7))
+			},
+			wantKey: "user:7:k-1",
+		},
+		{
+			name:    "No authentication",
+			ctx:     func(r *http.Request) *http.Request { return r },
+			wantKey: "k-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, app := newTestServer(t)
+			app.On("AcquireIdempotencyKey", mock.Anything, tt.wantKey, mock.Anything).Return(nil, nil)
+			app.On("SaveIdempotencyResponse", mock.Anything, tt.wantKey, http.StatusOK, []byte("ok")).Return(nil)
+
+			h := s.idempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Write([]byte("ok"))
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/users/7/top-ups", strings.NewReader(`{}`))
+			req.Header.Set(headerIdempotencyKey, "k-1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.ctx(req))
+
+			require.Equal(t, http.StatusOK, rec.Code)
+		})
+	}
+}
@@ -2,7 +2,25 @@ package internalhttp
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/cronnoss/avitotech/internal/metrics"
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("github.com/cronnoss/avitotech/internal/server/http")
+
+var (
+	httpRequests = metrics.NewCounter("avitotech_http_requests_total",
+		"HTTP requests by route, method and status.", "route", "method", "status")
+	httpDuration = metrics.NewHistogram("avitotech_http_request_duration_seconds",
+		"Duration of HTTP requests by route and method.", metrics.DefaultBuckets, "route", "method")
 )
 
 type statusWriter struct {
@@ -15,35 +33,142 @@ func (w *statusWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
+// code returns the status of the answer, 200 if the handler wrote nothing.
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Write answers with 200 like http.ResponseWriter when the handler didn't write the status.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// maxRequestIDLength limits the X-Request-ID accepted from the callers like the request_id columns.
+const maxRequestIDLength = 255
+
 type MiddlewareLogger struct{}
 
 func NewMiddlewareLogger() *MiddlewareLogger {
 	return &MiddlewareLogger{}
 }
 
-func (a *MiddlewareLogger) loggingMiddleware(next http.Handler) http.Handler {
+// requestIDMiddleware takes the X-Request-ID of the caller or makes a new one, the ID is echoed in the answer
+// and carried by ctx to the logs, the error answers, the audit log and the transactions of the request.
+func (a *MiddlewareLogger) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(headerRequestID, id)
+		next.ServeHTTP(w, r.WithContext(model.ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// requestID returns the ID given to the request by requestIDMiddleware,
+// the valid X-Request-ID of the caller for the handlers served without it.
+func requestID(r *http.Request) string {
+	if id := model.RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+	if id := r.Header.Get(headerRequestID); validRequestID(id) {
+		return id
+	}
+	return ""
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, they are safe to log and to echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// loggingMiddleware gives the request the child logger with its route, method and X-Request-ID,
+// the handlers log through it, and writes the access log line with the caller when the request is served.
+func (a *MiddlewareLogger) loggingMiddleware(pattern string, next http.Handler) http.Handler {
+	route := routeOf(pattern)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sw := &statusWriter{ResponseWriter: w}
 
-		l := r.Context().Value(KeyLoggerID).(Logger)
+		l := r.Context().Value(KeyLoggerID).(Logger).With("route", route, "method", r.Method)
+		if id := requestID(r); id != "" {
+			l = l.With("request_id", id)
+		}
+		r, caller := withAuthenticated(r)
+		start := time.Now()
+
+		next.ServeHTTP(sw, withLogger(r, l))
+
+		if caller.key != nil {
+			l = l.With("api_key_id", caller.key.ID)
+		}
+		if caller.userID != nil {
+			l = l.With("user_id", *caller.userID)
+		}
+		l.With("status", sw.code(), "duration", time.Since(start).String(),
+			"remote_addr", r.RemoteAddr, "user_agent", r.Header.Get("User-Agent"),
+		).Debugf("%s %s %s\n", r.Method, r.RequestURI, r.Proto)
+	})
+}
+
+// metricsMiddleware counts the requests of the route and measures their duration,
+// the route is the pattern of the mux without the method, so IDs of the path don't make new series.
+func (a *MiddlewareLogger) metricsMiddleware(pattern string, next http.Handler) http.Handler {
+	route := routeOf(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
 		start := time.Now()
 
 		next.ServeHTTP(sw, r)
 
-		l.Debugf("%s [%s] %s %s %s %d %s %s %s\n",
-			r.RemoteAddr,
-			start.Format("02/Jan/2006:15:04:05 -0700"),
-			r.Method,
-			r.RequestURI,
-			r.Proto,
-			sw.status,
-			http.StatusText(sw.status),
-			time.Since(start).String(),
-			r.Header.Get("User-Agent"),
-		)
+		httpDuration.Since(start, route, r.Method)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(sw.code()))
 	})
 }
 
+// tracingMiddleware continues the trace of the caller from the traceparent header or starts a new one,
+// the span of the request is named by the method and the route.
+func (a *MiddlewareLogger) tracingMiddleware(pattern string, next http.Handler) http.Handler {
+	route := routeOf(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path)))
+		defer span.End()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(sw.code()))
+		// errors of the clients are expected answers, only failures of the service fail the span
+		if sw.code() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.code()))
+		}
+	})
+}
+
+// routeOf returns the path of the pattern of the mux without the method.
+func routeOf(pattern string) string {
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
 func (a *MiddlewareLogger) setCommonHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,425 @@
+package internalhttp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cronnoss/avitotech/internal/metrics"
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const apiV1 = "/api/v1"
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+func (s *Server) routes() http.Handler {
+	midLogger := NewMiddlewareLogger()
+	mux := http.NewServeMux()
+
+	// every route requires its scope when the authentication is enabled
+	handle := func(pattern, scope string, h http.HandlerFunc) {
+		mux.Handle(pattern, midLogger.setCommonHeadersMiddleware(midLogger.requestIDMiddleware(
+			midLogger.tracingMiddleware(pattern, midLogger.metricsMiddleware(pattern,
+				midLogger.loggingMiddleware(pattern, s.authMiddleware(scope, h)))))))
+	}
+	// mutating routes replay responses of requests repeated with the same Idempotency-Key,
+	// every call of them is written to the audit log, including the calls rejected by the authentication
+	handleIdempotent := func(pattern, scope string, h http.HandlerFunc) {
+		mux.Handle(pattern, midLogger.setCommonHeadersMiddleware(midLogger.requestIDMiddleware(
+			midLogger.tracingMiddleware(pattern, midLogger.metricsMiddleware(pattern, midLogger.loggingMiddleware(pattern,
+				s.auditMiddleware(pattern, s.authMiddleware(scope, s.idempotencyMiddleware(h)))))))))
+	}
+
+	handle("GET /healthz", "", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK healthz\n"))
+	})
+	handle("GET /readiness", "", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK readiness\n"))
+	})
+	// metrics tell the money moved and the load, Prometheus scrapes them with a key of its own
+	handle("GET /metrics", model.ScopeMetricsRead, metrics.Handler().ServeHTTP)
+
+	// users get their tokens without authentication
+	handle("POST "+apiV1+"/auth/register", "", s.Register)
+	handle("POST "+apiV1+"/auth/login", "", s.Login)
+	handle("POST "+apiV1+"/auth/refresh", "", s.Refresh)
+
+	usersRead, usersWrite := model.ScopeUsersRead, model.ScopeUsersWrite
+	handleIdempotent("POST "+apiV1+"/users", usersWrite, s.CreateUser)
+	handle("GET "+apiV1+"/users", usersRead, s.SearchUsers)
+	handle("GET "+apiV1+"/users/{id}", usersRead, s.GetUser)
+	handleIdempotent("PATCH "+apiV1+"/users/{id}", usersWrite, s.UpdateUser)
+	handleIdempotent("DELETE "+apiV1+"/users/{id}", usersWrite, s.DeleteUser)
+
+	// support and fraud teams stop the money movement on accounts
+	admin := model.ScopeAccountsAdmin
+	handleIdempotent("PUT "+apiV1+"/users/{id}/status", admin, s.SetAccountStatus)
+	handle("GET "+apiV1+"/users/{id}/status-changes", admin, s.GetAccountStatusChanges)
+	handleIdempotent("POST "+apiV1+"/users/{id}/closure", admin, s.CloseAccount)
+	handle("GET "+apiV1+"/audit", model.ScopeAuditRead, s.GetAuditLog)
+
+	read, credit, debit := model.ScopeBalanceRead, model.ScopeBalanceCredit, model.ScopeBalanceDebit
+	handle("GET "+apiV1+"/users/{id}/balance", read, s.GetUserBalance)
+	handle("GET "+apiV1+"/users/{id}/wallets", read, s.GetUserWallets)
+	handle("GET "+apiV1+"/users/{id}/wallets/{currency}", read, s.GetUserWallet)
+	handleIdempotent("POST "+apiV1+"/users/{id}/top-ups", credit, s.UserTopUp)
+	handleIdempotent("POST "+apiV1+"/users/{id}/debits", debit, s.UserDebit)
+	handle("GET "+apiV1+"/users/{id}/transactions", read, s.GetUserTransactions)
+	handle("GET "+apiV1+"/users/{id}/transfers", read, s.GetUserTransfers)
+	handle("GET "+apiV1+"/transactions", read, s.FindTransactions)
+	handle("GET "+apiV1+"/transactions/{id}", read, s.GetTransaction)
+	handleIdempotent("POST "+apiV1+"/transactions/{id}/refunds", credit, s.Refund)
+	handleIdempotent("POST "+apiV1+"/transfers", model.ScopeTransfer, s.CreateTransfer)
+	handle("GET "+apiV1+"/transfers/{id}", read, s.GetTransfer)
+	handleIdempotent("POST "+apiV1+"/reservations", debit, s.Reserve)
+	handleIdempotent("POST "+apiV1+"/reservations/capture", debit, s.CaptureReservation)
+	handleIdempotent("POST "+apiV1+"/reservations/release", debit, s.ReleaseReservation)
+	handle("GET "+apiV1+"/exchange-rates", read, s.GetExchangeRates)
+	handleIdempotent("POST "+apiV1+"/exchange-rates", model.ScopeRatesWrite, s.AddExchangeRate)
+	handleIdempotent("POST "+apiV1+"/fx/quotes", debit, s.CreateQuote)
+
+	if s.legacyRoutes {
+		s.legacy(handle, handleIdempotent)
+	}
+	return s.routeErrors(mux)
+}
+
+// legacy registers the deprecated routes, they read user_id from the body and accept any method.
+func (s *Server) legacy(handle, handleIdempotent func(string, string, http.HandlerFunc)) {
+	read, credit, debit := model.ScopeBalanceRead, model.ScopeBalanceCredit, model.ScopeBalanceDebit
+	handle("/balance", read, s.GetBalance)
+	handleIdempotent("/top-up", credit, s.TopUp)
+	handleIdempotent("/debit", debit, s.Debit)
+	handleIdempotent("/transfer", model.ScopeTransfer, s.Transfer)
+	handle("GET /transfers", read, s.GetTransfers)
+	handle("GET /transfers/{id}", read, s.GetTransfer)
+	handleIdempotent("/reserve", debit, s.Reserve)
+	handleIdempotent("/reserve/capture", debit, s.CaptureReservation)
+	handleIdempotent("/reserve/release", debit, s.ReleaseReservation)
+	handle("/transaction", read, s.GetTransactions)
+	handle("GET /transactions/{id}", read, s.GetTransaction)
+	handleIdempotent("POST /transactions/{id}/refund", credit, s.Refund)
+}
+
+// routeErrorWriter holds back the plain text answers of the mux for unknown routes and methods.
+type routeErrorWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *routeErrorWriter) WriteHeader(status int) {
+	if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *routeErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// routeErrors answers in the error envelope when no route matches the request.
+func (s *Server) routeErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &routeErrorWriter{ResponseWriter: w}
+		mux.ServeHTTP(rw, r)
+		if rw.status == 0 {
+			return
+		}
+
+		w.Header().Del("X-Content-Type-Options")
+		w.Header().Set("Content-Type", "application/json")
+		if rw.status == http.StatusMethodNotAllowed {
+			s.writeError(w, r, "route request", model.Errorf(errMethodNotAllowed, "method %s is not allowed", r.Method))
+			return
+		}
+		s.writeError(w, r, "route request", model.Errorf(model.ErrNotFound, "no route for %s", r.URL.Path))
+	})
+}
+
+// CreateUser creates a user for a service, the password is optional.
+func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var user model.User
+	if err := s.helperDecode(r, w, &user); err != nil {
+		return
+	}
+	ans, err := s.app.CreateUser(r.Context(), &user)
+	if err != nil {
+		s.writeError(w, r, "create user", err)
+		return
+	}
+	writeJSON(w, r, "user", ans, s)
+}
+
+// SearchUsers finds users by the beginning of the username and the email.
+func (s *Server) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := &model.UserFilter{Username: q.Get("username"), Email: q.Get("email")}
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(l); err != nil || filter.Limit <= 0 {
+			s.writeError(w, r, "search users", model.Errorf(model.ErrBadRequest, "limit must be a positive number"))
+			return
+		}
+	}
+	ans, err := s.app.SearchUsers(r.Context(), filter)
+	if err != nil {
+		s.writeError(w, r, "search users", err)
+		return
+	}
+	writeJSON(w, r, "users", ans, s)
+}
+
+func (s *Server) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get user", err)
+		return
+	}
+	ans, err := s.app.GetUser(r.Context(), id)
+	if err != nil {
+		s.writeError(w, r, "get user", err)
+		return
+	}
+	writeJSON(w, r, "user", ans, s)
+}
+
+// UpdateUser changes the fields of the profile present in the body.
+func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "update user", err)
+		return
+	}
+	var update model.UserUpdate
+	if err := s.helperDecode(r, w, &update); err != nil {
+		return
+	}
+	ans, err := s.app.UpdateUser(r.Context(), id, &update)
+	if err != nil {
+		s.writeError(w, r, "update user", err)
+		return
+	}
+	writeJSON(w, r, "user", ans, s)
+}
+
+// DeleteUser deletes the user softly and answers with it.
+func (s *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "delete user", err)
+		return
+	}
+	ans, err := s.app.DeleteUser(r.Context(), id)
+	if err != nil {
+		s.writeError(w, r, "delete user", err)
+		return
+	}
+	writeJSON(w, r, "user", ans, s)
+}
+
+// SetAccountStatus freezes or unfreezes the account, the body has the status and the reason.
+func (s *Server) SetAccountStatus(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "set account status", err)
+		return
+	}
+	var change model.AccountStatusChange
+	if err := s.helperDecode(r, w, &change); err != nil {
+		return
+	}
+	change.UserID = userID
+	ans, err := s.app.SetAccountStatus(r.Context(), &change)
+	if err != nil {
+		s.writeError(w, r, "set account status", err)
+		return
+	}
+	writeJSON(w, r, "user", ans, s)
+}
+
+func (s *Server) GetAccountStatusChanges(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get account status changes", err)
+		return
+	}
+	ans, err := s.app.GetAccountStatusChanges(r.Context(), userID)
+	if err != nil {
+		s.writeError(w, r, "get account status changes", err)
+		return
+	}
+	writeJSON(w, r, "status_changes", ans, s)
+}
+
+// CloseAccount closes the account, the body has the reason and optionally payout_user_id.
+func (s *Server) CloseAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "close account", err)
+		return
+	}
+	var closure model.AccountClosure
+	if err := s.helperDecode(r, w, &closure); err != nil {
+		return
+	}
+	closure.UserID = userID
+	ans, err := s.app.CloseAccount(r.Context(), &closure)
+	if err != nil {
+		s.writeError(w, r, "close account", err)
+		return
+	}
+	writeJSON(w, r, "user", ans, s)
+}
+
+// GetAuditLog answers with a page of the audit log, the query parameters filter the records.
+func (s *Server) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		s.writeError(w, r, "get audit log", err)
+		return
+	}
+	ans, err := s.app.GetAuditLog(r.Context(), filter, r.URL.Query().Get("cursor"))
+	if err != nil {
+		s.writeError(w, r, "get audit log", err)
+		return
+	}
+	writeBody(w, r, ans, s)
+}
+
+func (s *Server) GetUserBalance(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get balance", err)
+		return
+	}
+	s.balance(w, r, userID, "")
+}
+
+func (s *Server) GetUserWallets(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get wallets", err)
+		return
+	}
+	ans, err := s.app.GetWallets(r.Context(), userID)
+	if err != nil {
+		s.writeError(w, r, "get wallets", err)
+		return
+	}
+	writeJSON(w, r, "wallets", ans, s)
+}
+
+func (s *Server) GetUserWallet(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get balance", err)
+		return
+	}
+	s.balance(w, r, userID, r.PathValue("currency"))
+}
+
+func (s *Server) UserTopUp(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "top up", err)
+		return
+	}
+	var transaction model.Transaction
+	if err := s.helperDecode(r, w, &transaction); err != nil {
+		return
+	}
+	transaction.UserID = userID
+	s.topUp(w, r, &transaction)
+}
+
+func (s *Server) UserDebit(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "debit", err)
+		return
+	}
+	var transaction model.Transaction
+	if err := s.helperDecode(r, w, &transaction); err != nil {
+		return
+	}
+	transaction.UserID = userID
+	s.debit(w, r, &transaction)
+}
+
+func (s *Server) GetUserTransactions(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get transactions", err)
+		return
+	}
+	s.transactions(w, r, userID)
+}
+
+// FindTransactions answers with the transactions of all users made by the call with request_id.
+func (s *Server) FindTransactions(w http.ResponseWriter, r *http.Request) {
+	s.transactions(w, r, 0)
+}
+
+func (s *Server) GetUserTransfers(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get transfers", err)
+		return
+	}
+	s.transfers(w, r, userID)
+}
+
+func (s *Server) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	at, err := parseTime(r.URL.Query().Get("at"))
+	if err != nil {
+		s.writeError(w, r, "get exchange rates", model.Errorf(model.ErrBadRequest, "wrong at: %v", err))
+		return
+	}
+	ans, err := s.app.GetExchangeRates(r.Context(), at)
+	if err != nil {
+		s.writeError(w, r, "get exchange rates", err)
+		return
+	}
+	writeJSON(w, r, "exchange_rates", ans, s)
+}
+
+// AddExchangeRate stores a manual rate that overrides the provider rates.
+func (s *Server) AddExchangeRate(w http.ResponseWriter, r *http.Request) {
+	var rate model.ExchangeRate
+	if err := s.helperDecode(r, w, &rate); err != nil {
+		return
+	}
+	ans, err := s.app.AddExchangeRate(r.Context(), &rate)
+	if err != nil {
+		s.writeError(w, r, "add exchange rate", err)
+		return
+	}
+	writeJSON(w, r, "exchange_rate", ans, s)
+}
+
+// CreateQuote locks the ruble price of a foreign-currency purchase for a debit with quote_id.
+func (s *Server) CreateQuote(w http.ResponseWriter, r *http.Request) {
+	var quote model.Quote
+	if err := s.helperDecode(r, w, &quote); err != nil {
+		return
+	}
+	ans, err := s.app.CreateQuote(r.Context(), &quote)
+	if err != nil {
+		s.writeError(w, r, "create quote", err)
+		return
+	}
+	writeJSON(w, r, "quote", ans, s)
+}
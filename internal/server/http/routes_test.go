@@ -0,0 +1,490 @@
+package internalhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/logger"
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/server/mocks"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestServer_Routes(t *testing.T) {
+	tests := []struct {
+		name         string
+		legacyRoutes bool
+		method       string
+		target       string
+		body         string
+		mock         func(app *mocks.Application)
+		wantStatus   int
+		wantCode     string
+	}{
+		{
+			name:   "Balance by path",
+			method: http.MethodGet,
+			target: "/api/v1/users/7/balance",
+			mock: func(app *mocks.Application) {
+				app.On("GetBalance", mock.Anything, &model.Balance{UserID: 7}).
+					Return(&model.Balance{ID: 1, UserID: 7, Amount: decimal.NewFromInt(10)}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Top-up by path",
+			method: http.MethodPost,
+			target: "/api/v1/users/7/top-ups",
+			body:   `{"amount": 10}`,
+			mock: func(app *mocks.Application) {
+				app.On("TopUp", mock.Anything, mock.MatchedBy(func(tr *model.Transaction) bool {
+					return tr.UserID == 7 && tr.Amount.Equal(decimal.NewFromInt(10))
+				})).Return(&model.Balance{ID: 1, UserID: 7, Amount: decimal.NewFromInt(10)}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Wallets by path",
+			method: http.MethodGet,
+			target: "/api/v1/users/7/wallets",
+			mock: func(app *mocks.Application) {
+				app.On("GetWallets", mock.Anything, int64(7)).Return([]model.Balance{
+					{ID: 1, UserID: 7, Currency: model.CurrencyRUB, Amount: decimal.NewFromInt(10)},
+					{ID: 2, UserID: 7, Currency: "USD", Amount: decimal.NewFromInt(1)},
+				}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Balance at past rates",
+			method: http.MethodGet,
+			target: "/api/v1/users/7/balance?currency=USD&at=2026-01-01",
+			mock: func(app *mocks.Application) {
+				balance := &model.Balance{ID: 1, UserID: 7, Amount: decimal.NewFromInt(900)}
+				app.On("GetBalance", mock.Anything, &model.Balance{UserID: 7}).Return(balance, nil)
+				app.On("ConvertBalance", mock.Anything, balance, "USD", mock.MatchedBy(func(at *time.Time) bool {
+					return at != nil && at.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+				})).Return(&model.Balance{ID: 1, UserID: 7, Amount: decimal.NewFromInt(10)}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Balance at wrong date",
+			method:     http.MethodGet,
+			target:     "/api/v1/users/7/balance?currency=USD&at=yesterday",
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:   "Manual exchange rate",
+			method: http.MethodPost,
+			target: "/api/v1/exchange-rates",
+			body:   `{"currency": "USD", "rate": 90.5, "valid_from": "2026-01-01T00:00:00Z"}`,
+			mock: func(app *mocks.Application) {
+				app.On("AddExchangeRate", mock.Anything, mock.MatchedBy(func(r *model.ExchangeRate) bool {
+					return r.Currency == "USD" && r.Rate.Equal(decimal.NewFromFloat(90.5))
+				})).Return(&model.ExchangeRate{ID: 1, Currency: "USD", Rate: decimal.NewFromFloat(90.5)}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "FX quote",
+			method: http.MethodPost,
+			target: "/api/v1/fx/quotes",
+			body:   `{"user_id": 7, "amount": 10, "currency": "USD"}`,
+			mock: func(app *mocks.Application) {
+				app.On("CreateQuote", mock.Anything, mock.MatchedBy(func(q *model.Quote) bool {
+					return q.UserID == 7 && q.Currency == "USD" && q.Amount.Equal(decimal.NewFromInt(10))
+				})).Return(&model.Quote{ID: 1, UserID: 7, QuotedAmount: decimal.NewFromInt(909)}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Debit at quote",
+			method: http.MethodPost,
+			target: "/api/v1/users/7/debits",
+			body:   `{"quote_id": 1, "service_id": 10, "order_id": 100}`,
+			mock: func(app *mocks.Application) {
+				app.On("Debit", mock.Anything, mock.MatchedBy(func(tr *model.Transaction) bool {
+					return tr.UserID == 7 && tr.QuoteID != nil && *tr.QuoteID == 1 && tr.Amount.IsZero()
+				})).Return(&model.Balance{ID: 1, UserID: 7, Amount: decimal.NewFromInt(91)}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Create user",
+			method: http.MethodPost,
+			target: "/api/v1/users",
+			body:   `{"name": "John Doe", "username": "johndoe", "email": "john@example.com"}`,
+			mock: func(app *mocks.Application) {
+				app.On("CreateUser", mock.Anything, &model.User{Name: "John Doe", Username: "johndoe",
+					Email: "john@example.com"}).
+					Return(&model.User{ID: 4, Name: "John Doe", Username: "johndoe", Email: "john@example.com"}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Duplicate user",
+			method: http.MethodPost,
+			target: "/api/v1/users",
+			body:   `{"name": "John Doe", "username": "johndoe", "email": "john@example.com"}`,
+			mock: func(app *mocks.Application) {
+				app.On("CreateUser", mock.Anything, mock.Anything).
+					Return(nil, model.Errorf(model.ErrConflict, "user with this username or email already exists"))
+			},
+			wantStatus: http.StatusConflict,
+			wantCode:   "conflict",
+		},
+		{
+			name:   "Search users",
+			method: http.MethodGet,
+			target: "/api/v1/users?username=john&limit=5",
+			mock: func(app *mocks.Application) {
+				app.On("SearchUsers", mock.Anything, &model.UserFilter{Username: "john", Limit: 5}).
+					Return([]model.User{{ID: 4, Name: "John Doe", Username: "johndoe", Email: "john@example.com"}}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Update user",
+			method: http.MethodPatch,
+			target: "/api/v1/users/4",
+			body:   `{"email": "jane@example.com"}`,
+			mock: func(app *mocks.Application) {
+				app.On("UpdateUser", mock.Anything, int64(4), mock.MatchedBy(func(u *model.UserUpdate) bool {
+					return u.Name == nil && u.Username == nil && u.Email != nil && *u.Email == "jane@example.com"
+				})).Return(&model.User{ID: 4, Name: "John Doe", Username: "johndoe", Email: "jane@example.com"}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Delete user",
+			method: http.MethodDelete,
+			target: "/api/v1/users/4",
+			mock: func(app *mocks.Application) {
+				deletedAt := time.Now()
+				app.On("DeleteUser", mock.Anything, int64(4)).
+					Return(&model.User{ID: 4, Name: "John Doe", Username: "johndoe", DeletedAt: &deletedAt}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Freeze account",
+			method: http.MethodPut,
+			target: "/api/v1/users/4/status",
+			body:   `{"status": "debit_frozen", "reason": "chargeback"}`,
+			mock: func(app *mocks.Application) {
+				app.On("SetAccountStatus", mock.Anything, &model.AccountStatusChange{UserID: 4,
+					Status: model.AccountDebitFrozen, Reason: "chargeback"}).
+					Return(&model.User{ID: 4, Status: model.AccountDebitFrozen}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Debit from frozen account",
+			method: http.MethodPost,
+			target: "/api/v1/users/4/debits",
+			body:   `{"amount": 10}`,
+			mock: func(app *mocks.Application) {
+				app.On("Debit", mock.Anything, mock.Anything).
+					Return(nil, model.Errorf(model.ErrAccountFrozen, "account of user 4 is debit-frozen"))
+			},
+			wantStatus: http.StatusConflict,
+			wantCode:   "account_frozen",
+		},
+		{
+			name:   "Close account with payout",
+			method: http.MethodPost,
+			target: "/api/v1/users/4/closure",
+			body:   `{"reason": "user request", "payout_user_id": 9}`,
+			mock: func(app *mocks.Application) {
+				app.On("CloseAccount", mock.Anything, mock.MatchedBy(func(c *model.AccountClosure) bool {
+					return c.UserID == 4 && c.Reason == "user request" && c.PayoutUserID != nil && *c.PayoutUserID == 9
+				})).Return(&model.User{ID: 4, Status: model.AccountClosed}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Wrong method",
+			method:     http.MethodPost,
+			target:     "/api/v1/users/7/balance",
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCode:   "method_not_allowed",
+		},
+		{
+			name:       "Unknown route",
+			method:     http.MethodGet,
+			target:     "/api/v1/users/7/wallet",
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+		},
+		{
+			name:       "Legacy route disabled",
+			method:     http.MethodGet,
+			target:     "/balance",
+			body:       `{"user_id": 7}`,
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+		},
+		{
+			name:         "Legacy route enabled",
+			legacyRoutes: true,
+			method:       http.MethodGet,
+			target:       "/balance",
+			body:         `{"user_id": 7}`,
+			mock: func(app *mocks.Application) {
+				app.On("GetBalance", mock.Anything, &model.Balance{UserID: 7}).
+					Return(&model.Balance{ID: 1, UserID: 7, Amount: decimal.NewFromInt(10)}, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := mocks.NewApplication(t)
+			log := newTestLogger(t)
+			app.On("RecordAudit", mock.Anything, mock.Anything).Return(nil).Maybe()
+			tt.mock(app)
+			s := NewServer(log, app, "localhost", "0", tt.legacyRoutes, false)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), KeyLoggerID, Logger(log)))
+			rec := httptest.NewRecorder()
+			s.routes().ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+			require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			if tt.wantCode != "" {
+				var got struct {
+					Error errorResponse `json:"error"`
+				}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				require.Equal(t, tt.wantCode, got.Error.Code)
+			}
+			if tt.wantStatus == http.StatusMethodNotAllowed {
+				require.Contains(t, rec.Header().Get("Allow"), http.MethodGet)
+			}
+		})
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	log := newTestLogger(t)
+	app := mocks.NewApplication(t)
+	app.On("AuthenticateKey", mock.Anything, "ak_prometheus").
+		Return(&model.APIKey{ID: 5, Name: "prometheus", Scopes: model.ScopeMetricsRead}, nil)
+	app.On("AuthenticateKey", mock.Anything, "ak_billing").
+		Return(&model.APIKey{ID: 3, Name: "billing", Scopes: model.ScopeBalanceRead}, nil)
+	handler := NewServer(log, app, "localhost", "0", false, true).routes()
+
+	serve := func(target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set(headerAPIKey, key)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), KeyLoggerID, Logger(log)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the request without a key is rejected and still counted
+	require.Equal(t, http.StatusUnauthorized, serve("/api/v1/users/7/wallets", "").Code)
+
+	// the metrics need a key with their scope
+	require.Equal(t, http.StatusUnauthorized, serve("/metrics", "").Code)
+	require.Equal(t, http.StatusForbidden, serve("/metrics", "ak_billing").Code)
+	rec := serve("/metrics", "ak_prometheus")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(),
+		`avitotech_http_requests_total{route="/api/v1/users/{id}/wallets",method="GET",status="401"} 1`+"\n")
+	require.Contains(t, rec.Body.String(),
+		`avitotech_http_request_duration_seconds_count{route="/api/v1/users/{id}/wallets",method="GET"} `)
+}
+
+func TestServer_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	log := newTestLogger(t)
+	app := mocks.NewApplication(t)
+	app.On("GetWallets", mock.Anything, int64(7)).Return([]model.Balance{}, nil)
+	handler := NewServer(log, app, "localhost", "0", false, false).routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/7/wallets", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req = req.WithContext(context.WithValue(req.Context(), KeyLoggerID, Logger(log)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// the span of the request continues the trace of the caller
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /api/v1/users/{id}/wallets", spans[0].Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.Contains(t, spans[0].Attributes(), semconv.HTTPResponseStatusCode(http.StatusOK))
+}
+
+func TestServer_RequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := logger.New(logger.Conf{Level: "DEBUG", Format: logger.FormatJSON}, &buf)
+	app := mocks.NewApplication(t)
+	app.On("AuthenticateKey", mock.Anything, "ak_1").
+		Return(&model.APIKey{ID: 3, Scopes: model.ScopeBalanceRead}, nil)
+	app.On("GetWallets", mock.Anything, int64(7)).Return(nil, errors.New("connection refused"))
+	handler := NewServer(log, app, "localhost", "0", false, true).routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/7/wallets", nil)
+	req.Header.Set(headerAPIKey, "ak_1")
+	req.Header.Set(headerRequestID, "req-1")
+	req = req.WithContext(context.WithValue(req.Context(), KeyLoggerID, Logger(log)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// the error of the handler and the access log carry the fields of the request
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var failure, access map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &failure))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &access))
+
+	require.Equal(t, "error", failure["level"])
+	require.Equal(t, "Can't get wallets:connection refused", failure["msg"])
+	require.Equal(t, "/api/v1/users/{id}/wallets", failure["route"])
+	require.Equal(t, http.MethodGet, failure["method"])
+	require.Equal(t, "req-1", failure["request_id"])
+	require.Equal(t, float64(3), failure["api_key_id"])
+
+	require.Equal(t, "debug", access["level"])
+	require.Equal(t, "req-1", access["request_id"])
+	require.Equal(t, float64(http.StatusInternalServerError), access["status"])
+	require.Equal(t, float64(3), access["api_key_id"])
+}
+
+func TestServer_RequestID(t *testing.T) {
+	fromCaller := mock.MatchedBy(func(ctx context.Context) bool {
+		return model.RequestIDFromContext(ctx) == "billing-42"
+	})
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		body      string
+		requestID string
+		mock      func(app *mocks.Application)
+		// wantID is the ID of the answer, a new one is made without it
+		wantID string
+	}{
+		{
+			name:      "Top-up carries the ID of the caller to the transaction",
+			method:    http.MethodPost,
+			target:    "/api/v1/users/7/top-ups",
+			body:      `{"amount":"10","source":"bank_card"}`,
+			requestID: "billing-42",
+			mock: func(app *mocks.Application) {
+				app.On("TopUp", fromCaller, mock.Anything).
+					Return(&model.Balance{ID: 1, UserID: 7, Amount: decimal.NewFromInt(10)}, nil)
+				app.On("RecordAudit", fromCaller, mock.MatchedBy(func(a *model.AuditRecord) bool {
+					return a.RequestID == "billing-42"
+				})).Return(nil)
+			},
+			wantID: "billing-42",
+		},
+		{
+			name:      "Transactions of the request",
+			method:    http.MethodGet,
+			target:    "/api/v1/users/7/transactions?request_id=billing-42",
+			requestID: "support-1",
+			mock: func(app *mocks.Application) {
+				app.On("GetTransactions", mock.Anything, mock.MatchedBy(func(f *model.TransactionFilter) bool {
+					return f.UserID == 7 && f.RequestID == "billing-42"
+				}), "").Return(&model.TransactionPage{}, nil)
+			},
+			wantID: "support-1",
+		},
+		{
+			name:      "Transactions of the request of all users",
+			method:    http.MethodGet,
+			target:    "/api/v1/transactions?request_id=billing-42",
+			requestID: "support-1",
+			mock: func(app *mocks.Application) {
+				app.On("GetTransactions", mock.Anything, mock.MatchedBy(func(f *model.TransactionFilter) bool {
+					return f.UserID == 0 && f.RequestID == "billing-42"
+				}), "").Return(&model.TransactionPage{}, nil)
+			},
+			wantID: "support-1",
+		},
+		{
+			name:   "Error answer without the ID of the caller",
+			method: http.MethodGet,
+			target: "/api/v1/users/7/wallets",
+			mock: func(app *mocks.Application) {
+				app.On("GetWallets", mock.Anything, int64(7)).Return(nil, model.ErrNotFound)
+			},
+		},
+		{
+			name:      "Error answer with the wrong ID of the caller",
+			method:    http.MethodGet,
+			target:    "/api/v1/users/7/wallets",
+			requestID: "id with spaces",
+			mock: func(app *mocks.Application) {
+				app.On("GetWallets", mock.Anything, int64(7)).Return(nil, model.ErrNotFound)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := mocks.NewApplication(t)
+			tt.mock(app)
+			log := newTestLogger(t)
+			handler := NewServer(log, app, "localhost", "0", false, false).routes()
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.requestID != "" {
+				req.Header.Set(headerRequestID, tt.requestID)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), KeyLoggerID, Logger(log)))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(headerRequestID)
+			if tt.wantID != "" {
+				require.Equal(t, tt.wantID, id)
+			} else {
+				require.Len(t, id, 16)
+			}
+			if rec.Code >= http.StatusBadRequest {
+				var got struct {
+					Error errorResponse `json:"error"`
+				}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				require.Equal(t, id, got.Error.RequestID)
+			}
+		})
+	}
+}
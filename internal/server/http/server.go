@@ -3,10 +3,9 @@ package internalhttp
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/cronnoss/avitotech/internal/model"
@@ -15,47 +14,75 @@ import (
 
 type ctxKeyID int
 
+// maxBodySize limits the bodies of requests read by the server.
+const maxBodySize = 1 << 20
+
 const (
 	KeyLoggerID ctxKeyID = iota
+	keyAuthenticatedID
 )
 
 type Server struct {
-	srv  http.Server
-	app  server.Application
-	log  Logger
-	host string
-	port string
+	srv          http.Server
+	app          server.Application
+	log          Logger
+	host         string
+	port         string
+	legacyRoutes bool
+	auth         bool
+}
+
+type Logger = server.Logger
+
+// NewServer returns the server of the /api/v1 routes, legacyRoutes also enables
+// the deprecated routes reading user_id from the body, auth requires API keys with the scopes of the routes.
+func NewServer(log Logger, app server.Application, host, port string, legacyRoutes, auth bool) *Server {
+	return &Server{log: log, app: app, host: host, port: port, legacyRoutes: legacyRoutes, auth: auth}
 }
 
-type Logger interface {
-	Fatalf(format string, a ...interface{})
-	Errorf(format string, a ...interface{})
-	Warningf(format string, a ...interface{})
-	Infof(format string, a ...interface{})
-	Debugf(format string, a ...interface{})
+// logger returns the logger of the request with its fields, the logger of the server out of requests.
+func (s *Server) logger(r *http.Request) Logger {
+	if l, ok := r.Context().Value(KeyLoggerID).(Logger); ok {
+		return l
+	}
+	return s.log
 }
 
-func NewServer(log Logger, app server.Application, host, port string) *Server {
-	return &Server{log: log, app: app, host: host, port: port}
+// withLogger returns the request logging through l.
+func withLogger(r *http.Request, l Logger) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), KeyLoggerID, l))
 }
 
-func (s *Server) helperDecode(stream io.ReadCloser, w http.ResponseWriter, data interface{}) error {
-	decoder := json.NewDecoder(stream)
+func (s *Server) helperDecode(r *http.Request, w http.ResponseWriter, data interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
 	if err := decoder.Decode(&data); err != nil {
-		s.log.Errorf("Can't decode json:%v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("{\"error\": \"Can't decode json:%v\"}\n", err)))
+		s.writeError(w, r, "decode json", model.Errorf(model.ErrBadRequest, "%v", err))
 		return err
 	}
 	return nil
 }
 
-func writeResponse(w http.ResponseWriter, _ error, ans *model.Balance, s *Server) {
-	responseBytes, err := json.Marshal(map[string]interface{}{"balance": ans})
+// pathID returns the ID from the path of the request.
+func pathID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, model.Errorf(model.ErrBadRequest, "wrong ID %q", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, ans *model.Balance, s *Server) {
+	writeJSON(w, r, "balance", ans, s)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, key string, ans interface{}, s *Server) {
+	writeBody(w, r, map[string]interface{}{key: ans}, s)
+}
+
+func writeBody(w http.ResponseWriter, r *http.Request, ans interface{}, s *Server) {
+	responseBytes, err := json.Marshal(ans)
 	if err != nil {
-		s.log.Errorf("Can't marshal response: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("{\"error\": \"Can't process response\"}\n"))
+		s.writeError(w, r, "process response", err)
 		return
 	}
 	w.Write(responseBytes)
@@ -63,133 +90,248 @@ func writeResponse(w http.ResponseWriter, _ error, ans *model.Balance, s *Server
 
 func (s *Server) GetBalance(w http.ResponseWriter, r *http.Request) {
 	var balance model.Balance
-	if err := s.helperDecode(r.Body, w, &balance); err != nil {
+	if err := s.helperDecode(r, w, &balance); err != nil {
 		return
 	}
+	s.balance(w, r, balance.UserID, balance.Currency)
+}
 
+// balance answers with the balance of the wallet of the user, converted to the currency of the query if it is set.
+func (s *Server) balance(w http.ResponseWriter, r *http.Request, userID int64, wallet string) {
 	currency := r.URL.Query().Get("currency")
+	at, err := parseTime(r.URL.Query().Get("at"))
+	if err != nil {
+		s.writeError(w, r, "get balance", model.Errorf(model.ErrBadRequest, "wrong at: %v", err))
+		return
+	}
 
-	ans, err := s.app.GetBalance(r.Context(), &balance)
+	ans, err := s.app.GetBalance(r.Context(), &model.Balance{UserID: userID, Currency: wallet})
 	if err != nil {
-		s.log.Errorf("Can't get balance:%v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("{\"error\": \"Can't get balance:%v\"}\n", err)))
+		s.writeError(w, r, "get balance", err)
 		return
 	}
 
 	if currency != "" {
-		ans, err = s.app.ConvertBalance(r.Context(), ans, currency)
+		ans, err = s.app.ConvertBalance(r.Context(), ans, currency, at)
 		if err != nil {
-			s.log.Errorf("Can't convert balance:%v\n", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(fmt.Sprintf("{\"error\": \"Can't convert balance:%v\"}\n", err)))
+			s.writeError(w, r, "convert balance", err)
 			return
 		}
 	}
-	writeResponse(w, err, ans, s)
+	writeResponse(w, r, ans, s)
 }
 
 func (s *Server) GetTransactions(w http.ResponseWriter, r *http.Request) {
 	var balance model.Balance
-	if err := s.helperDecode(r.Body, w, &balance); err != nil {
+	if err := s.helperDecode(r, w, &balance); err != nil {
 		return
 	}
+	s.transactions(w, r, balance.UserID)
+}
 
-	sort := r.URL.Query().Get("sort")
+func (s *Server) transactions(w http.ResponseWriter, r *http.Request, userID int64) {
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		s.writeError(w, r, "get transactions", err)
+		return
+	}
+	filter.UserID = userID
 
-	ans, err := s.app.GetTransactions(r.Context(), balance.UserID, sort)
+	ans, err := s.app.GetTransactions(r.Context(), filter, r.URL.Query().Get("cursor"))
 	if err != nil {
-		s.log.Errorf("Can't get transactions:%v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("{\"error\": \"Can't get transactions:%v\"}\n", err)))
+		s.writeError(w, r, "get transactions", err)
 		return
 	}
-	responseBytes, err := json.Marshal(map[string]interface{}{"transactions": ans})
+	writeBody(w, r, ans, s)
+}
+
+func (s *Server) GetTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
 	if err != nil {
-		s.log.Errorf("Can't marshal response: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("{\"error\": \"Can't process response\"}\n"))
+		s.writeError(w, r, "get transaction", err)
 		return
 	}
-	w.Write(responseBytes)
+	ans, err := s.app.GetTransaction(r.Context(), id)
+	if err != nil {
+		s.writeError(w, r, "get transaction", err)
+		return
+	}
+	writeJSON(w, r, "transaction", ans, s)
+}
+
+func (s *Server) Refund(w http.ResponseWriter, r *http.Request) {
+	// amount is optional, the rest of the transaction is refunded without it
+	var refund model.Refund
+	if err := s.helperDecode(r, w, &refund); err != nil {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "refund", err)
+		return
+	}
+	refund.TransactionID = id
+
+	ans, err := s.app.Refund(r.Context(), &refund)
+	if err != nil {
+		s.writeError(w, r, "refund", err)
+		return
+	}
+	writeJSON(w, r, "transaction", ans, s)
 }
 
 func (s *Server) TopUp(w http.ResponseWriter, r *http.Request) {
-	var balance model.Balance
-	if err := s.helperDecode(r.Body, w, &balance); err != nil {
+	var transaction model.Transaction
+	if err := s.helperDecode(r, w, &transaction); err != nil {
 		return
 	}
-	ans, err := s.app.TopUp(r.Context(), balance.UserID, balance.Amount)
+	s.topUp(w, r, &transaction)
+}
+
+func (s *Server) topUp(w http.ResponseWriter, r *http.Request, transaction *model.Transaction) {
+	ans, err := s.app.TopUp(r.Context(), transaction)
 	if err != nil {
-		s.log.Errorf("Can't Top up:%v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("{\"error\": \"Can't Top up:%v\"}\n", err)))
+		s.writeError(w, r, "top up", err)
 		return
 	}
-	writeResponse(w, err, ans, s)
+	writeResponse(w, r, ans, s)
 }
 
 func (s *Server) Debit(w http.ResponseWriter, r *http.Request) {
-	var balance model.Balance
-	if err := s.helperDecode(r.Body, w, &balance); err != nil {
+	var transaction model.Transaction
+	if err := s.helperDecode(r, w, &transaction); err != nil {
 		return
 	}
-	ans, err := s.app.Debit(r.Context(), balance.UserID, balance.Amount)
+	s.debit(w, r, &transaction)
+}
+
+func (s *Server) debit(w http.ResponseWriter, r *http.Request, transaction *model.Transaction) {
+	ans, err := s.app.Debit(r.Context(), transaction)
 	if err != nil {
-		s.log.Errorf("Can't Debit:%v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("{\"error\": \"Can't Debit:%v\"}\n", err)))
+		s.writeError(w, r, "debit", err)
 		return
 	}
-	writeResponse(w, err, ans, s)
+	writeResponse(w, r, ans, s)
 }
 
+// Transfer is the legacy transfer, it answers with the wallet of the recipient.
 func (s *Server) Transfer(w http.ResponseWriter, r *http.Request) {
+	ans, err := s.transfer(w, r)
+	if err != nil {
+		return
+	}
+	writeResponse(w, r, ans.ToBalance, s)
+}
+
+// CreateTransfer answers with the created transfer.
+func (s *Server) CreateTransfer(w http.ResponseWriter, r *http.Request) {
+	ans, err := s.transfer(w, r)
+	if err != nil {
+		return
+	}
+	writeJSON(w, r, "transfer", ans, s)
+}
+
+// transfer makes the transfer of the body, its error is already answered.
+func (s *Server) transfer(w http.ResponseWriter, r *http.Request) (*model.Transfer, error) {
 	var transfer model.Transfer
-	if err := s.helperDecode(r.Body, w, &transfer); err != nil {
+	if err := s.helperDecode(r, w, &transfer); err != nil {
+		return nil, err
+	}
+	ans, err := s.app.Transfer(r.Context(), &transfer)
+	if err != nil {
+		s.writeError(w, r, "transfer", err)
+		return nil, err
+	}
+	return ans, nil
+}
+
+func (s *Server) GetTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		s.writeError(w, r, "get transfer", err)
+		return
+	}
+	ans, err := s.app.GetTransfer(r.Context(), id)
+	if err != nil {
+		s.writeError(w, r, "get transfer", err)
+		return
+	}
+	writeJSON(w, r, "transfer", ans, s)
+}
+
+func (s *Server) GetTransfers(w http.ResponseWriter, r *http.Request) {
+	var balance model.Balance
+	if err := s.helperDecode(r, w, &balance); err != nil {
+		return
+	}
+	s.transfers(w, r, balance.UserID)
+}
+
+func (s *Server) transfers(w http.ResponseWriter, r *http.Request, userID int64) {
+	filter := &model.TransferFilter{UserID: userID}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(l); err != nil || filter.Limit <= 0 {
+			s.writeError(w, r, "get transfers", model.Errorf(model.ErrBadRequest, "limit must be a positive number"))
+			return
+		}
+	}
+
+	ans, err := s.app.GetTransfers(r.Context(), filter, r.URL.Query().Get("cursor"))
+	if err != nil {
+		s.writeError(w, r, "get transfers", err)
+		return
+	}
+	writeBody(w, r, ans, s)
+}
+
+func (s *Server) Reserve(w http.ResponseWriter, r *http.Request) {
+	var reservation model.Reservation
+	if err := s.helperDecode(r, w, &reservation); err != nil {
+		return
+	}
+	ans, err := s.app.Reserve(r.Context(), &reservation)
+	if err != nil {
+		s.writeError(w, r, "reserve", err)
+		return
+	}
+	writeJSON(w, r, "reservation", ans, s)
+}
+
+func (s *Server) CaptureReservation(w http.ResponseWriter, r *http.Request) {
+	// amount is optional, the whole reservation is captured without it
+	var reservation model.Reservation
+	if err := s.helperDecode(r, w, &reservation); err != nil {
+		return
+	}
+	ans, err := s.app.CaptureReservation(r.Context(), &reservation, reservation.Amount)
+	if err != nil {
+		s.writeError(w, r, "capture", err)
+		return
+	}
+	writeResponse(w, r, ans, s)
+}
+
+func (s *Server) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	var reservation model.Reservation
+	if err := s.helperDecode(r, w, &reservation); err != nil {
 		return
 	}
-	ans, err := s.app.Transfer(r.Context(), transfer.FromID, transfer.ToID, transfer.Amount)
+	ans, err := s.app.ReleaseReservation(r.Context(), &reservation)
 	if err != nil {
-		s.log.Errorf("Can't Transfer:%v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("{\"error\": \"Can't Transfer:%v\"}\n", err)))
+		s.writeError(w, r, "release", err)
 		return
 	}
-	writeResponse(w, err, ans, s)
+	writeResponse(w, r, ans, s)
 }
 
 func (s *Server) Start(ctx context.Context) error {
 	addr := net.JoinHostPort(s.host, s.port)
-	midLogger := NewMiddlewareLogger()
-	mux := http.NewServeMux()
-
-	mux.Handle("/healthz", midLogger.setCommonHeadersMiddleware(
-		midLogger.loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("OK healthz\n"))
-		}))))
-
-	mux.Handle("/readiness", midLogger.setCommonHeadersMiddleware(
-		midLogger.loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("OK readiness\n"))
-		}))))
-
-	mux.Handle("/balance", midLogger.setCommonHeadersMiddleware(
-		midLogger.loggingMiddleware(http.HandlerFunc(s.GetBalance))))
-	mux.Handle("/top-up", midLogger.setCommonHeadersMiddleware(
-		midLogger.loggingMiddleware(http.HandlerFunc(s.TopUp))))
-	mux.Handle("/debit", midLogger.setCommonHeadersMiddleware(
-		midLogger.loggingMiddleware(http.HandlerFunc(s.Debit))))
-	mux.Handle("/transfer", midLogger.setCommonHeadersMiddleware(
-		midLogger.loggingMiddleware(http.HandlerFunc(s.Transfer))))
-	mux.Handle("/transaction", midLogger.setCommonHeadersMiddleware(
-		midLogger.loggingMiddleware(http.HandlerFunc(s.GetTransactions))))
 
 	s.srv = http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           s.routes(),
 		ReadHeaderTimeout: 2 * time.Second,
 		BaseContext: func(_ net.Listener) context.Context {
 			bCtx := context.WithValue(ctx, KeyLoggerID, s.log)
@@ -0,0 +1,228 @@
+package internalhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/cronnoss/avitotech/internal/server/mocks"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) (*Server, *mocks.Application) {
+	t.Helper()
+	app := mocks.NewApplication(t)
+	return NewServer(newTestLogger(t), app, "localhost", "0", false, false), app
+}
+
+// newTestLogger returns the logger taking any lines, its child loggers are the logger itself.
+func newTestLogger(t *testing.T) *mocks.Logger {
+	t.Helper()
+	log := mocks.NewLogger(t)
+	anything := func(n int) []interface{} {
+		args := make([]interface{}, n)
+		for i := range args {
+			args[i] = mock.Anything
+		}
+		return args
+	}
+	for n := 1; n <= 4; n++ {
+		for _, method := range []string{"Errorf", "Warningf", "Debugf"} {
+			log.On(method, anything(n)...).Maybe()
+		}
+	}
+	for _, n := range []int{2, 4, 8} {
+		log.On("With", anything(n)...).Return(log).Maybe()
+	}
+	return log
+}
+
+func TestServer_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    func(s *Server) http.HandlerFunc
+		body       string
+		pathID     string
+		mock       func(app *mocks.Application)
+		wantStatus int
+		wantCode   string
+		wantInMsg  string
+	}{
+		{
+			name:    "Balance of unknown user",
+			handler: func(s *Server) http.HandlerFunc { return s.GetBalance },
+			body:    `{"user_id": 42}`,
+			mock: func(app *mocks.Application) {
+				app.On("GetBalance", mock.Anything, &model.Balance{UserID: 42}).
+					Return(nil, model.Errorf(model.ErrNotFound, "user with ID 42 has no balance"))
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+			wantInMsg:  "user with ID 42 has no balance",
+		},
+		{
+			name:    "Debit with insufficient funds",
+			handler: func(s *Server) http.HandlerFunc { return s.Debit },
+			body:    `{"user_id": 1, "amount": 100}`,
+			mock: func(app *mocks.Application) {
+				app.On("Debit", mock.Anything, mock.Anything).
+					Return(nil, model.Errorf(model.ErrInsufficientFunds, "insufficient funds"))
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   "insufficient_funds",
+			wantInMsg:  "insufficient funds",
+		},
+		{
+			name:    "Top-up with negative amount",
+			handler: func(s *Server) http.HandlerFunc { return s.TopUp },
+			body:    `{"user_id": 1, "amount": -1}`,
+			mock: func(app *mocks.Application) {
+				app.On("TopUp", mock.Anything, mock.Anything).
+					Return(nil, model.Errorf(model.ErrInvalidAmount, "amount must be greater than zero"))
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   "invalid_amount",
+			wantInMsg:  "amount must be greater than zero",
+		},
+		{
+			name:    "Refund of refunded transaction",
+			handler: func(s *Server) http.HandlerFunc { return s.Refund },
+			body:    `{}`,
+			pathID:  "5",
+			mock: func(app *mocks.Application) {
+				app.On("Refund", mock.Anything, &model.Refund{TransactionID: 5}).
+					Return(nil, model.Errorf(model.ErrConflict, "transaction 5 is already refunded"))
+			},
+			wantStatus: http.StatusConflict,
+			wantCode:   "conflict",
+			wantInMsg:  "transaction 5 is already refunded",
+		},
+		{
+			name:       "Wrong transaction ID",
+			handler:    func(s *Server) http.HandlerFunc { return s.GetTransaction },
+			pathID:     "abc",
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+			wantInMsg:  "wrong ID",
+		},
+		{
+			name:       "Broken json",
+			handler:    func(s *Server) http.HandlerFunc { return s.Transfer },
+			body:       `{"user_id": `,
+			mock:       func(_ *mocks.Application) {},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:    "Database is down",
+			handler: func(s *Server) http.HandlerFunc { return s.GetBalance },
+			body:    `{"user_id": 1}`,
+			mock: func(app *mocks.Application) {
+				app.On("GetBalance", mock.Anything, mock.Anything).
+					Return(nil, errors.Join(model.ErrUnavailable, errors.New("dial tcp 127.0.0.1:5432: connect refused")))
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   "unavailable",
+		},
+		{
+			name:    "Unexpected error",
+			handler: func(s *Server) http.HandlerFunc { return s.GetBalance },
+			body:    `{"user_id": 1}`,
+			mock: func(app *mocks.Application) {
+				app.On("GetBalance", mock.Anything, mock.Anything).
+					Return(nil, errors.New("pq: relation \"balances\" does not exist"))
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   "internal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, app := newTestServer(t)
+			tt.mock(app)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set(headerRequestID, "req-1")
+			req.SetPathValue("id", tt.pathID)
+			rec := httptest.NewRecorder()
+			tt.handler(s)(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+			var got struct {
+				Error errorResponse `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			require.Equal(t, tt.wantCode, got.Error.Code)
+			require.Equal(t, "req-1", got.Error.RequestID)
+			require.Contains(t, got.Error.Message, tt.wantInMsg)
+			// details of the infrastructure stay in the log
+			require.NotContains(t, got.Error.Message, "tcp")
+			require.NotContains(t, got.Error.Message, "relation")
+		})
+	}
+}
+
+func TestServer_TopUp(t *testing.T) {
+	s, app := newTestServer(t)
+	app.On("TopUp", mock.Anything, mock.MatchedBy(func(tr *model.Transaction) bool {
+		return tr.UserID == 1 && tr.Amount.Equal(decimal.NewFromInt(100))
+	})).Return(&model.Balance{ID: 1, UserID: 1, Amount: decimal.NewFromInt(100)}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/top-up", strings.NewReader(`{"user_id": 1, "amount": 100}`))
+	rec := httptest.NewRecorder()
+	s.TopUp(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got struct {
+		Balance model.Balance `json:"balance"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	require.True(t, got.Balance.Amount.Equal(decimal.NewFromInt(100)))
+}
+
+func TestServer_Transfer(t *testing.T) {
+	app := mocks.NewApplication(t)
+	log := newTestLogger(t)
+	app.On("RecordAudit", mock.Anything, mock.Anything).Return(nil).Maybe()
+	app.On("Transfer", mock.Anything, mock.MatchedBy(func(tr *model.Transfer) bool {
+		return tr.FromID == 1 && tr.ToID == 2 && tr.Amount.Equal(decimal.NewFromInt(5))
+	})).Return(&model.Transfer{
+		ID: 3, FromID: 1, ToID: 2, Amount: decimal.NewFromInt(5), Status: model.TransferCompleted,
+		ToBalance: &model.Balance{ID: 2, UserID: 2, Amount: decimal.NewFromInt(15)},
+	}, nil)
+	handler := NewServer(log, app, "localhost", "0", true, false).routes()
+
+	transfer := func(target string) []byte {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"user_id": 1, "to_id": 2, "amount": 5}`))
+		req = req.WithContext(context.WithValue(req.Context(), KeyLoggerID, Logger(log)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.Bytes()
+	}
+
+	// the legacy route keeps answering with the wallet of the recipient
+	var legacy map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(transfer("/transfer"), &legacy))
+	require.NotContains(t, legacy, "transfer")
+	var balance model.Balance
+	require.NoError(t, json.Unmarshal(legacy["balance"], &balance))
+	require.Equal(t, int64(2), balance.UserID)
+	require.True(t, balance.Amount.Equal(decimal.NewFromInt(15)))
+
+	var got struct {
+		Transfer model.Transfer `json:"transfer"`
+	}
+	require.NoError(t, json.Unmarshal(transfer("/api/v1/transfers"), &got))
+	require.Equal(t, int64(3), got.Transfer.ID)
+	require.Equal(t, model.TransferCompleted, got.Transfer.Status)
+}
@@ -9,6 +9,8 @@ import (
 	mock "github.com/stretchr/testify/mock"
 
 	model "github.com/cronnoss/avitotech/internal/model"
+
+	time "time"
 )
 
 // Application is an autogenerated mock type for the Application type
@@ -16,28 +18,28 @@ type Application struct {
 	mock.Mock
 }
 
-// ConvertBalance provides a mock function with given fields: _a0, _a1, _a2
-func (_m *Application) ConvertBalance(_a0 context.Context, _a1 *model.Balance, _a2 string) (*model.Balance, error) {
+// AcquireIdempotencyKey provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Application) AcquireIdempotencyKey(_a0 context.Context, _a1 string, _a2 string) (*model.IdempotencyKey, error) {
 	ret := _m.Called(_a0, _a1, _a2)
 
 	if len(ret) == 0 {
-		panic("no return value specified for ConvertBalance")
+		panic("no return value specified for AcquireIdempotencyKey")
 	}
 
-	var r0 *model.Balance
+	var r0 *model.IdempotencyKey
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance, string) (*model.Balance, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (*model.IdempotencyKey, error)); ok {
 		return rf(_a0, _a1, _a2)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance, string) *model.Balance); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *model.IdempotencyKey); ok {
 		r0 = rf(_a0, _a1, _a2)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*model.Balance)
+			r0 = ret.Get(0).(*model.IdempotencyKey)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, *model.Balance, string) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
 		r1 = rf(_a0, _a1, _a2)
 	} else {
 		r1 = ret.Error(1)
@@ -46,20 +48,138 @@ func (_m *Application) ConvertBalance(_a0 context.Context, _a1 *model.Balance, _
 	return r0, r1
 }
 
-// Debit provides a mock function with given fields: _a0, _a1, _a2
-func (_m *Application) Debit(_a0 context.Context, _a1 int64, _a2 decimal.Decimal) (*model.Balance, error) {
+// AddExchangeRate provides a mock function with given fields: _a0, _a1
+func (_m *Application) AddExchangeRate(_a0 context.Context, _a1 *model.ExchangeRate) (*model.ExchangeRate, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AddExchangeRate")
+	}
+
+	var r0 *model.ExchangeRate
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.ExchangeRate) (*model.ExchangeRate, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.ExchangeRate) *model.ExchangeRate); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ExchangeRate)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.ExchangeRate) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AuthenticateKey provides a mock function with given fields: _a0, _a1
+func (_m *Application) AuthenticateKey(_a0 context.Context, _a1 string) (*model.APIKey, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AuthenticateKey")
+	}
+
+	var r0 *model.APIKey
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*model.APIKey, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.APIKey); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.APIKey)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AuthenticateSignature provides a mock function with given fields: _a0, _a1
+func (_m *Application) AuthenticateSignature(_a0 context.Context, _a1 *model.SignedRequest) (*model.APIKey, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AuthenticateSignature")
+	}
+
+	var r0 *model.APIKey
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.SignedRequest) (*model.APIKey, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.SignedRequest) *model.APIKey); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.APIKey)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.SignedRequest) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AuthenticateToken provides a mock function with given fields: _a0, _a1
+func (_m *Application) AuthenticateToken(_a0 context.Context, _a1 string) (int64, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AuthenticateToken")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (int64, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CaptureReservation provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Application) CaptureReservation(_a0 context.Context, _a1 *model.Reservation, _a2 decimal.Decimal) (*model.Balance, error) {
 	ret := _m.Called(_a0, _a1, _a2)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Debit")
+		panic("no return value specified for CaptureReservation")
 	}
 
 	var r0 *model.Balance
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, decimal.Decimal) (*model.Balance, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Reservation, decimal.Decimal) (*model.Balance, error)); ok {
 		return rf(_a0, _a1, _a2)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, decimal.Decimal) *model.Balance); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Reservation, decimal.Decimal) *model.Balance); ok {
 		r0 = rf(_a0, _a1, _a2)
 	} else {
 		if ret.Get(0) != nil {
@@ -67,7 +187,7 @@ func (_m *Application) Debit(_a0 context.Context, _a1 int64, _a2 decimal.Decimal
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, decimal.Decimal) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Reservation, decimal.Decimal) error); ok {
 		r1 = rf(_a0, _a1, _a2)
 	} else {
 		r1 = ret.Error(1)
@@ -76,20 +196,140 @@ func (_m *Application) Debit(_a0 context.Context, _a1 int64, _a2 decimal.Decimal
 	return r0, r1
 }
 
-// GetBalance provides a mock function with given fields: _a0, _a1
-func (_m *Application) GetBalance(_a0 context.Context, _a1 *model.Balance) (*model.Balance, error) {
+// CloseAccount provides a mock function with given fields: _a0, _a1
+func (_m *Application) CloseAccount(_a0 context.Context, _a1 *model.AccountClosure) (*model.User, error) {
 	ret := _m.Called(_a0, _a1)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetBalance")
+		panic("no return value specified for CloseAccount")
+	}
+
+	var r0 *model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AccountClosure) (*model.User, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AccountClosure) *model.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.AccountClosure) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ConvertBalance provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *Application) ConvertBalance(_a0 context.Context, _a1 *model.Balance, _a2 string, _a3 *time.Time) (*model.Balance, error) {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ConvertBalance")
 	}
 
 	var r0 *model.Balance
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance) (*model.Balance, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance, string, *time.Time) (*model.Balance, error)); ok {
+		return rf(_a0, _a1, _a2, _a3)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance, string, *time.Time) *model.Balance); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Balance, string, *time.Time) error); ok {
+		r1 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateQuote provides a mock function with given fields: _a0, _a1
+func (_m *Application) CreateQuote(_a0 context.Context, _a1 *model.Quote) (*model.Quote, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateQuote")
+	}
+
+	var r0 *model.Quote
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Quote) (*model.Quote, error)); ok {
 		return rf(_a0, _a1)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance) *model.Balance); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Quote) *model.Quote); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Quote)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Quote) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateUser provides a mock function with given fields: _a0, _a1
+func (_m *Application) CreateUser(_a0 context.Context, _a1 *model.User) (*model.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateUser")
+	}
+
+	var r0 *model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.User) (*model.User, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.User) *model.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.User) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Debit provides a mock function with given fields: _a0, _a1
+func (_m *Application) Debit(_a0 context.Context, _a1 *model.Transaction) (*model.Balance, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Debit")
+	}
+
+	var r0 *model.Balance
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Transaction) (*model.Balance, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Transaction) *model.Balance); ok {
 		r0 = rf(_a0, _a1)
 	} else {
 		if ret.Get(0) != nil {
@@ -97,7 +337,7 @@ func (_m *Application) GetBalance(_a0 context.Context, _a1 *model.Balance) (*mod
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, *model.Balance) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Transaction) error); ok {
 		r1 = rf(_a0, _a1)
 	} else {
 		r1 = ret.Error(1)
@@ -106,29 +346,59 @@ func (_m *Application) GetBalance(_a0 context.Context, _a1 *model.Balance) (*mod
 	return r0, r1
 }
 
-// GetTransactions provides a mock function with given fields: _a0, _a1, _a2
-func (_m *Application) GetTransactions(_a0 context.Context, _a1 int64, _a2 string) ([]model.Transaction, error) {
-	ret := _m.Called(_a0, _a1, _a2)
+// DeleteUser provides a mock function with given fields: _a0, _a1
+func (_m *Application) DeleteUser(_a0 context.Context, _a1 int64) (*model.User, error) {
+	ret := _m.Called(_a0, _a1)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetTransactions")
+		panic("no return value specified for DeleteUser")
 	}
 
-	var r0 []model.Transaction
+	var r0 *model.User
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, string) ([]model.Transaction, error)); ok {
-		return rf(_a0, _a1, _a2)
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*model.User, error)); ok {
+		return rf(_a0, _a1)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, string) []model.Transaction); ok {
-		r0 = rf(_a0, _a1, _a2)
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *model.User); ok {
+		r0 = rf(_a0, _a1)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]model.Transaction)
+			r0 = ret.Get(0).(*model.User)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, string) error); ok {
-		r1 = rf(_a0, _a1, _a2)
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountStatusChanges provides a mock function with given fields: _a0, _a1
+func (_m *Application) GetAccountStatusChanges(_a0 context.Context, _a1 int64) ([]model.AccountStatusChange, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetAccountStatusChanges")
+	}
+
+	var r0 []model.AccountStatusChange
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]model.AccountStatusChange, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []model.AccountStatusChange); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AccountStatusChange)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(_a0, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -136,28 +406,28 @@ func (_m *Application) GetTransactions(_a0 context.Context, _a1 int64, _a2 strin
 	return r0, r1
 }
 
-// TopUp provides a mock function with given fields: _a0, _a1, _a2
-func (_m *Application) TopUp(_a0 context.Context, _a1 int64, _a2 decimal.Decimal) (*model.Balance, error) {
+// GetAuditLog provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Application) GetAuditLog(_a0 context.Context, _a1 *model.AuditFilter, _a2 string) (*model.AuditPage, error) {
 	ret := _m.Called(_a0, _a1, _a2)
 
 	if len(ret) == 0 {
-		panic("no return value specified for TopUp")
+		panic("no return value specified for GetAuditLog")
 	}
 
-	var r0 *model.Balance
+	var r0 *model.AuditPage
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, decimal.Decimal) (*model.Balance, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AuditFilter, string) (*model.AuditPage, error)); ok {
 		return rf(_a0, _a1, _a2)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, decimal.Decimal) *model.Balance); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AuditFilter, string) *model.AuditPage); ok {
 		r0 = rf(_a0, _a1, _a2)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*model.Balance)
+			r0 = ret.Get(0).(*model.AuditPage)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, decimal.Decimal) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, *model.AuditFilter, string) error); ok {
 		r1 = rf(_a0, _a1, _a2)
 	} else {
 		r1 = ret.Error(1)
@@ -166,29 +436,623 @@ func (_m *Application) TopUp(_a0 context.Context, _a1 int64, _a2 decimal.Decimal
 	return r0, r1
 }
 
-// Transfer provides a mock function with given fields: _a0, _a1, _a2, _a3
-func (_m *Application) Transfer(_a0 context.Context, _a1 int64, _a2 int64, _a3 decimal.Decimal) (*model.Balance, error) {
-	ret := _m.Called(_a0, _a1, _a2, _a3)
+// GetBalance provides a mock function with given fields: _a0, _a1
+func (_m *Application) GetBalance(_a0 context.Context, _a1 *model.Balance) (*model.Balance, error) {
+	ret := _m.Called(_a0, _a1)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Transfer")
+		panic("no return value specified for GetBalance")
 	}
 
 	var r0 *model.Balance
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, decimal.Decimal) (*model.Balance, error)); ok {
-		return rf(_a0, _a1, _a2, _a3)
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance) (*model.Balance, error)); ok {
+		return rf(_a0, _a1)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, decimal.Decimal) *model.Balance); ok {
-		r0 = rf(_a0, _a1, _a2, _a3)
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Balance) *model.Balance); ok {
+		r0 = rf(_a0, _a1)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*model.Balance)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, decimal.Decimal) error); ok {
-		r1 = rf(_a0, _a1, _a2, _a3)
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Balance) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetExchangeRates provides a mock function with given fields: _a0, _a1
+func (_m *Application) GetExchangeRates(_a0 context.Context, _a1 *time.Time) ([]model.ExchangeRate, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetExchangeRates")
+	}
+
+	var r0 []model.ExchangeRate
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *time.Time) ([]model.ExchangeRate, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *time.Time) []model.ExchangeRate); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ExchangeRate)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *time.Time) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTransaction provides a mock function with given fields: _a0, _a1
+func (_m *Application) GetTransaction(_a0 context.Context, _a1 int64) (*model.Transaction, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetTransaction")
+	}
+
+	var r0 *model.Transaction
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*model.Transaction, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *model.Transaction); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Transaction)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTransactions provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Application) GetTransactions(_a0 context.Context, _a1 *model.TransactionFilter, _a2 string) (*model.TransactionPage, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetTransactions")
+	}
+
+	var r0 *model.TransactionPage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TransactionFilter, string) (*model.TransactionPage, error)); ok {
+		return rf(_a0, _a1, _a2)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TransactionFilter, string) *model.TransactionPage); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TransactionPage)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.TransactionFilter, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTransfer provides a mock function with given fields: _a0, _a1
+func (_m *Application) GetTransfer(_a0 context.Context, _a1 int64) (*model.Transfer, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetTransfer")
+	}
+
+	var r0 *model.Transfer
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*model.Transfer, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *model.Transfer); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Transfer)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTransfers provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Application) GetTransfers(_a0 context.Context, _a1 *model.TransferFilter, _a2 string) (*model.TransferPage, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetTransfers")
+	}
+
+	var r0 *model.TransferPage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TransferFilter, string) (*model.TransferPage, error)); ok {
+		return rf(_a0, _a1, _a2)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TransferFilter, string) *model.TransferPage); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TransferPage)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.TransferFilter, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUser provides a mock function with given fields: _a0, _a1
+func (_m *Application) GetUser(_a0 context.Context, _a1 int64) (*model.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUser")
+	}
+
+	var r0 *model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*model.User, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *model.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWallets provides a mock function with given fields: _a0, _a1
+func (_m *Application) GetWallets(_a0 context.Context, _a1 int64) ([]model.Balance, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetWallets")
+	}
+
+	var r0 []model.Balance
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]model.Balance, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []model.Balance); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Balance)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Login provides a mock function with given fields: _a0, _a1
+func (_m *Application) Login(_a0 context.Context, _a1 *model.Credentials) (*model.Tokens, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Login")
+	}
+
+	var r0 *model.Tokens
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Credentials) (*model.Tokens, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Credentials) *model.Tokens); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Tokens)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Credentials) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RecordAudit provides a mock function with given fields: _a0, _a1
+func (_m *Application) RecordAudit(_a0 context.Context, _a1 *model.AuditRecord) error {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RecordAudit")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AuditRecord) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Refresh provides a mock function with given fields: _a0, _a1
+func (_m *Application) Refresh(_a0 context.Context, _a1 string) (*model.Tokens, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Refresh")
+	}
+
+	var r0 *model.Tokens
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*model.Tokens, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Tokens); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Tokens)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Refund provides a mock function with given fields: _a0, _a1
+func (_m *Application) Refund(_a0 context.Context, _a1 *model.Refund) (*model.Transaction, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Refund")
+	}
+
+	var r0 *model.Transaction
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Refund) (*model.Transaction, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Refund) *model.Transaction); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Transaction)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Refund) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Register provides a mock function with given fields: _a0, _a1
+func (_m *Application) Register(_a0 context.Context, _a1 *model.User) (*model.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Register")
+	}
+
+	var r0 *model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.User) (*model.User, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.User) *model.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.User) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReleaseIdempotencyKey provides a mock function with given fields: _a0, _a1
+func (_m *Application) ReleaseIdempotencyKey(_a0 context.Context, _a1 string) error {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReleaseIdempotencyKey")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReleaseReservation provides a mock function with given fields: _a0, _a1
+func (_m *Application) ReleaseReservation(_a0 context.Context, _a1 *model.Reservation) (*model.Balance, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReleaseReservation")
+	}
+
+	var r0 *model.Balance
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Reservation) (*model.Balance, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Reservation) *model.Balance); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Reservation) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Reserve provides a mock function with given fields: _a0, _a1
+func (_m *Application) Reserve(_a0 context.Context, _a1 *model.Reservation) (*model.Reservation, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Reserve")
+	}
+
+	var r0 *model.Reservation
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Reservation) (*model.Reservation, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Reservation) *model.Reservation); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Reservation)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Reservation) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveIdempotencyResponse provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *Application) SaveIdempotencyResponse(_a0 context.Context, _a1 string, _a2 int, _a3 []byte) error {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveIdempotencyResponse")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, []byte) error); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SearchUsers provides a mock function with given fields: _a0, _a1
+func (_m *Application) SearchUsers(_a0 context.Context, _a1 *model.UserFilter) ([]model.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SearchUsers")
+	}
+
+	var r0 []model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.UserFilter) ([]model.User, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.UserFilter) []model.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.UserFilter) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetAccountStatus provides a mock function with given fields: _a0, _a1
+func (_m *Application) SetAccountStatus(_a0 context.Context, _a1 *model.AccountStatusChange) (*model.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetAccountStatus")
+	}
+
+	var r0 *model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AccountStatusChange) (*model.User, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AccountStatusChange) *model.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.AccountStatusChange) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// TopUp provides a mock function with given fields: _a0, _a1
+func (_m *Application) TopUp(_a0 context.Context, _a1 *model.Transaction) (*model.Balance, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TopUp")
+	}
+
+	var r0 *model.Balance
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Transaction) (*model.Balance, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Transaction) *model.Balance); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Transaction) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Transfer provides a mock function with given fields: _a0, _a1
+func (_m *Application) Transfer(_a0 context.Context, _a1 *model.Transfer) (*model.Transfer, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Transfer")
+	}
+
+	var r0 *model.Transfer
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Transfer) (*model.Transfer, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Transfer) *model.Transfer); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Transfer)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Transfer) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateUser provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Application) UpdateUser(_a0 context.Context, _a1 int64, _a2 *model.UserUpdate) (*model.User, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateUser")
+	}
+
+	var r0 *model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, *model.UserUpdate) (*model.User, error)); ok {
+		return rf(_a0, _a1, _a2)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, *model.UserUpdate) *model.User); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, *model.UserUpdate) error); ok {
+		r1 = rf(_a0, _a1, _a2)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -2,7 +2,10 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	server "github.com/cronnoss/avitotech/internal/server"
+	mock "github.com/stretchr/testify/mock"
+)
 
 // Logger is an autogenerated mock type for the Logger type
 type Logger struct {
@@ -49,6 +52,28 @@ func (_m *Logger) Warningf(format string, a ...interface{}) {
 	_m.Called(_ca...)
 }
 
+// With provides a mock function with given fields: keyvals
+func (_m *Logger) With(keyvals ...interface{}) server.Logger {
+	var _ca []interface{}
+	_ca = append(_ca, keyvals...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for With")
+	}
+
+	var r0 server.Logger
+	if rf, ok := ret.Get(0).(func(...interface{}) server.Logger); ok {
+		r0 = rf(keyvals...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(server.Logger)
+		}
+	}
+
+	return r0
+}
+
 // NewLogger creates a new instance of Logger. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewLogger(t interface {
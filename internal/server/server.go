@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/cronnoss/avitotech/internal/model"
 	"github.com/shopspring/decimal"
@@ -19,16 +20,48 @@ type Logger interface {
 	Warningf(format string, a ...interface{})
 	Infof(format string, a ...interface{})
 	Debugf(format string, a ...interface{})
+	// With returns the child logger writing the keys and values on every line.
+	With(keyvals ...interface{}) Logger
 }
 
 //go:generate mockery --name Application
 type Application interface {
 	GetBalance(context.Context, *model.Balance) (*model.Balance, error)
-	TopUp(context.Context, int64, decimal.Decimal) (*model.Balance, error)
-	Debit(context.Context, int64, decimal.Decimal) (*model.Balance, error)
-	Transfer(context.Context, int64, int64, decimal.Decimal) (*model.Balance, error)
-	GetTransactions(context.Context, int64, string) ([]model.Transaction, error)
-	ConvertBalance(context.Context, *model.Balance, string) (*model.Balance, error)
+	GetWallets(context.Context, int64) ([]model.Balance, error)
+	TopUp(context.Context, *model.Transaction) (*model.Balance, error)
+	Debit(context.Context, *model.Transaction) (*model.Balance, error)
+	Transfer(context.Context, *model.Transfer) (*model.Transfer, error)
+	GetTransfer(context.Context, int64) (*model.Transfer, error)
+	GetTransfers(context.Context, *model.TransferFilter, string) (*model.TransferPage, error)
+	GetTransactions(context.Context, *model.TransactionFilter, string) (*model.TransactionPage, error)
+	GetTransaction(context.Context, int64) (*model.Transaction, error)
+	Refund(context.Context, *model.Refund) (*model.Transaction, error)
+	ConvertBalance(context.Context, *model.Balance, string, *time.Time) (*model.Balance, error)
+	GetExchangeRates(context.Context, *time.Time) ([]model.ExchangeRate, error)
+	AddExchangeRate(context.Context, *model.ExchangeRate) (*model.ExchangeRate, error)
+	CreateQuote(context.Context, *model.Quote) (*model.Quote, error)
+	AuthenticateKey(context.Context, string) (*model.APIKey, error)
+	AuthenticateSignature(context.Context, *model.SignedRequest) (*model.APIKey, error)
+	AuthenticateToken(context.Context, string) (int64, error)
+	Register(context.Context, *model.User) (*model.User, error)
+	Login(context.Context, *model.Credentials) (*model.Tokens, error)
+	CreateUser(context.Context, *model.User) (*model.User, error)
+	GetUser(context.Context, int64) (*model.User, error)
+	UpdateUser(context.Context, int64, *model.UserUpdate) (*model.User, error)
+	SearchUsers(context.Context, *model.UserFilter) ([]model.User, error)
+	DeleteUser(context.Context, int64) (*model.User, error)
+	SetAccountStatus(context.Context, *model.AccountStatusChange) (*model.User, error)
+	GetAccountStatusChanges(context.Context, int64) ([]model.AccountStatusChange, error)
+	CloseAccount(context.Context, *model.AccountClosure) (*model.User, error)
+	RecordAudit(context.Context, *model.AuditRecord) error
+	GetAuditLog(context.Context, *model.AuditFilter, string) (*model.AuditPage, error)
+	Refresh(context.Context, string) (*model.Tokens, error)
+	Reserve(context.Context, *model.Reservation) (*model.Reservation, error)
+	CaptureReservation(context.Context, *model.Reservation, decimal.Decimal) (*model.Balance, error)
+	ReleaseReservation(context.Context, *model.Reservation) (*model.Balance, error)
+	AcquireIdempotencyKey(context.Context, string, string) (*model.IdempotencyKey, error)
+	SaveIdempotencyResponse(context.Context, string, int, []byte) error
+	ReleaseIdempotencyKey(context.Context, string) error
 }
 
 func Exitfail(msg string) {
@@ -0,0 +1,167 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const statusChangeColumns = `id, user_id, status, previous_status, reason, api_key_id, created_at`
+
+// SetAccountStatus changes the status of the account of c.UserID and records the change.
+// A closed account keeps its status.
+func (s *Storage) SetAccountStatus(ctx context.Context, c *model.AccountStatusChange) (*model.User, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	user, err := lockUser(ctx, tx, c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	switch user.Status {
+	case model.AccountClosed:
+		return nil, model.Errorf(model.ErrConflict, "account of user %d is closed", c.UserID)
+	case c.Status:
+		return nil, model.Errorf(model.ErrConflict, "account of user %d is already %s", c.UserID, c.Status)
+	}
+
+	ans, err := updateAccountStatus(ctx, tx, user, c.Status, c.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit account status", err)
+	}
+	return ans, nil
+}
+
+// ListAccountStatusChanges returns the changes of the account status of the user from oldest to newest.
+func (s *Storage) ListAccountStatusChanges(ctx context.Context, userID int64) ([]model.AccountStatusChange, error) {
+	query := "SELECT " + statusChangeColumns + " FROM account_status_changes WHERE user_id = $1 ORDER BY id"
+	ans := []model.AccountStatusChange{}
+	if err := s.db.SelectContext(ctx, &ans, query, userID); err != nil {
+		return nil, dbError("get account status changes", err)
+	}
+	return ans, nil
+}
+
+// CloseAccount closes the account of c.UserID. The money left in its wallets is transferred
+// to the wallets of c.PayoutUserID, without it the wallets must be empty. Money held by reservations
+// must be captured or released first.
+func (s *Storage) CloseAccount(ctx context.Context, c *model.AccountClosure) (*model.User, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	user, err := lockUser(ctx, tx, c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == model.AccountClosed {
+		return nil, model.Errorf(model.ErrConflict, "account of user %d is already closed", c.UserID)
+	}
+
+	var wallets []model.Balance
+	query := "SELECT " + balanceColumns + " FROM balances WHERE user_id = $1 ORDER BY currency FOR UPDATE"
+	if err = tx.SelectContext(ctx, &wallets, query, c.UserID); err != nil {
+		return nil, dbError("lock balances", err)
+	}
+	for _, w := range wallets {
+		if w.Reserved.IsPositive() {
+			return nil, model.Errorf(model.ErrConflict, "account of user %d has %s %s reserved",
+				c.UserID, w.Reserved.StringFixed(2), w.Currency)
+		}
+		if w.Amount.IsPositive() && c.PayoutUserID == nil {
+			return nil, model.Errorf(model.ErrConflict, "account of user %d has %s %s left, it must be paid out",
+				c.UserID, w.Amount.StringFixed(2), w.Currency)
+		}
+	}
+
+	var payouts []*model.Transfer
+	if c.PayoutUserID != nil {
+		if err = checkAccount(ctx, tx, *c.PayoutUserID, false); err != nil {
+			return nil, err
+		}
+		for _, w := range wallets {
+			if !w.Amount.IsPositive() {
+				continue
+			}
+			payout, err := transfer(ctx, tx, &model.Transfer{
+				FromID:     c.UserID,
+				ToID:       *c.PayoutUserID,
+				Amount:     w.Amount,
+				Currency:   w.Currency,
+				ToAmount:   w.Amount,
+				ToCurrency: w.Currency,
+				Comment:    c.Reason,
+			})
+			if err != nil {
+				return nil, err
+			}
+			payouts = append(payouts, payout)
+		}
+	}
+
+	ans, err := updateAccountStatus(ctx, tx, user, model.AccountClosed, c.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit account closure", err)
+	}
+	for _, payout := range payouts {
+		countTransfer(payout)
+	}
+	return ans, nil
+}
+
+// lockUser returns the user locked until tx ends, deleted users are not found.
+func lockUser(ctx context.Context, tx *sqlx.Tx, id int64) (*model.User, error) {
+	var ans model.User
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE"
+	err := tx.GetContext(ctx, &ans, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "user with ID %d does not exist", id)
+	}
+	if err != nil {
+		return nil, dbError("lock user", err)
+	}
+	return &ans, nil
+}
+
+// updateAccountStatus sets the status of the locked user and records the change made by the caller of ctx.
+func updateAccountStatus(ctx context.Context, tx *sqlx.Tx, user *model.User,
+	status, reason string,
+) (*model.User, error) {
+	var ans model.User
+	query := `
+		UPDATE users
+		SET status = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + userColumns
+	if err := tx.GetContext(ctx, &ans, query, user.ID, status); err != nil {
+		return nil, dbError("update account status", err)
+	}
+
+	var apiKeyID *int64
+	if caller := model.CallerFromContext(ctx); caller != nil {
+		apiKeyID = &caller.ID
+	}
+	query = `
+		INSERT INTO account_status_changes (user_id, status, previous_status, reason, api_key_id)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, query, user.ID, status, user.Status, reason, apiKeyID); err != nil {
+		return nil, dbError("record account status change", err)
+	}
+	return &ans, nil
+}
@@ -0,0 +1,245 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestCheckAccount(t *testing.T) {
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		status string
+		debit  bool
+		want   error
+	}{
+		{status: model.AccountActive, debit: true, want: nil},
+		{status: model.AccountDebitFrozen, debit: false, want: nil},
+		{status: model.AccountDebitFrozen, debit: true, want: model.ErrAccountFrozen},
+		{status: model.AccountFrozen, debit: false, want: model.ErrAccountFrozen},
+		{status: model.AccountClosed, debit: false, want: model.ErrAccountFrozen},
+		{status: "", debit: false, want: model.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			mock.ExpectBegin()
+			expectAccount(mock, 1, tt.status)
+			mock.ExpectRollback()
+
+			tx, err := db.Beginx()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when beginning a transaction", err)
+			}
+			defer tx.Rollback()
+
+			err = checkAccount(context.Background(), tx, 1, tt.debit)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("checkAccount(%s, debit %v) error = %v, want %v", tt.status, tt.debit, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_SetAccountStatus(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	caller := &model.APIKey{ID: 3, Name: "support"}
+	change := &model.AccountStatusChange{UserID: 4, Status: model.AccountFrozen, Reason: "chargeback"}
+	mock.ExpectBegin()
+	expectLockUser(mock, 4, model.AccountActive)
+	mock.ExpectQuery("UPDATE users SET status").
+		WithArgs(int64(4), model.AccountFrozen).
+		WillReturnRows(userRow(4, model.AccountFrozen))
+	mock.ExpectExec("INSERT INTO account_status_changes").
+		WithArgs(int64(4), model.AccountFrozen, model.AccountActive, change.Reason, &caller.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// a closed account can't be reopened
+	mock.ExpectBegin()
+	expectLockUser(mock, 4, model.AccountClosed)
+	mock.ExpectRollback()
+
+	got, err := s.SetAccountStatus(model.ContextWithCaller(context.Background(), caller), change)
+	if err != nil {
+		t.Fatalf("Storage.SetAccountStatus() error = %v", err)
+	}
+	if got.Status != model.AccountFrozen {
+		t.Errorf("Storage.SetAccountStatus() Status = %v, want %v", got.Status, model.AccountFrozen)
+	}
+	if _, err = s.SetAccountStatus(context.Background(), change); !errors.Is(err, model.ErrConflict) {
+		t.Errorf("Storage.SetAccountStatus() error = %v, want %v", err, model.ErrConflict)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_CloseAccount(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	payoutUserID := int64(9)
+	amount := decimal.NewFromFloat(5)
+
+	type mockBehavior func(c *model.AccountClosure)
+
+	tests := []struct {
+		name    string
+		mock    mockBehavior
+		input   *model.AccountClosure
+		wantErr error
+	}{
+		{
+			name: "Empty wallets",
+			mock: func(c *model.AccountClosure) {
+				mock.ExpectBegin()
+				expectLockUser(mock, c.UserID, model.AccountFrozen)
+				mock.ExpectQuery("SELECT (.+) FROM balances WHERE user_id = \\$1 ORDER BY currency FOR UPDATE").
+					WithArgs(c.UserID).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, c.UserID, model.CurrencyRUB, decimal.Zero, decimal.Zero))
+				expectCloseAccount(mock, c, model.AccountFrozen)
+				mock.ExpectCommit()
+			},
+			input: &model.AccountClosure{UserID: 4, Reason: "user request"},
+		},
+		{
+			name: "Money left without payout",
+			mock: func(c *model.AccountClosure) {
+				mock.ExpectBegin()
+				expectLockUser(mock, c.UserID, model.AccountActive)
+				mock.ExpectQuery("SELECT (.+) FROM balances WHERE user_id = \\$1 ORDER BY currency FOR UPDATE").
+					WithArgs(c.UserID).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, c.UserID, model.CurrencyRUB, amount, decimal.Zero))
+				mock.ExpectRollback()
+			},
+			input:   &model.AccountClosure{UserID: 4, Reason: "user request"},
+			wantErr: model.ErrConflict,
+		},
+		{
+			name: "Money held by reservations",
+			mock: func(c *model.AccountClosure) {
+				mock.ExpectBegin()
+				expectLockUser(mock, c.UserID, model.AccountActive)
+				mock.ExpectQuery("SELECT (.+) FROM balances WHERE user_id = \\$1 ORDER BY currency FOR UPDATE").
+					WithArgs(c.UserID).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, c.UserID, model.CurrencyRUB, decimal.Zero, amount))
+				mock.ExpectRollback()
+			},
+			input:   &model.AccountClosure{UserID: 4, Reason: "user request", PayoutUserID: &payoutUserID},
+			wantErr: model.ErrConflict,
+		},
+		{
+			name: "Money paid out",
+			mock: func(c *model.AccountClosure) {
+				mock.ExpectBegin()
+				expectLockUser(mock, c.UserID, model.AccountActive)
+				mock.ExpectQuery("SELECT (.+) FROM balances WHERE user_id = \\$1 ORDER BY currency FOR UPDATE").
+					WithArgs(c.UserID).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, c.UserID, model.CurrencyRUB, amount, decimal.Zero))
+				expectAccount(mock, payoutUserID, model.AccountActive)
+				// the money left goes to the payout wallet in the same currency
+				mock.ExpectExec("INSERT INTO balances").
+					WithArgs(payoutUserID, model.CurrencyRUB).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("FOR UPDATE").
+					WithArgs(c.UserID, model.CurrencyRUB, payoutUserID, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, c.UserID, model.CurrencyRUB, amount, decimal.Zero).
+						AddRow(2, payoutUserID, model.CurrencyRUB, decimal.Zero, decimal.Zero))
+				mock.ExpectQuery("INSERT INTO transfers").
+					WithArgs(c.UserID, payoutUserID, amount, model.CurrencyRUB, amount, model.CurrencyRUB,
+						nil, nil, c.Reason, model.TransferCompleted).
+					WillReturnRows(sqlmock.NewRows(transferRows).AddRow(7, c.UserID, payoutUserID, amount, model.CurrencyRUB,
+						amount, model.CurrencyRUB, nil, nil, c.Reason, model.TransferCompleted, time.Now(), time.Now()))
+				expectWallet(mock, c.UserID)
+				expectWallet(mock, payoutUserID)
+				mock.ExpectQuery("INSERT INTO journal_entries").
+					WithArgs("transfer").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectExec("INSERT INTO postings").
+					WithArgs(int64(1), c.UserID+100, amount.Neg(), payoutUserID+100, amount).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec("UPDATE balances").
+					WithArgs(c.UserID, model.CurrencyRUB, amount.Neg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO transactions").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("UPDATE balances").
+					WithArgs(payoutUserID, model.CurrencyRUB, amount).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(2, payoutUserID, model.CurrencyRUB, amount, decimal.Zero))
+				mock.ExpectExec("INSERT INTO transactions").
+					WillReturnResult(sqlmock.NewResult(2, 1))
+				expectCloseAccount(mock, c, model.AccountActive)
+				mock.ExpectCommit()
+			},
+			input: &model.AccountClosure{UserID: 4, Reason: "user request", PayoutUserID: &payoutUserID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock(tt.input)
+			got, err := s.CloseAccount(context.Background(), tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Storage.CloseAccount() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("Storage.CloseAccount() error = %v", err)
+			} else if got.Status != model.AccountClosed {
+				t.Errorf("Storage.CloseAccount() Status = %v, want %v", got.Status, model.AccountClosed)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func userRow(id int64, status string) *sqlmock.Rows {
+	return sqlmock.NewRows(userRows).
+		AddRow(id, "John Doe", "johndoe", "john@example.com", "$2a$10$hash", status, time.Now(), time.Now(), nil)
+}
+
+func expectLockUser(mock sqlmock.Sqlmock, userID int64, status string) {
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(userRow(userID, status))
+}
+
+func expectCloseAccount(mock sqlmock.Sqlmock, c *model.AccountClosure, previous string) {
+	mock.ExpectQuery("UPDATE users SET status").
+		WithArgs(c.UserID, model.AccountClosed).
+		WillReturnRows(userRow(c.UserID, model.AccountClosed))
+	mock.ExpectExec("INSERT INTO account_status_changes").
+		WithArgs(c.UserID, model.AccountClosed, previous, c.Reason, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
@@ -0,0 +1,80 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const apiKeyColumns = `id, name, key_hash, scopes, created_at, revoked_at`
+
+func (s *Storage) CreateAPIKey(ctx context.Context, k *model.APIKey) (*model.APIKey, error) {
+	var ans model.APIKey
+	query := `
+		INSERT INTO api_keys (name, key_hash, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING ` + apiKeyColumns
+	err := s.db.GetContext(ctx, &ans, query, k.Name, k.KeyHash, k.Scopes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrConflict, "API key %q already exists", k.Name)
+	}
+	if err != nil {
+		return nil, dbError("create API key", err)
+	}
+	return &ans, nil
+}
+
+// GetAPIKey returns the key that isn't revoked by its ID.
+func (s *Storage) GetAPIKey(ctx context.Context, id int64) (*model.APIKey, error) {
+	return s.apiKey(ctx, "id = $1", id)
+}
+
+// FindAPIKey returns the key that isn't revoked by the SHA-256 of the key.
+func (s *Storage) FindAPIKey(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	return s.apiKey(ctx, "key_hash = $1", keyHash)
+}
+
+func (s *Storage) apiKey(ctx context.Context, where string, arg interface{}) (*model.APIKey, error) {
+	var ans model.APIKey
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE " + where + " AND revoked_at IS NULL"
+	err := s.db.GetContext(ctx, &ans, query, arg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "API key does not exist")
+	}
+	if err != nil {
+		return nil, dbError("get API key", err)
+	}
+	return &ans, nil
+}
+
+// UseNonce remembers the nonce of a signed request of the key for ttl,
+// a nonce used within ttl is a replay. The expiry is computed by the database clock it is checked by.
+func (s *Storage) UseNonce(ctx context.Context, keyID int64, nonce string, ttl time.Duration) error {
+	query := `
+		INSERT INTO api_nonces (key_id, nonce, expires_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')
+		ON CONFLICT (key_id, nonce) DO UPDATE
+		SET expires_at = EXCLUDED.expires_at
+		WHERE api_nonces.expires_at < CURRENT_TIMESTAMP`
+	res, err := s.db.ExecContext(ctx, query, keyID, nonce, ttl.Seconds())
+	if err != nil {
+		return dbError("use nonce", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.Errorf(model.ErrUnauthorized, "nonce is already used")
+	}
+	return nil
+}
+
+func (s *Storage) DeleteExpiredNonces(ctx context.Context) (int64, error) {
+	query := `DELETE FROM api_nonces WHERE expires_at < CURRENT_TIMESTAMP`
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, dbError("delete expired nonces", err)
+	}
+	return res.RowsAffected()
+}
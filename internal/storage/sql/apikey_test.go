@@ -0,0 +1,113 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var apiKeyRows = []string{"id", "name", "key_hash", "scopes", "created_at", "revoked_at"}
+
+func TestStorage_FindAPIKey(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	mock.ExpectQuery("FROM api_keys WHERE key_hash = \\$1 AND revoked_at IS NULL").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows(apiKeyRows).AddRow(3, "billing", "hash", "balance:read", time.Now(), nil))
+	mock.ExpectQuery("FROM api_keys WHERE key_hash = \\$1 AND revoked_at IS NULL").
+		WithArgs("revoked").
+		WillReturnRows(sqlmock.NewRows(apiKeyRows))
+
+	got, err := s.FindAPIKey(context.Background(), "hash")
+	if err != nil {
+		t.Fatalf("Storage.FindAPIKey() error = %v", err)
+	}
+	if got.ID != 3 || !got.HasScope(model.ScopeBalanceRead) || got.HasScope(model.ScopeTransfer) {
+		t.Errorf("Storage.FindAPIKey() got = %+v", got)
+	}
+	if _, err = s.FindAPIKey(context.Background(), "revoked"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("Storage.FindAPIKey() error = %v, want %v", err, model.ErrNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_UseNonce(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO api_nonces").
+		WithArgs(int64(3), "n-1", time.Minute.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// the nonce is still kept, so the second request is a replay
+	mock.ExpectExec("INSERT INTO api_nonces").
+		WithArgs(int64(3), "n-1", time.Minute.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := s.UseNonce(context.Background(), 3, "n-1", time.Minute); err != nil {
+		t.Fatalf("Storage.UseNonce() error = %v", err)
+	}
+	if err := s.UseNonce(context.Background(), 3, "n-1", time.Minute); !errors.Is(err, model.ErrUnauthorized) {
+		t.Errorf("Storage.UseNonce() error = %v, want %v", err, model.ErrUnauthorized)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_TopUpRecordsCaller(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	caller := &model.APIKey{ID: 3, Name: "billing"}
+	amount := decimal.NewFromFloat(100)
+
+	mock.ExpectBegin()
+	expectAccount(mock, int64(1), model.AccountActive)
+	mock.ExpectQuery("INSERT INTO balances").
+		WithArgs(int64(1), model.CurrencyRUB, amount).
+		WillReturnRows(sqlmock.NewRows(balanceRows).AddRow(1, 1, model.CurrencyRUB, amount, decimal.Zero))
+	expectWalletEntry(mock, 1)
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs(int64(1), int64(1), amount, model.CurrencyRUB, model.TransactionTopUp, model.SourceBankCard,
+			nil, nil, nil, nil, "", nil, nil, &caller.ID, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	ctx := model.ContextWithCaller(context.Background(), caller)
+	_, err = s.TopUp(ctx, &model.Transaction{
+		UserID:   1,
+		Amount:   amount,
+		Currency: model.CurrencyRUB,
+		Kind:     model.TransactionTopUp,
+		Source:   model.SourceBankCard,
+	})
+	if err != nil {
+		t.Fatalf("Storage.TopUp() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,76 @@
+package sqlstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const auditColumns = `id, api_key_id, actor_user_id, route, path, payload_hash, status, result,
+	user_id, balance, currency, request_id, created_at`
+
+// RecordAudit appends the record to the audit log, records are never changed afterwards.
+func (s *Storage) RecordAudit(ctx context.Context, a *model.AuditRecord) error {
+	query := `
+		INSERT INTO audit_log (api_key_id, actor_user_id, route, path, payload_hash, status, result,
+		                       user_id, balance, currency, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err := s.db.ExecContext(ctx, query, a.APIKeyID, a.ActorUserID, a.Route, a.Path, a.PayloadHash, a.Status,
+		a.Result, a.UserID, a.Balance, a.Currency, a.RequestID)
+	if err != nil {
+		return dbError("record audit", err)
+	}
+	return nil
+}
+
+// ListAudit returns the audit records matching the filter from newest to oldest.
+func (s *Storage) ListAudit(ctx context.Context, f *model.AuditFilter) ([]model.AuditRecord, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{"TRUE"}
+	if f.APIKeyID != 0 {
+		where = append(where, "api_key_id = "+arg(f.APIKeyID))
+	}
+	if f.ActorUserID != 0 {
+		where = append(where, "actor_user_id = "+arg(f.ActorUserID))
+	}
+	if f.UserID != 0 {
+		where = append(where, "user_id = "+arg(f.UserID))
+	}
+	if f.Route != "" {
+		where = append(where, "route = "+arg(f.Route))
+	}
+	if f.Result != "" {
+		where = append(where, "result = "+arg(f.Result))
+	}
+	if f.RequestID != "" {
+		where = append(where, "request_id = "+arg(f.RequestID))
+	}
+	if f.From != nil {
+		where = append(where, "created_at >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		where = append(where, "created_at < "+arg(*f.To))
+	}
+	if f.AfterID > 0 {
+		where = append(where, "id < "+arg(f.AfterID))
+	}
+
+	query := `
+		SELECT ` + auditColumns + ` FROM audit_log
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY id DESC
+		LIMIT ` + arg(f.Limit)
+
+	var ans []model.AuditRecord
+	if err := s.db.SelectContext(ctx, &ans, query, args...); err != nil {
+		return nil, dbError("get audit log", err)
+	}
+	return ans, nil
+}
@@ -0,0 +1,87 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var auditRows = []string{
+	"id", "api_key_id", "actor_user_id", "route", "path", "payload_hash", "status", "result",
+	"user_id", "balance", "currency", "request_id", "created_at",
+}
+
+func TestStorage_RecordAudit(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	apiKeyID, userID, balance, currency := int64(3), int64(7), decimal.NewFromInt(110), model.CurrencyRUB
+	a := &model.AuditRecord{
+		APIKeyID:    &apiKeyID,
+		Route:       "POST /api/v1/users/{id}/top-ups",
+		Path:        "POST /api/v1/users/7/top-ups",
+		PayloadHash: "hash",
+		Status:      200,
+		Result:      model.AuditResultOK,
+		UserID:      &userID,
+		Balance:     &balance,
+		Currency:    &currency,
+		RequestID:   "req-1",
+	}
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs(a.APIKeyID, nil, a.Route, a.Path, a.PayloadHash, a.Status, a.Result,
+			a.UserID, a.Balance, a.Currency, a.RequestID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err = s.RecordAudit(context.Background(), a); err != nil {
+		t.Fatalf("Storage.RecordAudit() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_ListAudit(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	// only the set fields of the filter narrow the query
+	mock.ExpectQuery("SELECT (.+) FROM audit_log WHERE TRUE AND api_key_id = \\$1 AND user_id = \\$2 "+
+		"AND created_at >= \\$3 AND id < \\$4 ORDER BY id DESC LIMIT \\$5").
+		WithArgs(int64(3), int64(7), from, int64(10), 21).
+		WillReturnRows(sqlmock.NewRows(auditRows).
+			AddRow(9, 3, nil, "POST /api/v1/users/{id}/top-ups", "POST /api/v1/users/7/top-ups", "hash", 200,
+				model.AuditResultOK, 7, decimal.NewFromInt(110), model.CurrencyRUB, "req-1", time.Now()))
+
+	got, err := s.ListAudit(context.Background(), &model.AuditFilter{
+		APIKeyID: 3,
+		UserID:   7,
+		From:     &from,
+		AfterID:  10,
+		Limit:    21,
+	})
+	if err != nil {
+		t.Fatalf("Storage.ListAudit() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 9 || got[0].APIKeyID == nil || *got[0].APIKeyID != 3 {
+		t.Errorf("Storage.ListAudit() = %+v, want the record 9 of key 3", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
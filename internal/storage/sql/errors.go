@@ -0,0 +1,33 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jackc/pgx"
+)
+
+// uniqueViolation is the SQLSTATE of a duplicate key.
+const uniqueViolation = "23505"
+
+// dbError wraps the error of the database operation op. Failures of the connection
+// are marked with model.ErrUnavailable, so an outage can be told from a failed query.
+func dbError(op string, err error) error {
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return fmt.Errorf("%w: failed to %s: %w", model.ErrUnavailable, op, err)
+	}
+	return fmt.Errorf("failed to %s: %w", op, err)
+}
+
+// isUniqueViolation tells whether the query failed on a unique index.
+func isUniqueViolation(err error) bool {
+	var pgErr pgx.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
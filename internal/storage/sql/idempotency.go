@@ -0,0 +1,74 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+// AcquireIdempotencyKey reserves the key for the caller. It returns nil if the key was free
+// (or expired) and the caller may process the request, otherwise it returns the stored key.
+// The expiry is computed by the database, TIMESTAMP columns keep no time zone of the service host.
+func (s *Storage) AcquireIdempotencyKey(ctx context.Context, key, fingerprint string,
+	ttl time.Duration,
+) (*model.IdempotencyKey, error) {
+	query := `
+		INSERT INTO idempotency_keys (key, fingerprint, expires_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')
+		ON CONFLICT (key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint,
+		    status_code = 0,
+		    response = '',
+		    created_at = CURRENT_TIMESTAMP,
+		    expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < CURRENT_TIMESTAMP
+		RETURNING key`
+	var acquired string
+	err := s.db.QueryRowxContext(ctx, query, key, fingerprint, ttl.Seconds()).Scan(&acquired)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, dbError("acquire idempotency key", err)
+	}
+
+	var ans model.IdempotencyKey
+	query = `
+		SELECT key, fingerprint, status_code, response, created_at, expires_at
+		FROM idempotency_keys WHERE key = $1`
+	if err = s.db.GetContext(ctx, &ans, query, key); err != nil {
+		return nil, dbError("get idempotency key", err)
+	}
+	return &ans, nil
+}
+
+func (s *Storage) SaveIdempotencyResponse(ctx context.Context, key string, status int, response []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $2, response = $3
+		WHERE key = $1`
+	if _, err := s.db.ExecContext(ctx, query, key, status, response); err != nil {
+		return dbError("save idempotent response", err)
+	}
+	return nil
+}
+
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE key = $1 AND status_code = 0`
+	if _, err := s.db.ExecContext(ctx, query, key); err != nil {
+		return dbError("release idempotency key", err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expires_at < CURRENT_TIMESTAMP`
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, dbError("delete expired idempotency keys", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,99 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestStorage_AcquireIdempotencyKey(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	type args struct {
+		key         string
+		fingerprint string
+	}
+
+	type mockBehavior func(args args)
+
+	tests := []struct {
+		name    string
+		mock    mockBehavior
+		input   args
+		want    *model.IdempotencyKey
+		wantErr bool
+	}{
+		{
+			name: "Acquired",
+			mock: func(args args) {
+				mock.ExpectQuery("INSERT INTO idempotency_keys").
+					WithArgs(args.key, args.fingerprint, time.Hour.Seconds()).
+					WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow(args.key))
+			},
+			input: args{
+				key:         "key-1",
+				fingerprint: "fp",
+			},
+			want:    nil,
+			wantErr: false,
+		},
+		{
+			name: "Already used",
+			mock: func(args args) {
+				mock.ExpectQuery("INSERT INTO idempotency_keys").
+					WithArgs(args.key, args.fingerprint, time.Hour.Seconds()).
+					WillReturnRows(sqlmock.NewRows([]string{"key"}))
+				mock.ExpectQuery("SELECT (.+) FROM idempotency_keys WHERE key = \\$1").
+					WithArgs(args.key).
+					WillReturnRows(sqlmock.NewRows([]string{
+						"key", "fingerprint", "status_code", "response", "created_at", "expires_at",
+					}).AddRow(args.key, "other", 200, []byte("{}"), time.Now(), time.Now().Add(time.Hour)))
+			},
+			input: args{
+				key:         "key-1",
+				fingerprint: "fp",
+			},
+			want: &model.IdempotencyKey{
+				Key:         "key-1",
+				Fingerprint: "other",
+				StatusCode:  200,
+				Response:    []byte("{}"),
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock(tt.input)
+			got, err := s.AcquireIdempotencyKey(context.Background(), tt.input.key, tt.input.fingerprint, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.AcquireIdempotencyKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("Storage.AcquireIdempotencyKey() = %v, want nil", got)
+			case tt.want != nil && got == nil:
+				t.Errorf("Storage.AcquireIdempotencyKey() = nil, want %v", tt.want)
+			case tt.want != nil:
+				if got.Fingerprint != tt.want.Fingerprint || got.StatusCode != tt.want.StatusCode {
+					t.Errorf("Storage.AcquireIdempotencyKey() = %v, want %v", got, tt.want)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
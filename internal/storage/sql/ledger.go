@@ -0,0 +1,140 @@
+package sqlstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+// System accounts are the counterparts of user wallets in the ledger.
+const (
+	accountBankCard     = "external_bank_card"
+	accountPurchases    = "purchases_revenue"
+	accountReservations = "reservations_hold"
+	accountExchange     = "currency_exchange"
+)
+
+// Kinds of journal entries.
+const (
+	entryTopUp    = "top_up"
+	entryPurchase = "purchase"
+	entryTransfer = "transfer"
+	entryReserve  = "reserve"
+	entryCapture  = "capture"
+	entryRelease  = "release"
+	entryRefund   = "refund"
+	entryReversal = "reversal"
+)
+
+type posting struct {
+	accountID int64
+	amount    decimal.Decimal
+}
+
+// counterAccount returns the system account money comes from or goes to for the source.
+func counterAccount(source string) (string, error) {
+	switch source {
+	case model.SourceBankCard:
+		return accountBankCard, nil
+	case model.SourcePurchase:
+		return accountPurchases, nil
+	default:
+		return "", fmt.Errorf("unknown source %q", source)
+	}
+}
+
+// walletAccount returns the ledger account of the currency wallet of the user, creating it on the first use.
+func walletAccount(ctx context.Context, tx *sqlx.Tx, userID int64, currency string) (int64, error) {
+	query := `
+		WITH created AS (
+			INSERT INTO accounts (user_id, kind, currency)
+			VALUES ($1, 'wallet', $2)
+			ON CONFLICT (user_id, currency) DO NOTHING
+			RETURNING id
+		)
+		SELECT id FROM created
+		UNION ALL
+		SELECT id FROM accounts WHERE user_id = $1 AND currency = $2
+		LIMIT 1`
+	var id int64
+	if err := tx.QueryRowxContext(ctx, query, userID, currency).Scan(&id); err != nil {
+		return 0, dbError("get wallet account", err)
+	}
+	return id, nil
+}
+
+// systemAccount returns the system account code in the currency, creating it on the first use.
+func systemAccount(ctx context.Context, tx *sqlx.Tx, code, currency string) (int64, error) {
+	query := `
+		WITH created AS (
+			INSERT INTO accounts (code, kind, currency)
+			VALUES ($1, 'system', $2)
+			ON CONFLICT (code, currency) DO NOTHING
+			RETURNING id
+		)
+		SELECT id FROM created
+		UNION ALL
+		SELECT id FROM accounts WHERE code = $1 AND currency = $2
+		LIMIT 1`
+	var id int64
+	if err := tx.QueryRowxContext(ctx, query, code, currency).Scan(&id); err != nil {
+		return 0, dbError("get system account "+code, err)
+	}
+	return id, nil
+}
+
+// postWalletEntry records a journal entry moving amount from the system account code to the currency wallet
+// of the user. Negative amount moves money from the wallet.
+func postWalletEntry(ctx context.Context, tx *sqlx.Tx, kind string, userID int64, currency, code string,
+	amount decimal.Decimal,
+) (int64, error) {
+	wallet, err := walletAccount(ctx, tx, userID, currency)
+	if err != nil {
+		return 0, err
+	}
+	counter, err := systemAccount(ctx, tx, code, currency)
+	if err != nil {
+		return 0, err
+	}
+	return postEntry(ctx, tx, kind,
+		posting{accountID: wallet, amount: amount},
+		posting{accountID: counter, amount: amount.Neg()})
+}
+
+// postEntry records a journal entry, its postings must sum to zero.
+func postEntry(ctx context.Context, tx *sqlx.Tx, kind string, postings ...posting) (int64, error) {
+	sum := decimal.Zero
+	for _, p := range postings {
+		sum = sum.Add(p.amount)
+	}
+	if len(postings) < 2 || !sum.IsZero() {
+		return 0, fmt.Errorf("journal entry %s is not balanced", kind)
+	}
+
+	var entryID int64
+	err := tx.QueryRowxContext(ctx, "INSERT INTO journal_entries (kind) VALUES ($1) RETURNING id", kind).
+		Scan(&entryID)
+	if err != nil {
+		return 0, dbError("create journal entry", err)
+	}
+
+	values := make([]string, 0, len(postings))
+	args := make([]interface{}, 0, 2*len(postings)+1)
+	args = append(args, entryID)
+	for _, p := range postings {
+		if p.amount.IsZero() {
+			continue
+		}
+		values = append(values, fmt.Sprintf("($1, $%d, $%d)", len(args)+1, len(args)+2))
+		args = append(args, p.accountID, p.amount)
+	}
+	query := "INSERT INTO postings (entry_id, account_id, amount) VALUES " + strings.Join(values, ", ")
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return 0, dbError("record postings", err)
+	}
+	return entryID, nil
+}
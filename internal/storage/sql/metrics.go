@@ -0,0 +1,154 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cronnoss/avitotech/internal/metrics"
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	queryDuration = metrics.NewHistogram("avitotech_db_query_duration_seconds",
+		"Duration of database statements by their first keyword.", metrics.DefaultBuckets, "statement")
+	queryErrors = metrics.NewCounter("avitotech_db_query_errors_total",
+		"Database statements that failed by their first keyword.", "statement")
+
+	moneyCredited = metrics.NewCounter("avitotech_money_credited_total",
+		"Money credited to wallets of users by currency and transaction kind.", "currency", "kind")
+	moneyDebited = metrics.NewCounter("avitotech_money_debited_total",
+		"Money debited from wallets of users by currency and transaction kind.", "currency", "kind")
+	insufficientFunds = metrics.NewCounter("avitotech_insufficient_funds_total",
+		"Operations rejected because the wallet had not enough money.", "operation")
+)
+
+// countMoney adds the amount of the committed transaction to the credited or the debited money.
+func countMoney(currency, kind string, amount decimal.Decimal) {
+	if amount.IsNegative() {
+		moneyDebited.Add(amount.Neg().InexactFloat64(), currency, kind)
+		return
+	}
+	moneyCredited.Add(amount.InexactFloat64(), currency, kind)
+}
+
+// countTransfer adds both legs of the committed transfer, a transfer with an exchange
+// is debited in its currency and credited in the currency of the recipient.
+func countTransfer(t *model.Transfer) {
+	countMoney(t.Currency, model.TransactionTransferOut, t.Amount.Neg())
+	countMoney(t.ToCurrency, model.TransactionTransferIn, t.ToAmount)
+}
+
+// connector opens connections of the driver that measure every statement of the storage
+// and trace it with the spans of trace.go.
+type connector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	measured, ok := conn.(measurableConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("driver doesn't support contexts")
+	}
+	return &measuredConn{measured}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// measurableConn is a connection of a driver supporting contexts like pgx.
+type measurableConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+type measuredConn struct {
+	measurableConn
+}
+
+func (c *measuredConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startSpan(ctx, statement(query), query)
+	start := time.Now()
+	res, err := c.measurableConn.ExecContext(ctx, query, args)
+	observe(statement(query), start, err)
+	endSpan(span, err)
+	return res, err
+}
+
+// QueryContext measures the query until the rows are returned, reading them is not counted.
+func (c *measuredConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startSpan(ctx, statement(query), query)
+	start := time.Now()
+	rows, err := c.measurableConn.QueryContext(ctx, query, args)
+	observe(statement(query), start, err)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (c *measuredConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	spanCtx, span := startSpan(ctx, "begin", "")
+	start := time.Now()
+	tx, err := c.measurableConn.BeginTx(spanCtx, opts)
+	observe("begin", start, err)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &measuredTx{tx: tx, ctx: ctx}, nil
+}
+
+// measuredTx keeps the context of the beginning, so the commit is traced in the trace of the caller.
+type measuredTx struct {
+	tx  driver.Tx
+	ctx context.Context
+}
+
+func (t *measuredTx) Commit() error {
+	_, span := startSpan(t.ctx, "commit", "")
+	start := time.Now()
+	err := t.tx.Commit()
+	observe("commit", start, err)
+	endSpan(span, err)
+	return err
+}
+
+func (t *measuredTx) Rollback() error {
+	_, span := startSpan(t.ctx, "rollback", "")
+	start := time.Now()
+	err := t.tx.Rollback()
+	observe("rollback", start, err)
+	endSpan(span, err)
+	return err
+}
+
+// observe records the duration of the statement and counts its failure.
+func observe(stmt string, start time.Time, err error) {
+	queryDuration.Since(start, stmt)
+	if err != nil && !errors.Is(err, driver.ErrSkip) {
+		queryErrors.Inc(stmt)
+	}
+}
+
+// statement returns the first keyword of the query in lower case, like select or insert.
+func statement(query string) string {
+	query = strings.TrimSpace(query)
+	if i := strings.IndexFunc(query, unicode.IsSpace); i > 0 {
+		query = query[:i]
+	}
+	return strings.ToLower(query)
+}
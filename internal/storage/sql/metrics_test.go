@@ -0,0 +1,84 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cronnoss/avitotech/internal/metrics"
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestConnector(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("sqlmock_metrics")
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	db := sql.OpenDB(&connector{dsn: "sqlmock_metrics", driver: mockDB.Driver()})
+	defer db.Close()
+	ctx := context.Background()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT amount FROM balances").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(1))
+	mock.ExpectExec("UPDATE balances").WillReturnError(errors.New("deadlock detected"))
+	mock.ExpectRollback()
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	var amount int
+	require.NoError(t, tx.QueryRowContext(ctx, "\n\t\tSELECT amount FROM balances").Scan(&amount))
+	_, err = tx.ExecContext(ctx, "UPDATE balances SET amount = 0")
+	require.Error(t, err)
+	require.NoError(t, tx.Rollback())
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	var out strings.Builder
+	require.NoError(t, metrics.Default.Write(&out))
+	for _, line := range []string{
+		`avitotech_db_query_duration_seconds_count{statement="begin"} 1`,
+		`avitotech_db_query_duration_seconds_count{statement="select"} 1`,
+		`avitotech_db_query_duration_seconds_count{statement="update"} 1`,
+		`avitotech_db_query_duration_seconds_count{statement="rollback"} 1`,
+		`avitotech_db_query_errors_total{statement="update"} 1`,
+	} {
+		require.Contains(t, out.String(), line+"\n")
+	}
+	require.NotContains(t, out.String(), `avitotech_db_query_errors_total{statement="select"}`)
+}
+
+func TestStatement(t *testing.T) {
+	require.Equal(t, "select", statement("\n\t\tSELECT "+balanceColumns+" FROM balances"))
+	require.Equal(t, "with", statement("WITH moved AS (UPDATE balances SET amount = 0) SELECT 1"))
+	require.Equal(t, "commit", statement("COMMIT"))
+}
+
+func TestCountMoney(t *testing.T) {
+	countMoney("XTS", "top_up", decimal.RequireFromString("100.50"))
+	countMoney("XTS", "purchase", decimal.RequireFromString("-30.25"))
+	countMoney("XTS", "purchase", decimal.RequireFromString("-9.75"))
+
+	var out strings.Builder
+	require.NoError(t, metrics.Default.Write(&out))
+	require.Contains(t, out.String(), `avitotech_money_credited_total{currency="XTS",kind="top_up"} 100.5`+"\n")
+	require.Contains(t, out.String(), `avitotech_money_debited_total{currency="XTS",kind="purchase"} 40`+"\n")
+	require.NotContains(t, out.String(), `avitotech_money_debited_total{currency="XTS",kind="top_up"}`)
+}
+
+func TestCountTransfer(t *testing.T) {
+	countTransfer(&model.Transfer{
+		Amount:     decimal.RequireFromString("900"),
+		Currency:   "XTS",
+		ToAmount:   decimal.RequireFromString("9.9"),
+		ToCurrency: "XXX",
+	})
+
+	var out strings.Builder
+	require.NoError(t, metrics.Default.Write(&out))
+	require.Contains(t, out.String(), `avitotech_money_debited_total{currency="XTS",kind="transfer_out"} 900`+"\n")
+	require.Contains(t, out.String(), `avitotech_money_credited_total{currency="XXX",kind="transfer_in"} 9.9`+"\n")
+}
@@ -0,0 +1,73 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const quoteColumns = `id, user_id, amount, currency, rate, spread, quoted_amount, created_at, expires_at, used_at`
+
+// CreateQuote stores the quote for the user, it expires in ttl.
+func (s *Storage) CreateQuote(ctx context.Context, q *model.Quote, ttl time.Duration) (*model.Quote, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	if err = checkAccount(ctx, tx, q.UserID, true); err != nil {
+		return nil, err
+	}
+
+	var ans model.Quote
+	query := `
+		INSERT INTO fx_quotes (user_id, amount, currency, rate, spread, quoted_amount, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + $7 * INTERVAL '1 second')
+		RETURNING ` + quoteColumns
+	err = tx.GetContext(ctx, &ans, query, q.UserID, q.Amount, q.Currency, q.Rate, q.Spread, q.QuotedAmount,
+		ttl.Seconds())
+	if err != nil {
+		return nil, dbError("create quote", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit quote", err)
+	}
+	return &ans, nil
+}
+
+// useQuote marks the quote of the user as used within tx and returns it.
+// Quotes of other users are reported as missing. The expiry is checked by the clock of the database
+// that wrote it, the TIMESTAMP keeps no time zone to compare it with the host.
+func useQuote(ctx context.Context, tx *sqlx.Tx, id, userID int64) (*model.Quote, error) {
+	var q model.Quote
+	query := `
+		UPDATE fx_quotes SET used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING ` + quoteColumns
+	err := tx.GetContext(ctx, &q, query, id, userID)
+	if err == nil {
+		return &q, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, dbError("use quote", err)
+	}
+
+	// the quote can't be used, the reason is told by the quote itself
+	err = tx.GetContext(ctx, &q, "SELECT "+quoteColumns+" FROM fx_quotes WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && q.UserID != userID {
+		return nil, model.Errorf(model.ErrNotFound, "quote with ID %d does not exist", id)
+	}
+	if err != nil {
+		return nil, dbError("get quote", err)
+	}
+	if q.UsedAt != nil {
+		return nil, model.Errorf(model.ErrConflict, "quote %d is already used", id)
+	}
+	return nil, model.Errorf(model.ErrConflict, "quote %d is expired", id)
+}
@@ -0,0 +1,177 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var quoteRows = []string{
+	"id", "user_id", "amount", "currency", "rate", "spread", "quoted_amount", "created_at", "expires_at", "used_at",
+}
+
+func TestStorage_CreateQuote(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	now := time.Now()
+	q := &model.Quote{
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(10),
+		Currency:     "USD",
+		Rate:         decimal.NewFromFloat(90),
+		Spread:       decimal.NewFromFloat(0.01),
+		QuotedAmount: decimal.NewFromFloat(909),
+	}
+
+	mock.ExpectBegin()
+	expectAccount(mock, q.UserID, model.AccountActive)
+	mock.ExpectQuery("INSERT INTO fx_quotes").
+		WithArgs(q.UserID, q.Amount, q.Currency, q.Rate, q.Spread, q.QuotedAmount, time.Minute.Seconds()).
+		WillReturnRows(sqlmock.NewRows(quoteRows).
+			AddRow(3, q.UserID, q.Amount, q.Currency, q.Rate, q.Spread, q.QuotedAmount, now, now.Add(time.Minute), nil))
+	mock.ExpectCommit()
+
+	got, err := s.CreateQuote(context.Background(), q, time.Minute)
+	if err != nil {
+		t.Fatalf("Storage.CreateQuote() error = %v", err)
+	}
+	if got.ID != 3 || !got.QuotedAmount.Equal(q.QuotedAmount) {
+		t.Errorf("Storage.CreateQuote() got = %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_DebitWithQuote(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	now := time.Now()
+	userID, quoteID := int64(1), int64(3)
+	quoted := decimal.NewFromFloat(909)
+	serviceID, orderID := int64(10), int64(100)
+
+	quote := func(userID int64, expiresAt time.Time, usedAt *time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows(quoteRows).
+			AddRow(quoteID, userID, decimal.NewFromFloat(10), "USD", decimal.NewFromFloat(90),
+				decimal.NewFromFloat(0.01), quoted, now, expiresAt, usedAt)
+	}
+	expectQuote := func(rows *sqlmock.Rows) {
+		mock.ExpectBegin()
+		expectAccount(mock, userID, model.AccountActive)
+		mock.ExpectQuery("UPDATE fx_quotes SET used_at").
+			WithArgs(quoteID, userID).
+			WillReturnRows(rows)
+	}
+	// unusable quotes aren't updated, they are read to tell why
+	expectUnusable := func(rows *sqlmock.Rows) {
+		expectQuote(sqlmock.NewRows(quoteRows))
+		mock.ExpectQuery("FROM fx_quotes WHERE id = \\$1$").
+			WithArgs(quoteID).
+			WillReturnRows(rows)
+	}
+
+	tests := []struct {
+		name    string
+		mock    func()
+		amount  decimal.Decimal
+		wantErr error
+	}{
+		{
+			name: "OK",
+			mock: func() {
+				expectQuote(quote(userID, now.Add(time.Minute), nil))
+				mock.ExpectQuery("UPDATE balances").
+					WithArgs(userID, model.CurrencyRUB, quoted.Neg()).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, userID, model.CurrencyRUB, decimal.NewFromFloat(91), decimal.Zero))
+				expectWalletEntry(mock, userID)
+				// the quote is recorded on the transaction
+				mock.ExpectExec("INSERT INTO transactions").
+					WithArgs(int64(1), userID, quoted.Neg(), model.CurrencyRUB, model.TransactionPurchase,
+						model.SourcePurchase, nil, nil, &serviceID, &orderID, "", nil, &quoteID, nil, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Amount differs from the quote",
+			mock: func() {
+				expectQuote(quote(userID, now.Add(time.Minute), nil))
+				mock.ExpectRollback()
+			},
+			amount:  decimal.NewFromFloat(-900),
+			wantErr: model.ErrInvalidAmount,
+		},
+		{
+			name: "Expired quote",
+			mock: func() {
+				expectUnusable(quote(userID, now.Add(-time.Second), nil))
+				mock.ExpectRollback()
+			},
+			wantErr: model.ErrConflict,
+		},
+		{
+			name: "Used quote",
+			mock: func() {
+				expectUnusable(quote(userID, now.Add(time.Minute), &now))
+				mock.ExpectRollback()
+			},
+			wantErr: model.ErrConflict,
+		},
+		{
+			name: "Quote of another user",
+			mock: func() {
+				expectUnusable(quote(2, now.Add(time.Minute), nil))
+				mock.ExpectRollback()
+			},
+			wantErr: model.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			got, err := s.Debit(context.Background(), &model.Transaction{
+				UserID:    userID,
+				Amount:    tt.amount,
+				Currency:  model.CurrencyRUB,
+				Kind:      model.TransactionPurchase,
+				Source:    model.SourcePurchase,
+				ServiceID: &serviceID,
+				OrderID:   &orderID,
+				QuoteID:   &quoteID,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Storage.Debit() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("Storage.Debit() error = %v", err)
+			} else if !got.Amount.Equal(decimal.NewFromFloat(91)) {
+				t.Errorf("Storage.Debit() Amount = %v, want 91", got.Amount)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const exchangeRateColumns = `id, currency, rate, source, valid_from, valid_to, created_at`
+
+// The columns of exchange_rates have no zone and the driver drops the zone of the arguments,
+// so the times are written and compared in UTC like the dates of the transactions.
+
+// validRates selects the rates valid at $1: an active manual override or else the latest snapshot.
+const validRates = `
+	SELECT DISTINCT ON (currency) ` + exchangeRateColumns + ` FROM exchange_rates
+	WHERE valid_from <= $1 AND (valid_to IS NULL OR valid_to > $1)`
+
+const validRatesOrder = `
+	ORDER BY currency, source = $2 DESC, valid_from DESC, id DESC`
+
+// SaveExchangeRates stores the rates at once and returns them with their IDs.
+func (s *Storage) SaveExchangeRates(ctx context.Context, rates []model.ExchangeRate) ([]model.ExchangeRate, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	query := `
+		INSERT INTO exchange_rates (currency, rate, source, valid_from, valid_to)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + exchangeRateColumns
+	ans := make([]model.ExchangeRate, len(rates))
+	for i, r := range rates {
+		var validTo *time.Time
+		if r.ValidTo != nil {
+			utc := r.ValidTo.UTC()
+			validTo = &utc
+		}
+		err = tx.QueryRowxContext(ctx, query, r.Currency, r.Rate, r.Source, r.ValidFrom.UTC(), validTo).
+			StructScan(&ans[i])
+		if err != nil {
+			return nil, dbError("save exchange rate", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit transaction", err)
+	}
+	return ans, nil
+}
+
+// GetExchangeRate returns the rate of the currency valid at the time.
+func (s *Storage) GetExchangeRate(ctx context.Context, currency string, at time.Time) (*model.ExchangeRate, error) {
+	var ans model.ExchangeRate
+	err := s.db.GetContext(ctx, &ans, validRates+" AND currency = $3"+validRatesOrder,
+		at.UTC(), model.RateSourceManual, currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "no exchange rate of %s at %s", currency, at.Format(time.RFC3339))
+	}
+	if err != nil {
+		return nil, dbError("get exchange rate", err)
+	}
+	return &ans, nil
+}
+
+// ListExchangeRates returns the rates of all currencies valid at the time.
+func (s *Storage) ListExchangeRates(ctx context.Context, at time.Time) ([]model.ExchangeRate, error) {
+	var ans []model.ExchangeRate
+	if err := s.db.SelectContext(ctx, &ans, validRates+validRatesOrder, at.UTC(), model.RateSourceManual); err != nil {
+		return nil, dbError("get exchange rates", err)
+	}
+	return ans, nil
+}
@@ -0,0 +1,110 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var exchangeRateRows = []string{"id", "currency", "rate", "source", "valid_from", "valid_to", "created_at"}
+
+func TestStorage_SaveExchangeRates(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	// the rates fetched on a host in another zone are stored in UTC
+	now := time.Date(2026, 10, 17, 15, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	rates := []model.ExchangeRate{
+		{Currency: "EUR", Rate: decimal.NewFromFloat(98.45), Source: model.RateSourceProvider, ValidFrom: now},
+		{Currency: "USD", Rate: decimal.NewFromFloat(91.2), Source: model.RateSourceProvider, ValidFrom: now},
+	}
+
+	mock.ExpectBegin()
+	for i, r := range rates {
+		mock.ExpectQuery("INSERT INTO exchange_rates").
+			WithArgs(r.Currency, r.Rate, r.Source, r.ValidFrom.UTC(), nil).
+			WillReturnRows(sqlmock.NewRows(exchangeRateRows).
+				AddRow(i+1, r.Currency, r.Rate, r.Source, r.ValidFrom, nil, now))
+	}
+	mock.ExpectCommit()
+
+	got, err := s.SaveExchangeRates(context.Background(), rates)
+	if err != nil {
+		t.Fatalf("Storage.SaveExchangeRates() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID != 1 || got[1].Currency != "USD" {
+		t.Errorf("Storage.SaveExchangeRates() got = %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_GetExchangeRate(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type mockBehavior func()
+
+	tests := []struct {
+		name    string
+		mock    mockBehavior
+		want    decimal.Decimal
+		wantErr error
+	}{
+		{
+			name: "Manual rate wins",
+			mock: func() {
+				mock.ExpectQuery("WHERE valid_from <= \\$1 AND \\(valid_to IS NULL OR valid_to > \\$1\\) AND currency = \\$3\\s+"+
+					"ORDER BY currency, source = \\$2 DESC, valid_from DESC, id DESC").
+					WithArgs(at, model.RateSourceManual, "USD").
+					WillReturnRows(sqlmock.NewRows(exchangeRateRows).
+						AddRow(3, "USD", decimal.NewFromInt(90), model.RateSourceManual, at, nil, at))
+			},
+			want: decimal.NewFromInt(90),
+		},
+		{
+			name: "No rate",
+			mock: func() {
+				mock.ExpectQuery("FROM exchange_rates").
+					WithArgs(at, model.RateSourceManual, "USD").
+					WillReturnRows(sqlmock.NewRows(exchangeRateRows))
+			},
+			wantErr: model.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			// the time of the caller is compared in UTC
+			got, err := s.GetExchangeRate(context.Background(), "USD", at.In(time.FixedZone("MSK", 3*60*60)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Storage.GetExchangeRate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Rate.Equal(tt.want) {
+				t.Errorf("Storage.GetExchangeRate() rate = %v, want %v", got.Rate, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+const transactionColumns = `id, user_id, amount, currency, kind, source, counterparty_user_id, transfer_id,
+	service_id, order_id, comment, refund_of, quote_id, api_key_id, request_id, refunded, date`
+
+// GetTransaction returns the transaction with its refunds.
+func (s *Storage) GetTransaction(ctx context.Context, id int64) (*model.Transaction, error) {
+	var ans model.Transaction
+	err := s.db.GetContext(ctx, &ans, "SELECT "+transactionColumns+" FROM transactions WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "transaction with ID %d does not exist", id)
+	}
+	if err != nil {
+		return nil, dbError("get transaction", err)
+	}
+
+	if err = loadRefunds(ctx, s.db, &ans); err != nil {
+		return nil, err
+	}
+	return &ans, nil
+}
+
+// Refund returns r.Amount of the transaction where it came from and records the refund linked to it.
+// A transfer is reversed, the money goes from the recipient back to the sender.
+// It returns the refunded transaction with its refunds.
+func (s *Storage) Refund(ctx context.Context, r *model.Refund) (*model.Transaction, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	// the transaction is locked with the other legs of its journal entry,
+	// so concurrent refunds can't both pass the check of the refunded amount
+	var legs []model.Transaction
+	query := `
+		SELECT ` + transactionColumns + ` FROM transactions
+		WHERE id = $1 OR entry_id = (SELECT entry_id FROM transactions WHERE id = $1)
+		ORDER BY id
+		FOR UPDATE`
+	if err = tx.SelectContext(ctx, &legs, query, r.TransactionID); err != nil {
+		return nil, dbError("lock transaction", err)
+	}
+
+	var orig *model.Transaction
+	for i := range legs {
+		if legs[i].ID == r.TransactionID {
+			orig = &legs[i]
+		}
+	}
+	if orig == nil {
+		return nil, model.Errorf(model.ErrNotFound, "transaction with ID %d does not exist", r.TransactionID)
+	}
+	if orig.RefundOf != nil {
+		return nil, model.Errorf(model.ErrConflict, "transaction %d is a refund itself", orig.ID)
+	}
+
+	refundable := orig.Amount.Abs().Sub(orig.Refunded)
+	if !refundable.IsPositive() {
+		return nil, model.Errorf(model.ErrConflict, "transaction %d is already refunded", orig.ID)
+	}
+	amount := r.Amount
+	if amount.IsZero() {
+		amount = refundable
+	}
+	if amount.GreaterThan(refundable) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "refund %s exceeds the refundable amount %s",
+			amount.StringFixed(2), refundable.StringFixed(2))
+	}
+
+	// the refund takes the money back from the users who got it and returns it to the ones who paid,
+	// their accounts must allow that
+	for _, leg := range legs {
+		if err = checkAccount(ctx, tx, leg.UserID, leg.Amount.IsPositive()); err != nil {
+			return nil, err
+		}
+	}
+
+	switch orig.Kind {
+	case model.TransactionTopUp, model.TransactionPurchase:
+		err = refundWalletEntry(ctx, tx, orig, amount, r.Comment)
+	case model.TransactionTransferIn, model.TransactionTransferOut:
+		err = reverseTransfer(ctx, tx, legs, amount, r.Comment)
+	default:
+		err = model.Errorf(model.ErrConflict, "transaction of kind %s can't be refunded", orig.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// both legs of a transfer are refunded together
+	for _, leg := range legs {
+		_, err = tx.ExecContext(ctx, "UPDATE transactions SET refunded = refunded + $2 WHERE id = $1", leg.ID, amount)
+		if err != nil {
+			return nil, dbError("update refunded amount", err)
+		}
+	}
+	orig.Refunded = orig.Refunded.Add(amount)
+
+	if orig.TransferID != nil && orig.Refunded.Equal(orig.Amount.Abs()) {
+		_, err = tx.ExecContext(ctx, "UPDATE transfers SET status = $2 WHERE id = $1",
+			*orig.TransferID, model.TransferReversed)
+		if err != nil {
+			return nil, dbError("update transfer status", err)
+		}
+	}
+
+	if err = loadRefunds(ctx, tx, orig); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit refund", err)
+	}
+	switch orig.Kind {
+	case model.TransactionTopUp:
+		countMoney(orig.Currency, model.TransactionRefund, amount.Neg())
+	case model.TransactionPurchase:
+		countMoney(orig.Currency, model.TransactionRefund, amount)
+	case model.TransactionTransferIn, model.TransactionTransferOut:
+		// the recipient pays the money back to the sender
+		countMoney(orig.Currency, model.TransactionReversal, amount.Neg())
+		countMoney(orig.Currency, model.TransactionReversal, amount)
+	}
+	return orig, nil
+}
+
+// refundWalletEntry returns amount of the top-up or purchase t to the system account
+// the money came from or went to.
+func refundWalletEntry(ctx context.Context, tx *sqlx.Tx, t *model.Transaction, amount decimal.Decimal,
+	comment string,
+) error {
+	counter, err := counterAccount(t.Source)
+	if err != nil {
+		return err
+	}
+	// the refund goes the opposite way of the transaction
+	if t.Amount.IsPositive() {
+		amount = amount.Neg()
+	}
+
+	query := `
+		UPDATE balances
+		SET amount = amount + $3
+		WHERE user_id = $1 AND currency = $2 AND amount + $3 >= 0`
+	res, err := tx.ExecContext(ctx, query, t.UserID, t.Currency, amount)
+	if err != nil {
+		return dbError("refund", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		insufficientFunds.Inc("refund")
+		return model.Errorf(model.ErrInsufficientFunds, "insufficient funds")
+	}
+
+	entryID, err := postWalletEntry(ctx, tx, entryRefund, t.UserID, t.Currency, counter, amount)
+	if err != nil {
+		return err
+	}
+	return recordTransaction(ctx, tx, entryID, &model.Transaction{
+		UserID:    t.UserID,
+		Amount:    amount,
+		Currency:  t.Currency,
+		Kind:      model.TransactionRefund,
+		Source:    t.Source,
+		ServiceID: t.ServiceID,
+		OrderID:   t.OrderID,
+		Comment:   comment,
+		RefundOf:  &t.ID,
+	})
+}
+
+// reverseTransfer returns amount of the transfer made of legs from the recipient back to the sender,
+// if the recipient still has it.
+func reverseTransfer(ctx context.Context, tx *sqlx.Tx, legs []model.Transaction, amount decimal.Decimal,
+	comment string,
+) error {
+	var out, in *model.Transaction
+	for i := range legs {
+		switch legs[i].Kind {
+		case model.TransactionTransferOut:
+			out = &legs[i]
+		case model.TransactionTransferIn:
+			in = &legs[i]
+		}
+	}
+	if out == nil || in == nil {
+		return model.Errorf(model.ErrConflict, "transfer has no journal entry and can't be reversed")
+	}
+	// the refunded amount is kept in one currency for both legs
+	if out.Currency != in.Currency {
+		return model.Errorf(model.ErrConflict, "transfer with currency exchange can't be reversed")
+	}
+	sender, recipient, currency := out.UserID, in.UserID, out.Currency
+
+	// lock both rows in user_id order like Transfer does
+	var locked []model.Balance
+	err := tx.SelectContext(ctx, &locked, `
+		SELECT `+balanceColumns+` FROM balances
+		WHERE user_id IN ($1, $2) AND currency = $3
+		ORDER BY user_id
+		FOR UPDATE`, sender, recipient, currency)
+	if err != nil {
+		return dbError("lock balances", err)
+	}
+	for _, b := range locked {
+		if b.UserID == recipient && b.Amount.LessThan(amount) {
+			insufficientFunds.Inc("refund")
+			return model.Errorf(model.ErrInsufficientFunds, "recipient has insufficient funds")
+		}
+	}
+
+	senderWallet, err := walletAccount(ctx, tx, sender, currency)
+	if err != nil {
+		return err
+	}
+	recipientWallet, err := walletAccount(ctx, tx, recipient, currency)
+	if err != nil {
+		return err
+	}
+	entryID, err := postEntry(ctx, tx, entryReversal,
+		posting{accountID: recipientWallet, amount: amount.Neg()},
+		posting{accountID: senderWallet, amount: amount})
+	if err != nil {
+		return err
+	}
+
+	updateQuery := "UPDATE balances SET amount = amount + $3 WHERE user_id = $1 AND currency = $2"
+	if _, err = tx.ExecContext(ctx, updateQuery, recipient, currency, amount.Neg()); err != nil {
+		return dbError("debit", err)
+	}
+	err = recordTransaction(ctx, tx, entryID, &model.Transaction{
+		UserID:             recipient,
+		Amount:             amount.Neg(),
+		Currency:           currency,
+		Kind:               model.TransactionReversal,
+		Source:             model.SourceTransfer,
+		CounterpartyUserID: &sender,
+		TransferID:         in.TransferID,
+		Comment:            comment,
+		RefundOf:           &in.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, updateQuery, sender, currency, amount); err != nil {
+		return dbError("top up", err)
+	}
+	return recordTransaction(ctx, tx, entryID, &model.Transaction{
+		UserID:             sender,
+		Amount:             amount,
+		Currency:           currency,
+		Kind:               model.TransactionReversal,
+		Source:             model.SourceTransfer,
+		CounterpartyUserID: &recipient,
+		TransferID:         out.TransferID,
+		Comment:            comment,
+		RefundOf:           &out.ID,
+	})
+}
+
+func loadRefunds(ctx context.Context, q sqlx.QueryerContext, t *model.Transaction) error {
+	t.Refunds = nil
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE refund_of = $1 ORDER BY id"
+	if err := sqlx.SelectContext(ctx, q, &t.Refunds, query, t.ID); err != nil {
+		return dbError("get refunds", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var transactionRows = []string{
+	"id", "user_id", "amount", "currency", "kind", "source", "counterparty_user_id", "transfer_id",
+	"service_id", "order_id", "comment", "refund_of", "quote_id", "api_key_id", "request_id", "refunded", "date",
+}
+
+func TestStorage_Refund(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	date := time.Now().Format(time.RFC3339)
+	purchase := func(refunded float64) *sqlmock.Rows {
+		return sqlmock.NewRows(transactionRows).
+			AddRow(1, 1, decimal.NewFromFloat(-10), model.CurrencyRUB, model.TransactionPurchase, model.SourcePurchase,
+				nil, nil, 10, 100, "", nil, nil, nil, "", decimal.NewFromFloat(refunded), date)
+	}
+
+	type mockBehavior func(r *model.Refund)
+
+	tests := []struct {
+		name         string
+		mock         mockBehavior
+		input        *model.Refund
+		wantRefunded decimal.Decimal
+		wantErr      bool
+	}{
+		{
+			name: "Partial refund of purchase",
+			mock: func(r *model.Refund) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM transactions (.+) FOR UPDATE").
+					WithArgs(r.TransactionID).
+					WillReturnRows(purchase(0))
+				expectAccount(mock, int64(1), model.AccountActive)
+				// the purchase is refunded to the wallet from the revenue
+				mock.ExpectExec("UPDATE balances").
+					WithArgs(int64(1), model.CurrencyRUB, r.Amount).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectWallet(mock, 1)
+				mock.ExpectQuery("SELECT id FROM accounts WHERE code = \\$1").
+					WithArgs(accountPurchases, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectQuery("INSERT INTO journal_entries").
+					WithArgs(entryRefund).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectExec("INSERT INTO postings").
+					WithArgs(int64(2), int64(101), r.Amount, int64(2), r.Amount.Neg()).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec("INSERT INTO transactions").
+					WithArgs(int64(2), int64(1), r.Amount, model.CurrencyRUB, model.TransactionRefund, model.SourcePurchase,
+						nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), r.Comment, &r.TransactionID, nil, nil, "").
+					WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec("UPDATE transactions SET refunded").
+					WithArgs(r.TransactionID, r.Amount).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT (.+) FROM transactions WHERE refund_of = \\$1").
+					WithArgs(r.TransactionID).
+					WillReturnRows(sqlmock.NewRows(transactionRows).
+						AddRow(2, 1, r.Amount, model.CurrencyRUB, model.TransactionRefund, model.SourcePurchase,
+							nil, nil, 10, 100, r.Comment, r.TransactionID, nil, nil, "", decimal.Zero, date))
+				mock.ExpectCommit()
+			},
+			input: &model.Refund{
+				TransactionID: 1,
+				Amount:        decimal.NewFromFloat(4),
+				Comment:       "damaged",
+			},
+			wantRefunded: decimal.NewFromFloat(4),
+			wantErr:      false,
+		},
+		{
+			name: "User is deleted",
+			mock: func(r *model.Refund) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM transactions (.+) FOR UPDATE").
+					WithArgs(r.TransactionID).
+					WillReturnRows(purchase(0))
+				expectAccount(mock, int64(1), "")
+				mock.ExpectRollback()
+			},
+			input: &model.Refund{
+				TransactionID: 1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "More than refundable",
+			mock: func(r *model.Refund) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM transactions (.+) FOR UPDATE").
+					WithArgs(r.TransactionID).
+					WillReturnRows(purchase(4))
+				mock.ExpectRollback()
+			},
+			input: &model.Refund{
+				TransactionID: 1,
+				Amount:        decimal.NewFromFloat(7),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Already refunded",
+			mock: func(r *model.Refund) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM transactions (.+) FOR UPDATE").
+					WithArgs(r.TransactionID).
+					WillReturnRows(purchase(10))
+				mock.ExpectRollback()
+			},
+			input: &model.Refund{
+				TransactionID: 1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Refund of refund",
+			mock: func(r *model.Refund) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM transactions (.+) FOR UPDATE").
+					WithArgs(r.TransactionID).
+					WillReturnRows(sqlmock.NewRows(transactionRows).
+						AddRow(2, 1, decimal.NewFromFloat(4), model.CurrencyRUB, model.TransactionRefund, model.SourcePurchase,
+							nil, nil, 10, 100, "", 1, nil, nil, "", decimal.Zero, date))
+				mock.ExpectRollback()
+			},
+			input: &model.Refund{
+				TransactionID: 2,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Transfer recipient has insufficient funds",
+			mock: func(r *model.Refund) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM transactions (.+) FOR UPDATE").
+					WithArgs(r.TransactionID).
+					WillReturnRows(sqlmock.NewRows(transactionRows).
+						AddRow(3, 1, decimal.NewFromFloat(-10), model.CurrencyRUB, model.TransactionTransferOut, model.SourceTransfer,
+							2, 5, nil, nil, "", nil, nil, nil, "", decimal.Zero, date).
+						AddRow(4, 2, decimal.NewFromFloat(10), model.CurrencyRUB, model.TransactionTransferIn, model.SourceTransfer,
+							1, 5, nil, nil, "", nil, nil, nil, "", decimal.Zero, date))
+				expectAccount(mock, int64(1), model.AccountActive)
+				expectAccount(mock, int64(2), model.AccountActive)
+				mock.ExpectQuery("SELECT id, user_id, currency, amount, reserved FROM balances").
+					WithArgs(int64(1), int64(2), model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, 1, model.CurrencyRUB, decimal.NewFromFloat(0), decimal.Zero).
+						AddRow(2, 2, model.CurrencyRUB, decimal.NewFromFloat(3), decimal.Zero))
+				mock.ExpectRollback()
+			},
+			input: &model.Refund{
+				TransactionID: 3,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock(tt.input)
+			got, err := s.Refund(context.Background(), tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.Refund() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if !got.Refunded.Equal(tt.wantRefunded) {
+					t.Errorf("Storage.Refund() Refunded = %v, want %v", got.Refunded, tt.wantRefunded)
+				}
+				if len(got.Refunds) != 1 || got.Refunds[0].RefundOf == nil || *got.Refunds[0].RefundOf != got.ID {
+					t.Errorf("Storage.Refund() Refunds = %v, want the refund of %d", got.Refunds, got.ID)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
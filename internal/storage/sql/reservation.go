@@ -0,0 +1,247 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+const reservationColumns = `id, user_id, service_id, order_id, amount, captured, status,
+	created_at, expires_at, completed_at`
+
+// Reserve moves money from the available ruble balance of the user into a hold for the order.
+func (s *Storage) Reserve(ctx context.Context, r *model.Reservation, ttl time.Duration) (*model.Reservation, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	if err = checkAccount(ctx, tx, r.UserID, true); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE balances
+		SET amount = amount - $2, reserved = reserved + $2
+		WHERE user_id = $1 AND currency = $3 AND amount >= $2`
+	res, err := tx.ExecContext(ctx, query, r.UserID, r.Amount, model.CurrencyRUB)
+	if err != nil {
+		return nil, dbError("reserve", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		insufficientFunds.Inc("reserve")
+		return nil, model.Errorf(model.ErrInsufficientFunds, "insufficient funds")
+	}
+
+	_, err = postWalletEntry(ctx, tx, entryReserve, r.UserID, model.CurrencyRUB, accountReservations, r.Amount.Neg())
+	if err != nil {
+		return nil, err
+	}
+
+	var ans model.Reservation
+	query = `
+		INSERT INTO reservations (user_id, service_id, order_id, amount, expires_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')
+		ON CONFLICT (service_id, order_id) DO NOTHING
+		RETURNING ` + reservationColumns
+	err = tx.GetContext(ctx, &ans, query, r.UserID, r.ServiceID, r.OrderID, r.Amount, ttl.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrConflict, "order %d of service %d is already reserved", r.OrderID, r.ServiceID)
+	}
+	if err != nil {
+		return nil, dbError("create reservation", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit reservation", err)
+	}
+	return &ans, nil
+}
+
+// CaptureReservation charges the held money. If amount is zero the whole reservation is captured,
+// otherwise the remainder is returned to the available balance.
+func (s *Storage) CaptureReservation(ctx context.Context, r *model.Reservation,
+	amount decimal.Decimal,
+) (*model.Balance, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	held, err := lockHeldReservation(ctx, tx, r)
+	if err != nil {
+		return nil, err
+	}
+	// the clock of the database wrote expires_at, the TIMESTAMP keeps no time zone to compare with the host
+	var expired bool
+	err = tx.GetContext(ctx, &expired, "SELECT expires_at < CURRENT_TIMESTAMP FROM reservations WHERE id = $1", held.ID)
+	if err != nil {
+		return nil, dbError("check reservation expiry", err)
+	}
+	if expired {
+		return nil, model.Errorf(model.ErrConflict, "reservation is expired")
+	}
+
+	if amount.IsZero() {
+		amount = held.Amount
+	}
+	if amount.GreaterThan(held.Amount) {
+		return nil, model.Errorf(model.ErrInvalidAmount, "can't capture more than reserved %s", held.Amount.StringFixed(2))
+	}
+
+	// a reservation of a deleted or frozen account can only be released
+	if err = checkAccount(ctx, tx, held.UserID, true); err != nil {
+		return nil, err
+	}
+
+	ans, entryID, err := completeReservation(ctx, tx, held, model.ReservationCaptured, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordTransaction(ctx, tx, entryID, &model.Transaction{
+		UserID:    held.UserID,
+		Amount:    amount.Neg(),
+		Currency:  model.CurrencyRUB,
+		Kind:      model.TransactionPurchase,
+		Source:    model.SourcePurchase,
+		ServiceID: &held.ServiceID,
+		OrderID:   &held.OrderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit capture", err)
+	}
+	countMoney(model.CurrencyRUB, model.TransactionPurchase, amount.Neg())
+	return ans, nil
+}
+
+// ReleaseReservation returns the held money to the available balance.
+func (s *Storage) ReleaseReservation(ctx context.Context, r *model.Reservation) (*model.Balance, error) {
+	return s.releaseReservation(ctx, r, model.ReservationReleased)
+}
+
+// ReleaseExpiredReservations returns money of all expired holds, it returns the number of released holds.
+func (s *Storage) ReleaseExpiredReservations(ctx context.Context) (int64, error) {
+	var expired []model.Reservation
+	query := `
+		SELECT ` + reservationColumns + `
+		FROM reservations
+		WHERE status = $1 AND expires_at < CURRENT_TIMESTAMP`
+	if err := s.db.SelectContext(ctx, &expired, query, model.ReservationHeld); err != nil {
+		return 0, dbError("get expired reservations", err)
+	}
+
+	var n int64
+	for i := range expired {
+		if _, err := s.releaseReservation(ctx, &expired[i], model.ReservationExpired); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *Storage) releaseReservation(ctx context.Context, r *model.Reservation, status string) (*model.Balance, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	held, err := lockHeldReservation(ctx, tx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	ans, _, err := completeReservation(ctx, tx, held, status, decimal.Zero)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit release", err)
+	}
+	return ans, nil
+}
+
+func lockHeldReservation(ctx context.Context, tx *sqlx.Tx, r *model.Reservation) (*model.Reservation, error) {
+	var held model.Reservation
+	query := `
+		SELECT ` + reservationColumns + `
+		FROM reservations
+		WHERE user_id = $1 AND service_id = $2 AND order_id = $3
+		FOR UPDATE`
+	err := tx.GetContext(ctx, &held, query, r.UserID, r.ServiceID, r.OrderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "reservation for order %d of service %d not found", r.OrderID, r.ServiceID)
+	}
+	if err != nil {
+		return nil, dbError("get reservation", err)
+	}
+	if held.Status != model.ReservationHeld {
+		return nil, model.Errorf(model.ErrConflict, "reservation is already %s", held.Status)
+	}
+	return &held, nil
+}
+
+// completeReservation removes the hold from the balance, everything above captured goes back to the user.
+// It returns the updated balance and the journal entry of the movement.
+func completeReservation(ctx context.Context, tx *sqlx.Tx, held *model.Reservation, status string,
+	captured decimal.Decimal,
+) (*model.Balance, int64, error) {
+	query := `
+		UPDATE reservations
+		SET status = $2, captured = $3, completed_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, held.ID, status, captured); err != nil {
+		return nil, 0, dbError("update reservation", err)
+	}
+
+	var ans model.Balance
+	query = `
+		UPDATE balances
+		SET amount = amount + $2, reserved = reserved - $3
+		WHERE user_id = $1 AND currency = $4
+		RETURNING ` + balanceColumns
+	err := tx.GetContext(ctx, &ans, query, held.UserID, held.Amount.Sub(captured), held.Amount, model.CurrencyRUB)
+	if err != nil {
+		return nil, 0, dbError("update balance", err)
+	}
+
+	wallet, err := walletAccount(ctx, tx, held.UserID, model.CurrencyRUB)
+	if err != nil {
+		return nil, 0, err
+	}
+	hold, err := systemAccount(ctx, tx, accountReservations, model.CurrencyRUB)
+	if err != nil {
+		return nil, 0, err
+	}
+	revenue, err := systemAccount(ctx, tx, accountPurchases, model.CurrencyRUB)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kind := entryRelease
+	if status == model.ReservationCaptured {
+		kind = entryCapture
+	}
+	entryID, err := postEntry(ctx, tx, kind,
+		posting{accountID: hold, amount: held.Amount.Neg()},
+		posting{accountID: wallet, amount: held.Amount.Sub(captured)},
+		posting{accountID: revenue, amount: captured})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &ans, entryID, nil
+}
@@ -0,0 +1,267 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var reservationRows = []string{
+	"id", "user_id", "service_id", "order_id", "amount", "captured", "status",
+	"created_at", "expires_at", "completed_at",
+}
+
+func TestStorage_Reserve(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	type mockBehavior func(r *model.Reservation)
+
+	tests := []struct {
+		name    string
+		mock    mockBehavior
+		input   *model.Reservation
+		wantErr bool
+	}{
+		{
+			name: "OK",
+			mock: func(r *model.Reservation) {
+				mock.ExpectBegin()
+				expectAccount(mock, r.UserID, model.AccountActive)
+				mock.ExpectExec("UPDATE balances").
+					WithArgs(r.UserID, r.Amount, model.CurrencyRUB).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectWalletEntry(mock, r.UserID)
+				mock.ExpectQuery("INSERT INTO reservations").
+					WithArgs(r.UserID, r.ServiceID, r.OrderID, r.Amount, time.Minute.Seconds()).
+					WillReturnRows(sqlmock.NewRows(reservationRows).
+						AddRow(1, r.UserID, r.ServiceID, r.OrderID, r.Amount, decimal.Zero, model.ReservationHeld,
+							time.Now(), time.Now().Add(time.Minute), nil))
+				mock.ExpectCommit()
+			},
+			input: &model.Reservation{
+				UserID:    1,
+				ServiceID: 10,
+				OrderID:   100,
+				Amount:    decimal.NewFromFloat(5),
+			},
+			wantErr: false,
+		},
+		{
+			name: "Insufficient funds",
+			mock: func(r *model.Reservation) {
+				mock.ExpectBegin()
+				expectAccount(mock, r.UserID, model.AccountActive)
+				mock.ExpectExec("UPDATE balances").
+					WithArgs(r.UserID, r.Amount, model.CurrencyRUB).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			input: &model.Reservation{
+				UserID:    1,
+				ServiceID: 10,
+				OrderID:   101,
+				Amount:    decimal.NewFromFloat(500),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock(tt.input)
+			got, err := s.Reserve(context.Background(), tt.input, time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.Reserve() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if got.Status != model.ReservationHeld {
+					t.Errorf("Storage.Reserve() Status = %v, want %v", got.Status, model.ReservationHeld)
+				}
+				if got.Amount.Cmp(tt.input.Amount) != 0 {
+					t.Errorf("Storage.Reserve() Amount = %v, want %v", got.Amount, tt.input.Amount)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestStorage_CaptureReservation(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	held := &model.Reservation{
+		ID:        1,
+		UserID:    1,
+		ServiceID: 10,
+		OrderID:   100,
+		Amount:    decimal.NewFromFloat(10),
+	}
+
+	type mockBehavior func(amount decimal.Decimal)
+
+	tests := []struct {
+		name    string
+		mock    mockBehavior
+		amount  decimal.Decimal
+		want    *model.Balance
+		wantErr bool
+	}{
+		{
+			name: "Partial capture",
+			mock: func(amount decimal.Decimal) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM reservations").
+					WithArgs(held.UserID, held.ServiceID, held.OrderID).
+					WillReturnRows(sqlmock.NewRows(reservationRows).
+						AddRow(held.ID, held.UserID, held.ServiceID, held.OrderID, held.Amount, decimal.Zero,
+							model.ReservationHeld, time.Now(), time.Now().Add(time.Minute), nil))
+				expectExpired(mock, held.ID, false)
+				expectAccount(mock, held.UserID, model.AccountActive)
+				mock.ExpectExec("UPDATE reservations").
+					WithArgs(held.ID, model.ReservationCaptured, amount).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				// the remainder goes back to the available balance
+				mock.ExpectQuery("UPDATE balances").
+					WithArgs(held.UserID, held.Amount.Sub(amount), held.Amount, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, held.UserID, model.CurrencyRUB, decimal.NewFromFloat(6), decimal.Zero))
+				expectWallet(mock, held.UserID)
+				mock.ExpectQuery("SELECT id FROM accounts WHERE code = \\$1").
+					WithArgs(accountReservations, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectQuery("SELECT id FROM accounts WHERE code = \\$1").
+					WithArgs(accountPurchases, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectQuery("INSERT INTO journal_entries").
+					WithArgs(entryCapture).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				// hold is emptied, the remainder goes to the wallet and the captured part to the revenue
+				mock.ExpectExec("INSERT INTO postings").
+					WithArgs(int64(1), int64(3), held.Amount.Neg(), held.UserID+100, held.Amount.Sub(amount),
+						int64(2), amount).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectExec("INSERT INTO transactions").
+					WithArgs(int64(1), held.UserID, amount.Neg(), model.CurrencyRUB, model.TransactionPurchase, model.SourcePurchase,
+						nil, nil, &held.ServiceID, &held.OrderID, "", nil, nil, nil, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			amount: decimal.NewFromFloat(4),
+			want: &model.Balance{
+				ID:     1,
+				UserID: 1,
+				Amount: decimal.NewFromFloat(6),
+			},
+			wantErr: false,
+		},
+		{
+			name: "User is deleted",
+			mock: func(_ decimal.Decimal) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM reservations").
+					WithArgs(held.UserID, held.ServiceID, held.OrderID).
+					WillReturnRows(sqlmock.NewRows(reservationRows).
+						AddRow(held.ID, held.UserID, held.ServiceID, held.OrderID, held.Amount, decimal.Zero,
+							model.ReservationHeld, time.Now(), time.Now().Add(time.Minute), nil))
+				expectExpired(mock, held.ID, false)
+				expectAccount(mock, held.UserID, "")
+				mock.ExpectRollback()
+			},
+			amount:  decimal.Zero,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "More than reserved",
+			mock: func(_ decimal.Decimal) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM reservations").
+					WithArgs(held.UserID, held.ServiceID, held.OrderID).
+					WillReturnRows(sqlmock.NewRows(reservationRows).
+						AddRow(held.ID, held.UserID, held.ServiceID, held.OrderID, held.Amount, decimal.Zero,
+							model.ReservationHeld, time.Now(), time.Now().Add(time.Minute), nil))
+				expectExpired(mock, held.ID, false)
+				mock.ExpectRollback()
+			},
+			amount:  decimal.NewFromFloat(11),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Expired",
+			mock: func(_ decimal.Decimal) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM reservations").
+					WithArgs(held.UserID, held.ServiceID, held.OrderID).
+					WillReturnRows(sqlmock.NewRows(reservationRows).
+						AddRow(held.ID, held.UserID, held.ServiceID, held.OrderID, held.Amount, decimal.Zero,
+							model.ReservationHeld, time.Now(), time.Now().Add(-time.Minute), nil))
+				expectExpired(mock, held.ID, true)
+				mock.ExpectRollback()
+			},
+			amount:  decimal.Zero,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Already released",
+			mock: func(_ decimal.Decimal) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM reservations").
+					WithArgs(held.UserID, held.ServiceID, held.OrderID).
+					WillReturnRows(sqlmock.NewRows(reservationRows).
+						AddRow(held.ID, held.UserID, held.ServiceID, held.OrderID, held.Amount, decimal.Zero,
+							model.ReservationReleased, time.Now(), time.Now().Add(time.Minute), time.Now()))
+				mock.ExpectRollback()
+			},
+			amount:  decimal.Zero,
+			want:    nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock(tt.amount)
+			got, err := s.CaptureReservation(context.Background(), held, tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.CaptureReservation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Amount.Cmp(tt.want.Amount) != 0 {
+				t.Errorf("Storage.CaptureReservation() Amount = %v, want %v", got.Amount, tt.want.Amount)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// expectExpired expects the check of the reservation expiry by the clock of the database.
+func expectExpired(mock sqlmock.Sqlmock, id int64, expired bool) {
+	mock.ExpectQuery("SELECT expires_at < CURRENT_TIMESTAMP FROM reservations").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"expired"}).AddRow(expired))
+}
@@ -2,14 +2,19 @@ package sqlstorage
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/cronnoss/avitotech/internal/model"
-	_ "github.com/jackc/pgx/stdlib" // pgx driver
+	"github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
 	"github.com/shopspring/decimal"
 )
 
+const balanceColumns = `id, user_id, currency, amount, reserved`
+
 type Storage struct {
 	dsn string
 	db  *sqlx.DB
@@ -20,14 +25,11 @@ func New(dsn string) *Storage {
 }
 
 func (s *Storage) Connect(ctx context.Context) error {
-	db, err := sqlx.Open("pgx", s.dsn)
-	if err != nil {
-		return fmt.Errorf("failed to load driver: %w", err)
-	}
-	s.db = db
-	err = s.db.PingContext(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to connect to db: %w", err)
+	// the connections of the pgx driver are wrapped to measure the statements
+	db := sql.OpenDB(&connector{dsn: s.dsn, driver: stdlib.GetDefaultDriver()})
+	s.db = sqlx.NewDb(db, "pgx")
+	if err := s.db.PingContext(ctx); err != nil {
+		return dbError("connect to db", err)
 	}
 	return nil
 }
@@ -38,131 +40,460 @@ func (s *Storage) Close(ctx context.Context) error {
 	return nil
 }
 
+// GetBalance returns the balance of the b.Currency wallet of the user verified against the postings of the ledger.
 func (s *Storage) GetBalance(ctx context.Context, b *model.Balance) (*model.Balance, error) {
-	var ans model.Balance
-	query := `SELECT * FROM balances WHERE user_id = $1`
-	err := s.db.QueryRowxContext(ctx, query, b.UserID).
-		Scan(&ans.ID, &ans.UserID, &ans.Amount)
+	wallets, err := s.wallets(ctx, b.UserID, b.Currency)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get balance: %w", err)
+		return nil, err
+	}
+	if len(wallets) == 0 {
+		return nil, model.Errorf(model.ErrNotFound, "user with ID %d has no %s balance", b.UserID, b.Currency)
 	}
+	return &wallets[0], nil
+}
 
-	return &ans, nil
+// ListWallets returns the balances of all wallets of the user ordered by currency.
+func (s *Storage) ListWallets(ctx context.Context, userID int64) ([]model.Balance, error) {
+	return s.wallets(ctx, userID, "")
 }
 
-func (s *Storage) TopUp(ctx context.Context, userID int64, amount decimal.Decimal, by string) (*model.Balance, error) {
-	var ans model.Balance
+// wallets returns the wallets of the user verified against the postings of the ledger,
+// empty currency selects all of them.
+func (s *Storage) wallets(ctx context.Context, userID int64, currency string) ([]model.Balance, error) {
+	args := []interface{}{userID}
+	query := `
+		SELECT b.id, b.user_id, b.currency, b.amount, b.reserved,
+		       COALESCE((SELECT SUM(p.amount)
+		                 FROM postings p
+		                 JOIN accounts a ON a.id = p.account_id
+		                 WHERE a.user_id = b.user_id AND a.currency = b.currency), 0)
+		FROM balances b
+		WHERE b.user_id = $1`
+	if currency != "" {
+		args = append(args, currency)
+		query += " AND b.currency = $2"
+	}
+	query += `
+		ORDER BY b.currency`
 
-	var userExists bool
-	err := s.db.QueryRowxContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&userExists)
+	rows, err := s.db.QueryxContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check user existence: %w", err)
+		return nil, dbError("get balance", err)
 	}
-	if !userExists {
-		return nil, fmt.Errorf("user with ID %d does not exist", userID)
+	defer rows.Close()
+
+	var ans []model.Balance
+	for rows.Next() {
+		var b model.Balance
+		var ledger decimal.Decimal
+		if err = rows.Scan(&b.ID, &b.UserID, &b.Currency, &b.Amount, &b.Reserved, &ledger); err != nil {
+			return nil, dbError("get balance", err)
+		}
+		if !ledger.Equal(b.Amount) {
+			return nil, fmt.Errorf("balance %s%s of user %d doesn't match the ledger %s",
+				b.Amount.StringFixed(2), b.Currency, b.UserID, ledger.StringFixed(2))
+		}
+		ans = append(ans, b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbError("get balance", err)
 	}
+	return ans, nil
+}
 
-	query := `
-        INSERT INTO balances (user_id, amount) 
-        VALUES ($1, $2)
-        ON CONFLICT (user_id) DO UPDATE 
-        SET amount = balances.amount + EXCLUDED.amount
-        RETURNING id, user_id, amount`
-	err = s.db.QueryRowxContext(ctx, query, userID, amount).
-		Scan(&ans.ID, &ans.UserID, &ans.Amount)
+// TopUp credits the t.Currency wallet of t.UserID with t.Amount and records t.
+func (s *Storage) TopUp(ctx context.Context, t *model.Transaction) (*model.Balance, error) {
+	userID, currency, amount := t.UserID, t.Currency, t.Amount
+	counter, err := counterAccount(t.Source)
 	if err != nil {
-		return nil, fmt.Errorf("failed to top up: %w", err)
+		return nil, err
 	}
 
-	operation := fmt.Sprintf("Top-up by %s %sRUB", by, amount.StringFixed(2))
-	transactionQuery := `
-        INSERT INTO transactions (user_id, amount, operation) 
-        VALUES ($1, $2, $3)`
-	_, err = s.db.ExecContext(ctx, transactionQuery, userID, amount, operation)
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to record transaction: %w", err)
+		return nil, dbError("begin transaction", err)
 	}
+	defer tx.Rollback() // no-op after a successful commit
 
-	return &ans, nil
-}
+	if err = checkAccount(ctx, tx, userID, false); err != nil {
+		return nil, err
+	}
 
-func (s *Storage) Debit(ctx context.Context, userID int64, amount decimal.Decimal, by string) (*model.Balance, error) {
 	var ans model.Balance
+	query := `
+		INSERT INTO balances (user_id, currency, amount)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, currency) DO UPDATE
+		SET amount = balances.amount + EXCLUDED.amount
+		RETURNING ` + balanceColumns
+	err = tx.GetContext(ctx, &ans, query, userID, currency, amount)
+	if err != nil {
+		return nil, dbError("top up", err)
+	}
 
-	var userExists bool
-	err := s.db.QueryRowxContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&userExists)
+	entryID, err := postWalletEntry(ctx, tx, entryTopUp, userID, currency, counter, amount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check user existence: %w", err)
+		return nil, err
+	}
+
+	if err = recordTransaction(ctx, tx, entryID, t); err != nil {
+		return nil, err
 	}
-	if !userExists {
-		return nil, fmt.Errorf("user with ID %d does not exist", userID)
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit top-up", err)
+	}
+	countMoney(currency, t.Kind, amount)
+	return &ans, nil
+}
+
+// Debit adds negative t.Amount to the t.Currency wallet of t.UserID and records t.
+// With t.QuoteID the quote is used up and its amount is charged, t.Amount may be zero then.
+func (s *Storage) Debit(ctx context.Context, t *model.Transaction) (*model.Balance, error) {
+	userID, currency, amount := t.UserID, t.Currency, t.Amount
+	counter, err := counterAccount(t.Source)
+	if err != nil {
+		return nil, err
 	}
 
-	balance, err := s.GetBalance(ctx, &model.Balance{UserID: userID})
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("user has no balance: %w", err)
+		return nil, dbError("begin transaction", err)
 	}
+	defer tx.Rollback() // no-op after a successful commit
 
-	newBalance := balance.Amount.Add(amount) // amount is negative, so we add it
-	if newBalance.LessThan(decimal.Zero) {
-		return nil, fmt.Errorf("insufficient funds")
+	if err = checkAccount(ctx, tx, userID, true); err != nil {
+		return nil, err
 	}
 
+	// a quoted purchase is charged exactly the quoted rubles
+	if t.QuoteID != nil {
+		q, err := useQuote(ctx, tx, *t.QuoteID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !amount.IsZero() && !amount.Equal(q.QuotedAmount.Neg()) {
+			return nil, model.Errorf(model.ErrInvalidAmount, "amount differs from the quoted %s",
+				q.QuotedAmount.StringFixed(2))
+		}
+		amount = q.QuotedAmount.Neg()
+		quoted := *t
+		quoted.Amount = amount
+		t = &quoted
+	}
+
+	// the check and the update are a single statement, so concurrent debits
+	// can't both pass the check against the same old balance;
+	// amount is negative, so we add it
+	var ans model.Balance
 	query := `
-		UPDATE balances 
-		SET amount = $2
-		WHERE user_id = $1
-		RETURNING id, user_id, amount`
-	err = s.db.QueryRowxContext(ctx, query, userID, newBalance).
-		Scan(&ans.ID, &ans.UserID, &ans.Amount)
+		UPDATE balances
+		SET amount = amount + $3
+		WHERE user_id = $1 AND currency = $2 AND amount + $3 >= 0
+		RETURNING ` + balanceColumns
+	err = tx.GetContext(ctx, &ans, query, userID, currency, amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		var hasBalance bool
+		err = tx.QueryRowxContext(ctx, "SELECT EXISTS(SELECT 1 FROM balances WHERE user_id = $1 AND currency = $2)",
+			userID, currency).Scan(&hasBalance)
+		if err != nil {
+			return nil, dbError("check balance existence", err)
+		}
+		if !hasBalance {
+			return nil, model.Errorf(model.ErrNotFound, "user has no %s balance", currency)
+		}
+		insufficientFunds.Inc("debit")
+		return nil, model.Errorf(model.ErrInsufficientFunds, "insufficient funds")
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to debit: %w", err)
+		return nil, dbError("debit", err)
 	}
 
-	operation := fmt.Sprintf("Debit by %s %sRUB", by, amount.StringFixed(2))
-	transactionQuery := `
-		INSERT INTO transactions (user_id, amount, operation) 
-		VALUES ($1, $2, $3)`
-	_, err = s.db.ExecContext(ctx, transactionQuery, userID, amount, operation)
+	entryID, err := postWalletEntry(ctx, tx, entryPurchase, userID, currency, counter, amount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to record transaction: %w", err)
+		return nil, err
+	}
+
+	if err = recordTransaction(ctx, tx, entryID, t); err != nil {
+		return nil, err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit debit", err)
+	}
+	countMoney(currency, t.Kind, amount)
 	return &ans, nil
 }
 
-func (s *Storage) GetTransactions(ctx context.Context, userID int64) ([]model.Transaction, error) {
-	var ans []model.Transaction
+// Transfer moves t.Amount from the t.Currency wallet of the sender to the t.ToCurrency wallet
+// of the recipient, who gets t.ToAmount. Money of different currencies is exchanged
+// through the currency exchange account. The created transfer is returned.
+func (s *Storage) Transfer(ctx context.Context, t *model.Transfer) (*model.Transfer, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
 
-	// sorted from oldest to newest
-	query := `SELECT * FROM transactions WHERE user_id = $1`
-	err := s.db.SelectContext(ctx, &ans, query, userID)
+	// the sender must be able to pay and the recipient to receive
+	if err = checkAccount(ctx, tx, t.FromID, true); err != nil {
+		return nil, err
+	}
+	if err = checkAccount(ctx, tx, t.ToID, false); err != nil {
+		return nil, err
+	}
+
+	ans, err := transfer(ctx, tx, t)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transactions: %w", err)
+		return nil, err
 	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit transfer", err)
+	}
+	countTransfer(ans)
 	return ans, nil
 }
 
-func (s *Storage) GetTransactionsByDate(ctx context.Context, userID int64) ([]model.Transaction, error) {
-	var ans []model.Transaction
+// transfer moves the money of t within tx and returns the created transfer with the wallet
+// of the recipient, the caller checks the accounts of both users.
+func transfer(ctx context.Context, tx *sqlx.Tx, t *model.Transfer) (*model.Transfer, error) {
+	fromID, toID, amount, toAmount := t.FromID, t.ToID, t.Amount, t.ToAmount
+
+	// the recipient may not have a wallet yet, it appears on the first credit
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO balances (user_id, currency, amount)
+		VALUES ($1, $2, 0)
+		ON CONFLICT (user_id, currency) DO NOTHING`, toID, t.ToCurrency)
+	if err != nil {
+		return nil, dbError("create balance", err)
+	}
 
-	// sorted from newest to oldest
-	query := `SELECT * FROM transactions WHERE user_id = $1 ORDER BY date DESC`
-	err := s.db.SelectContext(ctx, &ans, query, userID)
+	// lock both rows in user_id and currency order, so opposite-direction transfers can't deadlock
+	var locked []model.Balance
+	err = tx.SelectContext(ctx, &locked, `
+		SELECT `+balanceColumns+` FROM balances
+		WHERE (user_id = $1 AND currency = $2) OR (user_id = $3 AND currency = $4)
+		ORDER BY user_id, currency
+		FOR UPDATE`, fromID, t.Currency, toID, t.ToCurrency)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transactions: %w", err)
+		return nil, dbError("lock balances", err)
+	}
+
+	var from *model.Balance
+	for i := range locked {
+		if locked[i].UserID == fromID && locked[i].Currency == t.Currency {
+			from = &locked[i]
+		}
+	}
+	if from == nil {
+		return nil, model.Errorf(model.ErrNotFound, "user has no %s balance", t.Currency)
+	}
+	if from.Amount.LessThan(amount) {
+		insufficientFunds.Inc("transfer")
+		return nil, model.Errorf(model.ErrInsufficientFunds, "insufficient funds")
+	}
+
+	ans, err := createTransfer(ctx, tx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	fromWallet, err := walletAccount(ctx, tx, fromID, t.Currency)
+	if err != nil {
+		return nil, err
+	}
+	toWallet, err := walletAccount(ctx, tx, toID, t.ToCurrency)
+	if err != nil {
+		return nil, err
+	}
+	postings := []posting{
+		{accountID: fromWallet, amount: amount.Neg()},
+		{accountID: toWallet, amount: toAmount},
+	}
+	if t.Currency != t.ToCurrency {
+		fromExchange, err := systemAccount(ctx, tx, accountExchange, t.Currency)
+		if err != nil {
+			return nil, err
+		}
+		toExchange, err := systemAccount(ctx, tx, accountExchange, t.ToCurrency)
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings,
+			posting{accountID: fromExchange, amount: amount},
+			posting{accountID: toExchange, amount: toAmount.Neg()})
+	}
+	entryID, err := postEntry(ctx, tx, entryTransfer, postings...)
+	if err != nil {
+		return nil, err
+	}
+
+	updateQuery := `
+		UPDATE balances
+		SET amount = amount + $3
+		WHERE user_id = $1 AND currency = $2`
+
+	_, err = tx.ExecContext(ctx, updateQuery, fromID, t.Currency, amount.Neg())
+	if err != nil {
+		return nil, dbError("debit", err)
+	}
+	err = recordTransaction(ctx, tx, entryID, &model.Transaction{
+		UserID:             fromID,
+		Amount:             amount.Neg(),
+		Currency:           t.Currency,
+		Kind:               model.TransactionTransferOut,
+		Source:             model.SourceTransfer,
+		CounterpartyUserID: &toID,
+		TransferID:         &ans.ID,
+		Comment:            t.Comment,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var toBalance model.Balance
+	err = tx.GetContext(ctx, &toBalance, updateQuery+`
+		RETURNING `+balanceColumns, toID, t.ToCurrency, toAmount)
+	if err != nil {
+		return nil, dbError("top up", err)
+	}
+	ans.ToBalance = &toBalance
+	err = recordTransaction(ctx, tx, entryID, &model.Transaction{
+		UserID:             toID,
+		Amount:             toAmount,
+		Currency:           t.ToCurrency,
+		Kind:               model.TransactionTransferIn,
+		Source:             model.SourceTransfer,
+		CounterpartyUserID: &fromID,
+		TransferID:         &ans.ID,
+		Comment:            t.Comment,
+	})
+	if err != nil {
+		return nil, err
 	}
 	return ans, nil
 }
 
-func (s *Storage) GetTransactionsByAmount(ctx context.Context, userID int64) ([]model.Transaction, error) {
-	var ans []model.Transaction
+// checkAccount fails with ErrNotFound unless the user exists and with ErrAccountFrozen unless the status
+// of its account lets money in or, for a debit, out of its wallets. Deleted users can't move money.
+// The status can't change until tx ends.
+func checkAccount(ctx context.Context, tx *sqlx.Tx, userID int64, debit bool) error {
+	var status string
+	query := "SELECT status FROM users WHERE id = $1 AND deleted_at IS NULL FOR SHARE"
+	err := tx.QueryRowxContext(ctx, query, userID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Errorf(model.ErrNotFound, "user with ID %d does not exist", userID)
+	}
+	if err != nil {
+		return dbError("check user account", err)
+	}
+	if status == model.AccountActive || status == model.AccountDebitFrozen && !debit {
+		return nil
+	}
+	return model.Errorf(model.ErrAccountFrozen, "account of user %d is %s", userID, strings.ReplaceAll(status, "_", "-"))
+}
 
-	// sorted from highest to lowest
-	query := `SELECT * FROM transactions WHERE user_id = $1 ORDER BY amount DESC`
-	err := s.db.SelectContext(ctx, &ans, query, userID)
+// recordTransaction adds the user-facing record of the journal entry made by the caller and the request of ctx.
+func recordTransaction(ctx context.Context, tx *sqlx.Tx, entryID int64, t *model.Transaction) error {
+	var apiKeyID *int64
+	if caller := model.CallerFromContext(ctx); caller != nil {
+		apiKeyID = &caller.ID
+	}
+	transactionQuery := `
+		INSERT INTO transactions (entry_id, user_id, amount, currency, kind, source, counterparty_user_id,
+		                          transfer_id, service_id, order_id, comment, refund_of, quote_id, api_key_id,
+		                          request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+	_, err := tx.ExecContext(ctx, transactionQuery, entryID, t.UserID, t.Amount, t.Currency, t.Kind, t.Source,
+		t.CounterpartyUserID, t.TransferID, t.ServiceID, t.OrderID, t.Comment, t.RefundOf, t.QuoteID, apiKeyID,
+		model.RequestIDFromContext(ctx))
+	if isUniqueViolation(err) && t.QuoteID != nil {
+		return model.Errorf(model.ErrConflict, "quote %d is already used", *t.QuoteID)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transactions: %w", err)
+		return dbError("record transaction", err)
+	}
+	return nil
+}
+
+// ListTransactions returns transactions matching the filter, the filter must be validated by the caller.
+func (s *Storage) ListTransactions(ctx context.Context, f *model.TransactionFilter) ([]model.Transaction, error) {
+	sortColumn, ok := map[string]string{
+		model.SortByID:     "t.id",
+		model.SortByDate:   "t.date",
+		model.SortByAmount: "t.amount",
+	}[f.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q", f.SortBy)
+	}
+	order, cmp := "ASC", ">"
+	if f.Order == model.OrderDesc {
+		order, cmp = "DESC", "<"
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var where []string
+	if f.UserID != 0 {
+		where = append(where, "t.user_id = "+arg(f.UserID))
+	}
+	if f.From != nil {
+		where = append(where, "t.date >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		where = append(where, "t.date < "+arg(*f.To))
+	}
+	if f.Type != "" {
+		where = append(where, "t.kind = "+arg(f.Type))
+	}
+	if f.RequestID != "" {
+		where = append(where, "t.request_id = "+arg(f.RequestID))
+	}
+	switch f.Direction {
+	case model.DirectionIn:
+		where = append(where, "t.amount > 0")
+	case model.DirectionOut:
+		where = append(where, "t.amount < 0")
+	}
+	if f.MinAmount != nil {
+		where = append(where, "ABS(t.amount) >= "+arg(*f.MinAmount))
+	}
+	if f.MaxAmount != nil {
+		where = append(where, "ABS(t.amount) <= "+arg(*f.MaxAmount))
+	}
+	if f.After != nil {
+		switch f.SortBy {
+		case model.SortByDate:
+			where = append(where, fmt.Sprintf("(t.date, t.id) %s (%s, %s)", cmp, arg(f.After.Date), arg(f.After.ID)))
+		case model.SortByAmount:
+			where = append(where, fmt.Sprintf("(t.amount, t.id) %s (%s, %s)", cmp, arg(f.After.Amount), arg(f.After.ID)))
+		default:
+			where = append(where, fmt.Sprintf("t.id %s %s", cmp, arg(f.After.ID)))
+		}
+	}
+
+	orderBy := sortColumn + " " + order
+	if sortColumn != "t.id" {
+		orderBy += ", t.id " + order
+	}
+
+	query := `
+		SELECT t.id, t.user_id, t.amount, t.currency, t.kind, t.source, t.counterparty_user_id, t.transfer_id,
+		       t.service_id, t.order_id, t.comment, t.refund_of, t.quote_id, t.api_key_id, t.request_id,
+		       t.refunded, t.date
+		FROM transactions t
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY ` + orderBy + `
+		LIMIT ` + arg(f.Limit)
+
+	var ans []model.Transaction
+	if err := s.db.SelectContext(ctx, &ans, query, args...); err != nil {
+		return nil, dbError("get transactions", err)
 	}
 	return ans, nil
 }
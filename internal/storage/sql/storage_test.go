@@ -2,15 +2,20 @@ package sqlstorage
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jackc/pgx"
 	"github.com/shopspring/decimal"
 	sqlmock "github.com/zhashkevych/go-sqlxmock"
 )
 
 const testDSN = "sqlmock_db_0"
 
+var balanceRows = []string{"id", "user_id", "currency", "amount", "reserved"}
+
 func TestStorage_GetBalance(t *testing.T) {
 	s := New(testDSN)
 	if s == nil {
@@ -41,14 +46,16 @@ func TestStorage_GetBalance(t *testing.T) {
 			name: "OK",
 			mock: func(args args) {
 				// Mocking the balance retrieval
-				mock.ExpectQuery("^SELECT \\* FROM balances WHERE user_id = \\$1$").
-					WithArgs(args.b.UserID).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).
-						AddRow(1, args.b.UserID, decimal.NewFromFloat(100.00)))
+				mock.ExpectQuery("SELECT (.+) FROM balances b WHERE b.user_id = \\$1 AND b.currency = \\$2").
+					WithArgs(args.b.UserID, args.b.Currency).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "currency", "amount", "reserved", "ledger"}).
+						AddRow(1, args.b.UserID, args.b.Currency, decimal.NewFromFloat(100.00), decimal.Zero,
+							decimal.NewFromFloat(100.00)))
 			},
 			input: args{
 				b: &model.Balance{
-					UserID: 1,
+					UserID:   1,
+					Currency: model.CurrencyRUB,
 				},
 			},
 			want: &model.Balance{
@@ -62,14 +69,32 @@ func TestStorage_GetBalance(t *testing.T) {
 			name: "Not found",
 			mock: func(args args) {
 				// Mocking the balance retrieval
-				mock.ExpectQuery("^SELECT \\* FROM balances WHERE user_id = \\$1$").
-					WithArgs(args.b.UserID).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).
-						AddRow(nil, nil, nil))
+				mock.ExpectQuery("SELECT (.+) FROM balances b WHERE b.user_id = \\$1 AND b.currency = \\$2").
+					WithArgs(args.b.UserID, args.b.Currency).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "currency", "amount", "reserved", "ledger"}))
 			},
 			input: args{
 				b: &model.Balance{
-					UserID: 1,
+					UserID:   1,
+					Currency: model.CurrencyRUB,
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Doesn't match the ledger",
+			mock: func(args args) {
+				mock.ExpectQuery("SELECT (.+) FROM balances b WHERE b.user_id = \\$1 AND b.currency = \\$2").
+					WithArgs(args.b.UserID, args.b.Currency).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "currency", "amount", "reserved", "ledger"}).
+						AddRow(1, args.b.UserID, args.b.Currency, decimal.NewFromFloat(100.00), decimal.Zero,
+							decimal.NewFromFloat(90.00)))
+			},
+			input: args{
+				b: &model.Balance{
+					UserID:   1,
+					Currency: model.CurrencyRUB,
 				},
 			},
 			want:    nil,
@@ -128,21 +153,27 @@ func TestStorage_TopUp(t *testing.T) {
 		{
 			name: "OK",
 			mock: func(args args) {
+				mock.ExpectBegin()
+
 				// Mocking the user existence check
-				mock.ExpectQuery("SELECT EXISTS").
-					WithArgs(args.userID).
-					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				expectAccount(mock, args.userID, model.AccountActive)
 
 				// Mocking the balance update
 				mock.ExpectQuery("INSERT INTO balances").
-					WithArgs(args.userID, args.amount).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).
-						AddRow(1, args.userID, args.amount))
+					WithArgs(args.userID, model.CurrencyRUB, args.amount).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, args.userID, model.CurrencyRUB, args.amount, decimal.Zero))
+
+				// Mocking the journal entry
+				expectWalletEntry(mock, args.userID)
 
 				// Mocking the transaction recording
 				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(args.userID, args.amount, sqlmock.AnyArg()).
+					WithArgs(int64(1), args.userID, args.amount, model.CurrencyRUB, model.TransactionTopUp, model.SourceBankCard,
+						nil, nil, nil, nil, "", nil, nil, nil, "").
 					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectCommit()
 			},
 			input: args{
 				userID: 1,
@@ -155,12 +186,32 @@ func TestStorage_TopUp(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "User does not exist",
+			mock: func(args args) {
+				mock.ExpectBegin()
+				expectAccount(mock, args.userID, "")
+				mock.ExpectRollback()
+			},
+			input: args{
+				userID: 42,
+				amount: decimal.NewFromFloat(100.00),
+			},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mock(tt.input)
-			got, err := s.TopUp(context.Background(), tt.input.userID, tt.input.amount, "some by")
+			got, err := s.TopUp(context.Background(), &model.Transaction{
+				UserID:   tt.input.userID,
+				Amount:   tt.input.amount,
+				Currency: model.CurrencyRUB,
+				Kind:     model.TransactionTopUp,
+				Source:   model.SourceBankCard,
+			})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Storage.TopUp() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -184,6 +235,83 @@ func TestStorage_TopUp(t *testing.T) {
 	}
 }
 
+func TestStorage_TopUpRecordsRequestID(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	amount := decimal.NewFromFloat(100)
+
+	mock.ExpectBegin()
+	expectAccount(mock, int64(1), model.AccountActive)
+	mock.ExpectQuery("INSERT INTO balances").
+		WithArgs(int64(1), model.CurrencyRUB, amount).
+		WillReturnRows(sqlmock.NewRows(balanceRows).AddRow(1, 1, model.CurrencyRUB, amount, decimal.Zero))
+	expectWalletEntry(mock, 1)
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs(int64(1), int64(1), amount, model.CurrencyRUB, model.TransactionTopUp, model.SourceBankCard,
+			nil, nil, nil, nil, "", nil, nil, nil, "billing-42").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	ctx := model.ContextWithRequestID(context.Background(), "billing-42")
+	_, err = s.TopUp(ctx, &model.Transaction{
+		UserID:   1,
+		Amount:   amount,
+		Currency: model.CurrencyRUB,
+		Kind:     model.TransactionTopUp,
+		Source:   model.SourceBankCard,
+	})
+	if err != nil {
+		t.Fatalf("Storage.TopUp() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_TopUpUsedQuote(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	amount := decimal.NewFromFloat(100)
+	quoteID := int64(5)
+
+	mock.ExpectBegin()
+	expectAccount(mock, int64(1), model.AccountActive)
+	mock.ExpectQuery("INSERT INTO balances").
+		WithArgs(int64(1), model.CurrencyRUB, amount).
+		WillReturnRows(sqlmock.NewRows(balanceRows).AddRow(1, 1, model.CurrencyRUB, amount, decimal.Zero))
+	expectWalletEntry(mock, 1)
+	mock.ExpectExec("INSERT INTO transactions").
+		WillReturnError(pgx.PgError{Code: uniqueViolation})
+	mock.ExpectRollback()
+
+	_, err = s.TopUp(context.Background(), &model.Transaction{
+		UserID:   1,
+		Amount:   amount,
+		Currency: model.CurrencyRUB,
+		Kind:     model.TransactionTopUp,
+		Source:   model.SourceBankCard,
+		QuoteID:  &quoteID,
+	})
+	if !errors.Is(err, model.ErrConflict) {
+		t.Fatalf("Storage.TopUp() error = %v, want %v", err, model.ErrConflict)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestStorage_Debit(t *testing.T) {
 	s := New(testDSN)
 	if s == nil {
@@ -213,27 +341,27 @@ func TestStorage_Debit(t *testing.T) {
 		{
 			name: "OK",
 			mock: func(args args) {
-				// Mocking the user existence check
-				mock.ExpectQuery("SELECT EXISTS").
-					WithArgs(args.userID).
-					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectBegin()
 
-				// Mocking the balance retrieval
-				mock.ExpectQuery("^SELECT \\* FROM balances WHERE user_id = \\$1$").
-					WithArgs(args.userID).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).
-						AddRow(1, args.userID, decimal.NewFromFloat(10)))
+				// Mocking the user existence check
+				expectAccount(mock, args.userID, model.AccountActive)
 
-				// Mocking the balance update
+				// Mocking the guarded balance update
 				mock.ExpectQuery("UPDATE balances").
-					WithArgs(args.userID, decimal.NewFromFloat(5)).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount"}).
-						AddRow(1, args.userID, decimal.NewFromFloat(5)))
+					WithArgs(args.userID, model.CurrencyRUB, args.amount).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, args.userID, model.CurrencyRUB, decimal.NewFromFloat(5), decimal.Zero))
+
+				// Mocking the journal entry
+				expectWalletEntry(mock, args.userID)
 
 				// Mocking the transaction recording
 				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(args.userID, args.amount, sqlmock.AnyArg()).
+					WithArgs(int64(1), args.userID, args.amount, model.CurrencyRUB, model.TransactionPurchase, model.SourcePurchase,
+						nil, nil, nil, nil, "", nil, nil, nil, "").
 					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectCommit()
 			},
 			input: args{
 				userID: 1,
@@ -246,12 +374,40 @@ func TestStorage_Debit(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Insufficient funds",
+			mock: func(args args) {
+				mock.ExpectBegin()
+				expectAccount(mock, args.userID, model.AccountActive)
+
+				// the guard rejects the update, so no row is returned
+				mock.ExpectQuery("UPDATE balances").
+					WithArgs(args.userID, model.CurrencyRUB, args.amount).
+					WillReturnRows(sqlmock.NewRows(balanceRows))
+				mock.ExpectQuery("SELECT EXISTS").
+					WithArgs(args.userID, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+			input: args{
+				userID: 1,
+				amount: decimal.NewFromFloat(-500),
+			},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mock(tt.input)
-			got, err := s.Debit(context.Background(), tt.input.userID, tt.input.amount, "some by")
+			got, err := s.Debit(context.Background(), &model.Transaction{
+				UserID:   tt.input.userID,
+				Amount:   tt.input.amount,
+				Currency: model.CurrencyRUB,
+				Kind:     model.TransactionPurchase,
+				Source:   model.SourcePurchase,
+			})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Storage.Debit() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -275,7 +431,7 @@ func TestStorage_Debit(t *testing.T) {
 	}
 }
 
-func TestStorage_GetTransactions(t *testing.T) {
+func TestStorage_ListTransactions(t *testing.T) {
 	s := New(testDSN)
 	if s == nil {
 		t.Error("New() should not return nil")
@@ -287,68 +443,466 @@ func TestStorage_GetTransactions(t *testing.T) {
 	s.db = db
 	defer db.Close()
 
-	type args struct {
-		userID int64
-	}
+	minAmount := decimal.NewFromFloat(5)
 
-	type mockBehavior func(args args)
+	type mockBehavior func(f *model.TransactionFilter)
 
 	tests := []struct {
 		name    string
 		mock    mockBehavior
-		input   args
+		input   *model.TransactionFilter
 		want    []model.Transaction
 		wantErr bool
 	}{
 		{
 			name: "OK",
-			mock: func(args args) {
+			mock: func(f *model.TransactionFilter) {
 				// Mocking the transaction retrieval
-				mock.ExpectQuery("^SELECT \\* FROM transactions WHERE user_id = \\$1$").
-					WithArgs(args.userID).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "operation"}).
-						AddRow(1, args.userID, decimal.NewFromFloat(10), "Top-up by some by 10.00RUB"))
+				mock.ExpectQuery("WHERE t.user_id = \\$1\\s+ORDER BY t.id ASC\\s+LIMIT \\$2$").
+					WithArgs(f.UserID, f.Limit).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "kind", "source"}).
+						AddRow(1, f.UserID, decimal.NewFromFloat(10), model.TransactionTopUp, model.SourceBankCard))
 			},
-			input: args{
-				userID: 1,
+			input: &model.TransactionFilter{
+				UserID: 1,
+				SortBy: model.SortByID,
+				Order:  model.OrderAsc,
+				Limit:  10,
 			},
 			want: []model.Transaction{
 				{
-					ID:        1,
-					UserID:    1,
-					Amount:    decimal.NewFromFloat(10),
-					Operation: "Top-up by some by 10.00RUB",
+					ID:     1,
+					UserID: 1,
+					Amount: decimal.NewFromFloat(10),
+					Kind:   model.TransactionTopUp,
+					Source: model.SourceBankCard,
 				},
 			},
 			wantErr: false,
 		},
+		{
+			name: "Filtered next page by amount",
+			mock: func(f *model.TransactionFilter) {
+				mock.ExpectQuery("WHERE t.user_id = \\$1 AND t.kind = \\$2 "+
+					"AND ABS\\(t.amount\\) >= \\$3 AND \\(t.amount, t.id\\) < \\(\\$4, \\$5\\)\\s+"+
+					"ORDER BY t.amount DESC, t.id DESC\\s+LIMIT \\$6$").
+					WithArgs(f.UserID, model.TransactionTransferOut, minAmount, f.After.Amount, f.After.ID, f.Limit).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "kind", "source"}).
+						AddRow(2, f.UserID, decimal.NewFromFloat(-5), model.TransactionTransferOut, model.SourceTransfer))
+			},
+			input: &model.TransactionFilter{
+				UserID:    1,
+				Type:      model.TransactionTransferOut,
+				MinAmount: &minAmount,
+				SortBy:    model.SortByAmount,
+				Order:     model.OrderDesc,
+				After:     &model.Transaction{ID: 3, Amount: decimal.NewFromFloat(-5)},
+				Limit:     10,
+			},
+			want: []model.Transaction{
+				{
+					ID:     2,
+					UserID: 1,
+					Amount: decimal.NewFromFloat(-5),
+					Kind:   model.TransactionTransferOut,
+					Source: model.SourceTransfer,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "By request ID",
+			mock: func(f *model.TransactionFilter) {
+				mock.ExpectQuery("WHERE t.user_id = \\$1 AND t.request_id = \\$2\\s+ORDER BY t.id ASC\\s+LIMIT \\$3$").
+					WithArgs(f.UserID, f.RequestID, f.Limit).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "kind", "source", "request_id"}).
+						AddRow(4, f.UserID, decimal.NewFromFloat(-7), model.TransactionPurchase, model.SourcePurchase,
+							f.RequestID))
+			},
+			input: &model.TransactionFilter{
+				UserID:    1,
+				RequestID: "billing-42",
+				SortBy:    model.SortByID,
+				Order:     model.OrderAsc,
+				Limit:     10,
+			},
+			want: []model.Transaction{
+				{
+					ID:     4,
+					UserID: 1,
+					Amount: decimal.NewFromFloat(-7),
+					Kind:   model.TransactionPurchase,
+					Source: model.SourcePurchase,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "By request ID of all users",
+			mock: func(f *model.TransactionFilter) {
+				mock.ExpectQuery("WHERE t.request_id = \\$1\\s+ORDER BY t.id ASC\\s+LIMIT \\$2$").
+					WithArgs(f.RequestID, f.Limit).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "amount", "kind", "source", "request_id"}).
+						AddRow(4, 1, decimal.NewFromFloat(-7), model.TransactionTransferOut, model.SourceTransfer,
+							f.RequestID).
+						AddRow(5, 2, decimal.NewFromFloat(7), model.TransactionTransferIn, model.SourceTransfer,
+							f.RequestID))
+			},
+			input: &model.TransactionFilter{
+				RequestID: "billing-42",
+				SortBy:    model.SortByID,
+				Order:     model.OrderAsc,
+				Limit:     10,
+			},
+			want: []model.Transaction{
+				{
+					ID:     4,
+					UserID: 1,
+					Amount: decimal.NewFromFloat(-7),
+					Kind:   model.TransactionTransferOut,
+					Source: model.SourceTransfer,
+				},
+				{
+					ID:     5,
+					UserID: 2,
+					Amount: decimal.NewFromFloat(7),
+					Kind:   model.TransactionTransferIn,
+					Source: model.SourceTransfer,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Unknown sort",
+			mock: func(_ *model.TransactionFilter) {},
+			input: &model.TransactionFilter{
+				UserID: 1,
+				SortBy: "operation",
+				Limit:  10,
+			},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mock(tt.input)
-			got, err := s.GetTransactions(context.Background(), tt.input.userID)
+			got, err := s.ListTransactions(context.Background(), tt.input)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Storage.GetTransactions() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Storage.ListTransactions() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if err == nil {
 				if len(got) != len(tt.want) {
-					t.Errorf("Storage.GetTransactions() = %v, want %v", got, tt.want)
+					t.Errorf("Storage.ListTransactions() = %v, want %v", got, tt.want)
 				}
 				for i := range got {
 					switch {
 					case got[i].ID != tt.want[i].ID:
-						t.Errorf("Storage.GetTransactions() ID = %v, want %v", got[i].ID, tt.want[i].ID)
+						t.Errorf("Storage.ListTransactions() ID = %v, want %v", got[i].ID, tt.want[i].ID)
 					case got[i].UserID != tt.want[i].UserID:
-						t.Errorf("Storage.GetTransactions() UserID = %v, want %v", got[i].UserID, tt.want[i].UserID)
+						t.Errorf("Storage.ListTransactions() UserID = %v, want %v", got[i].UserID, tt.want[i].UserID)
 					case got[i].Amount.Cmp(tt.want[i].Amount) != 0:
-						t.Errorf("Storage.GetTransactions() Amount = %v, want %v", got[i].Amount, tt.want[i].Amount)
-					case got[i].Operation != tt.want[i].Operation:
-						t.Errorf("Storage.GetTransactions() Operation = %v, want %v", got[i].Operation, tt.want[i].Operation)
+						t.Errorf("Storage.ListTransactions() Amount = %v, want %v", got[i].Amount, tt.want[i].Amount)
+					case got[i].Kind != tt.want[i].Kind:
+						t.Errorf("Storage.ListTransactions() Kind = %v, want %v", got[i].Kind, tt.want[i].Kind)
+					case got[i].Source != tt.want[i].Source:
+						t.Errorf("Storage.ListTransactions() Source = %v, want %v", got[i].Source, tt.want[i].Source)
 					}
 				}
 			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
 		})
 	}
 }
+
+func TestStorage_Transfer(t *testing.T) {
+	s := New(testDSN)
+	if s == nil {
+		t.Error("New() should not return nil")
+	}
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	type args struct {
+		fromID int64
+		toID   int64
+		amount decimal.Decimal
+	}
+
+	type mockBehavior func(args args)
+
+	transferID, now := int64(7), time.Now()
+
+	tests := []struct {
+		name    string
+		mock    mockBehavior
+		input   args
+		want    *model.Transfer
+		wantErr bool
+	}{
+		{
+			name: "OK",
+			mock: func(args args) {
+				mock.ExpectBegin()
+				expectAccount(mock, args.fromID, model.AccountActive)
+				expectAccount(mock, args.toID, model.AccountActive)
+				mock.ExpectExec("INSERT INTO balances").
+					WithArgs(args.toID, model.CurrencyRUB).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("FOR UPDATE").
+					WithArgs(args.fromID, model.CurrencyRUB, args.toID, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, args.fromID, model.CurrencyRUB, decimal.NewFromFloat(10), decimal.Zero).
+						AddRow(2, args.toID, model.CurrencyRUB, decimal.NewFromFloat(0), decimal.Zero))
+				mock.ExpectQuery("INSERT INTO transfers").
+					WithArgs(args.fromID, args.toID, args.amount, model.CurrencyRUB, args.amount, model.CurrencyRUB,
+						nil, nil, "", model.TransferCompleted).
+					WillReturnRows(sqlmock.NewRows(transferRows).AddRow(transferID, args.fromID, args.toID, args.amount,
+						model.CurrencyRUB, args.amount, model.CurrencyRUB, nil, nil, "", model.TransferCompleted, now, now))
+				expectWallet(mock, args.fromID)
+				expectWallet(mock, args.toID)
+				mock.ExpectQuery("INSERT INTO journal_entries").
+					WithArgs("transfer").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectExec("INSERT INTO postings").
+					WithArgs(int64(1), args.fromID+100, args.amount.Neg(), args.toID+100, args.amount).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec("UPDATE balances").
+					WithArgs(args.fromID, model.CurrencyRUB, args.amount.Neg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO transactions").
+					WithArgs(int64(1), args.fromID, args.amount.Neg(), model.CurrencyRUB,
+						model.TransactionTransferOut, model.SourceTransfer, &args.toID, &transferID, nil, nil, "", nil, nil, nil, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("UPDATE balances").
+					WithArgs(args.toID, model.CurrencyRUB, args.amount).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(2, args.toID, model.CurrencyRUB, args.amount, decimal.Zero))
+				mock.ExpectExec("INSERT INTO transactions").
+					WithArgs(int64(1), args.toID, args.amount, model.CurrencyRUB, model.TransactionTransferIn, model.SourceTransfer,
+						&args.fromID, &transferID, nil, nil, "", nil, nil, nil, "").
+					WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectCommit()
+			},
+			input: args{
+				fromID: 1,
+				toID:   2,
+				amount: decimal.NewFromFloat(5),
+			},
+			want: &model.Transfer{
+				ID:     transferID,
+				FromID: 1,
+				Amount: decimal.NewFromFloat(5),
+				Status: model.TransferCompleted,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Insufficient funds",
+			mock: func(args args) {
+				mock.ExpectBegin()
+				expectAccount(mock, args.fromID, model.AccountActive)
+				expectAccount(mock, args.toID, model.AccountActive)
+				mock.ExpectExec("INSERT INTO balances").
+					WithArgs(args.toID, model.CurrencyRUB).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("FOR UPDATE").
+					WithArgs(args.fromID, model.CurrencyRUB, args.toID, model.CurrencyRUB).
+					WillReturnRows(sqlmock.NewRows(balanceRows).
+						AddRow(1, args.fromID, model.CurrencyRUB, decimal.NewFromFloat(1), decimal.Zero).
+						AddRow(2, args.toID, model.CurrencyRUB, decimal.NewFromFloat(0), decimal.Zero))
+				mock.ExpectRollback()
+			},
+			input: args{
+				fromID: 1,
+				toID:   2,
+				amount: decimal.NewFromFloat(5),
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Sender account is debit-frozen",
+			mock: func(args args) {
+				mock.ExpectBegin()
+				expectAccount(mock, args.fromID, model.AccountDebitFrozen)
+				mock.ExpectRollback()
+			},
+			input: args{
+				fromID: 1,
+				toID:   2,
+				amount: decimal.NewFromFloat(5),
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Recipient does not exist",
+			mock: func(args args) {
+				mock.ExpectBegin()
+				expectAccount(mock, args.fromID, model.AccountActive)
+				expectAccount(mock, args.toID, "")
+				mock.ExpectRollback()
+			},
+			input: args{
+				fromID: 1,
+				toID:   42,
+				amount: decimal.NewFromFloat(5),
+			},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock(tt.input)
+			got, err := s.Transfer(context.Background(), &model.Transfer{
+				FromID:     tt.input.fromID,
+				ToID:       tt.input.toID,
+				Amount:     tt.input.amount,
+				Currency:   model.CurrencyRUB,
+				ToAmount:   tt.input.amount,
+				ToCurrency: model.CurrencyRUB,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.Transfer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if got.ID != tt.want.ID || got.FromID != tt.want.FromID || got.Status != tt.want.Status {
+					t.Errorf("Storage.Transfer() = %d from %d %s, want %d from %d %s",
+						got.ID, got.FromID, got.Status, tt.want.ID, tt.want.FromID, tt.want.Status)
+				}
+				if got.Amount.Cmp(tt.want.Amount) != 0 {
+					t.Errorf("Storage.Transfer() Amount = %v, want %v", got.Amount, tt.want.Amount)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestStorage_TransferExchange(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	userID, transferID := int64(1), int64(7)
+	amount, toAmount := decimal.NewFromFloat(900), decimal.NewFromFloat(9.9)
+	rate, spread := decimal.NewFromFloat(0.011), decimal.NewFromFloat(0.01)
+
+	mock.ExpectBegin()
+	expectAccount(mock, userID, model.AccountActive)
+	expectAccount(mock, userID, model.AccountActive)
+	mock.ExpectExec("INSERT INTO balances").
+		WithArgs(userID, "USD").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FOR UPDATE").
+		WithArgs(userID, model.CurrencyRUB, userID, "USD").
+		WillReturnRows(sqlmock.NewRows(balanceRows).
+			AddRow(1, userID, model.CurrencyRUB, decimal.NewFromFloat(1000), decimal.Zero).
+			AddRow(2, userID, "USD", decimal.Zero, decimal.Zero))
+	mock.ExpectQuery("INSERT INTO transfers").
+		WithArgs(userID, userID, amount, model.CurrencyRUB, toAmount, "USD", &rate, &spread, "",
+			model.TransferCompleted).
+		WillReturnRows(sqlmock.NewRows(transferRows).AddRow(transferID, userID, userID, amount, model.CurrencyRUB,
+			toAmount, "USD", &rate, &spread, "", model.TransferCompleted, time.Now(), time.Now()))
+	expectWallet(mock, userID)
+	mock.ExpectQuery("INSERT INTO accounts").
+		WithArgs(userID, "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(201))
+	mock.ExpectQuery("INSERT INTO accounts").
+		WithArgs(accountExchange, model.CurrencyRUB).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectQuery("INSERT INTO accounts").
+		WithArgs(accountExchange, "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs("transfer").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	// the exchange accounts balance the entry in each currency
+	mock.ExpectExec("INSERT INTO postings").
+		WithArgs(int64(1), userID+100, amount.Neg(), int64(201), toAmount, int64(10), amount, int64(11), toAmount.Neg()).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE balances").
+		WithArgs(userID, model.CurrencyRUB, amount.Neg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs(int64(1), userID, amount.Neg(), model.CurrencyRUB,
+			model.TransactionTransferOut, model.SourceTransfer, &userID, &transferID, nil, nil, "", nil, nil, nil, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE balances").
+		WithArgs(userID, "USD", toAmount).
+		WillReturnRows(sqlmock.NewRows(balanceRows).AddRow(2, userID, "USD", toAmount, decimal.Zero))
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs(int64(1), userID, toAmount, "USD", model.TransactionTransferIn, model.SourceTransfer,
+			&userID, &transferID, nil, nil, "", nil, nil, nil, "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	got, err := s.Transfer(context.Background(), &model.Transfer{
+		FromID:     userID,
+		ToID:       userID,
+		Amount:     amount,
+		Currency:   model.CurrencyRUB,
+		ToAmount:   toAmount,
+		ToCurrency: "USD",
+		Rate:       &rate,
+		Spread:     &spread,
+	})
+	if err != nil {
+		t.Fatalf("Storage.Transfer() error = %v", err)
+	}
+	if got.ID != transferID || got.ToCurrency != "USD" || !got.ToAmount.Equal(toAmount) {
+		t.Errorf("Storage.Transfer() = %d %v %v, want %d %v USD", got.ID, got.ToAmount, got.ToCurrency, transferID, toAmount)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// expectWallet mocks the lookup of the ruble wallet account, wallet of the user N has ID N+100.
+func expectWallet(mock sqlmock.Sqlmock, userID int64) {
+	mock.ExpectQuery("INSERT INTO accounts").
+		WithArgs(userID, model.CurrencyRUB).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID + 100))
+}
+
+// expectAccount mocks the check of the account of the user, an empty status means there is no such user.
+func expectAccount(mock sqlmock.Sqlmock, userID int64, status string) {
+	rows := sqlmock.NewRows([]string{"status"})
+	if status != "" {
+		rows.AddRow(status)
+	}
+	mock.ExpectQuery("SELECT status FROM users").
+		WithArgs(userID).
+		WillReturnRows(rows)
+}
+
+// expectWalletEntry mocks a journal entry between the wallet of the user and a system account.
+func expectWalletEntry(mock sqlmock.Sqlmock, userID int64) {
+	expectWallet(mock, userID)
+	mock.ExpectQuery("SELECT id FROM accounts WHERE code = \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO postings").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+}
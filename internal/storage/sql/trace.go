@@ -0,0 +1,34 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	"github.com/cronnoss/avitotech/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("github.com/cronnoss/avitotech/internal/storage/sql")
+
+// startSpan starts the span of the statement as a child of the span of ctx.
+func startSpan(ctx context.Context, stmt, query string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{semconv.DBSystemPostgreSQL, semconv.DBOperationName(stmt)}
+	if query != "" {
+		// the arguments are not in the text of the query, so it holds no data of the users
+		attrs = append(attrs, semconv.DBQueryText(strings.TrimSpace(query)))
+	}
+	return tracer.Start(ctx, strings.ToUpper(stmt),
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span of the statement and marks its failure like observe counts it.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, driver.ErrSkip) {
+		tracing.Fail(span, err)
+	}
+	span.End()
+}
@@ -0,0 +1,61 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestConnectorSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	mockDB, mock, err := sqlmock.NewWithDSN("sqlmock_trace")
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	db := sql.OpenDB(&connector{dsn: "sqlmock_trace", driver: mockDB.Driver()})
+	defer db.Close()
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "Avitotech.Debit")
+	defer parent.End()
+
+	mock.ExpectQuery("INSERT INTO journal_entries").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("DELETE FROM reservations").WillReturnError(errors.New("deadlock detected"))
+
+	var id int
+	require.NoError(t, db.QueryRowContext(ctx, "INSERT INTO journal_entries DEFAULT VALUES RETURNING id").Scan(&id))
+	_, err = db.ExecContext(ctx, "DELETE FROM reservations")
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the commit is traced in the context of the beginning
+	require.NoError(t, (&measuredTx{tx: stubTx{}, ctx: ctx}).Commit())
+
+	// every statement is a child span of the caller, the failed one is marked
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+		require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID(), span.Name())
+		wantCode := codes.Unset
+		if span.Name() == "DELETE" {
+			wantCode = codes.Error
+		}
+		require.Equal(t, wantCode, span.Status().Code, span.Name())
+	}
+	require.Equal(t, []string{"INSERT", "DELETE", "COMMIT"}, names)
+}
+
+type stubTx struct{}
+
+func (stubTx) Commit() error   { return nil }
+func (stubTx) Rollback() error { return nil }
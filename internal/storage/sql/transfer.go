@@ -0,0 +1,62 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const transferColumns = `id, from_user_id, to_user_id, amount, currency, to_amount, to_currency, rate, spread,
+	comment, status, created_at, completed_at`
+
+// createTransfer records the transfer made within tx, its legs refer to the ID of the returned transfer.
+func createTransfer(ctx context.Context, tx *sqlx.Tx, t *model.Transfer) (*model.Transfer, error) {
+	query := `
+		INSERT INTO transfers (from_user_id, to_user_id, amount, currency, to_amount, to_currency, rate, spread,
+		                       comment, status, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP)
+		RETURNING ` + transferColumns
+	var ans model.Transfer
+	err := tx.GetContext(ctx, &ans, query, t.FromID, t.ToID, t.Amount, t.Currency, t.ToAmount, t.ToCurrency,
+		t.Rate, t.Spread, t.Comment, model.TransferCompleted)
+	if err != nil {
+		return nil, dbError("create transfer", err)
+	}
+	return &ans, nil
+}
+
+func (s *Storage) GetTransfer(ctx context.Context, id int64) (*model.Transfer, error) {
+	var ans model.Transfer
+	err := s.db.GetContext(ctx, &ans, "SELECT "+transferColumns+" FROM transfers WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "transfer with ID %d does not exist", id)
+	}
+	if err != nil {
+		return nil, dbError("get transfer", err)
+	}
+	return &ans, nil
+}
+
+// ListTransfers returns transfers sent or received by the user from newest to oldest.
+func (s *Storage) ListTransfers(ctx context.Context, f *model.TransferFilter) ([]model.Transfer, error) {
+	args := []interface{}{f.UserID, f.Limit}
+	query := `
+		SELECT ` + transferColumns + ` FROM transfers
+		WHERE (from_user_id = $1 OR to_user_id = $1)`
+	if f.AfterID > 0 {
+		args = append(args, f.AfterID)
+		query += " AND id < $3"
+	}
+	query += `
+		ORDER BY id DESC
+		LIMIT $2`
+
+	var ans []model.Transfer
+	if err := s.db.SelectContext(ctx, &ans, query, args...); err != nil {
+		return nil, dbError("get transfers", err)
+	}
+	return ans, nil
+}
@@ -0,0 +1,97 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/shopspring/decimal"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var transferRows = []string{
+	"id", "from_user_id", "to_user_id", "amount", "currency", "to_amount", "to_currency", "rate", "spread",
+	"comment", "status", "created_at", "completed_at",
+}
+
+func TestStorage_ListTransfers(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	now := time.Now()
+
+	type mockBehavior func(f *model.TransferFilter)
+
+	tests := []struct {
+		name    string
+		mock    mockBehavior
+		input   *model.TransferFilter
+		want    []int64
+		wantErr bool
+	}{
+		{
+			name: "First page",
+			mock: func(f *model.TransferFilter) {
+				mock.ExpectQuery("WHERE \\(from_user_id = \\$1 OR to_user_id = \\$1\\)\\s+ORDER BY id DESC\\s+LIMIT \\$2").
+					WithArgs(f.UserID, f.Limit).
+					WillReturnRows(sqlmock.NewRows(transferRows).
+						AddRow(9, 1, 2, decimal.NewFromFloat(5), model.CurrencyRUB, decimal.NewFromFloat(0.05), "USD",
+							decimal.NewFromFloat(0.011), decimal.NewFromFloat(0.01), "", model.TransferCompleted, now, now).
+						AddRow(4, 3, 1, decimal.NewFromFloat(7), model.CurrencyRUB, decimal.NewFromFloat(7), model.CurrencyRUB,
+							nil, nil, "rent", model.TransferReversed, now, now))
+			},
+			input: &model.TransferFilter{
+				UserID: 1,
+				Limit:  2,
+			},
+			want:    []int64{9, 4},
+			wantErr: false,
+		},
+		{
+			name: "Next page",
+			mock: func(f *model.TransferFilter) {
+				mock.ExpectQuery("AND id < \\$3\\s+ORDER BY id DESC").
+					WithArgs(f.UserID, f.Limit, f.AfterID).
+					WillReturnRows(sqlmock.NewRows(transferRows).
+						AddRow(2, 1, 3, decimal.NewFromFloat(1), model.CurrencyRUB, decimal.NewFromFloat(1), model.CurrencyRUB,
+							nil, nil, "", model.TransferCompleted, now, now))
+			},
+			input: &model.TransferFilter{
+				UserID:  1,
+				AfterID: 4,
+				Limit:   2,
+			},
+			want:    []int64{2},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock(tt.input)
+			got, err := s.ListTransfers(context.Background(), tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.ListTransfers() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Storage.ListTransfers() got %d transfers, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i] {
+					t.Errorf("Storage.ListTransfers() ID = %v, want %v", got[i].ID, tt.want[i])
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
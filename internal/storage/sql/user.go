@@ -0,0 +1,167 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+)
+
+const userColumns = `id, name, username, email, password_hash, status, created_at, updated_at, deleted_at`
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *Storage) CreateUser(ctx context.Context, u *model.User) (*model.User, error) {
+	var ans model.User
+	query := `
+		INSERT INTO users (name, username, email, password_hash)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		RETURNING ` + userColumns
+	err := s.db.GetContext(ctx, &ans, query, u.Name, u.Username, u.Email, u.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrConflict, "user with this username or email already exists")
+	}
+	if err != nil {
+		return nil, dbError("create user", err)
+	}
+	return &ans, nil
+}
+
+// GetUser returns the user unless it is deleted.
+func (s *Storage) GetUser(ctx context.Context, id int64) (*model.User, error) {
+	var ans model.User
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1 AND deleted_at IS NULL"
+	err := s.db.GetContext(ctx, &ans, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "user with ID %d does not exist", id)
+	}
+	if err != nil {
+		return nil, dbError("get user", err)
+	}
+	return &ans, nil
+}
+
+// UpdateUser changes the profile of the user, the username and the email must stay unique.
+func (s *Storage) UpdateUser(ctx context.Context, id int64, u *model.UserUpdate) (*model.User, error) {
+	var ans model.User
+	query := `
+		UPDATE users
+		SET name       = COALESCE($2, name),
+		    username   = COALESCE($3, username),
+		    email      = COALESCE($4, email),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING ` + userColumns
+	err := s.db.GetContext(ctx, &ans, query, id, u.Name, u.Username, u.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "user with ID %d does not exist", id)
+	}
+	if isUniqueViolation(err) {
+		return nil, model.Errorf(model.ErrConflict, "user with this username or email already exists")
+	}
+	if err != nil {
+		return nil, dbError("update user", err)
+	}
+	return &ans, nil
+}
+
+// SearchUsers returns the users matching the filter in the order of their IDs.
+func (s *Storage) SearchUsers(ctx context.Context, f *model.UserFilter) ([]model.User, error) {
+	query := `
+		SELECT ` + userColumns + ` FROM users
+		WHERE deleted_at IS NULL AND username ILIKE $1 AND email ILIKE $2
+		ORDER BY id
+		LIMIT $3`
+	ans := []model.User{}
+	err := s.db.SelectContext(ctx, &ans, query,
+		likeEscaper.Replace(f.Username)+"%", likeEscaper.Replace(f.Email)+"%", f.Limit)
+	if err != nil {
+		return nil, dbError("search users", err)
+	}
+	return ans, nil
+}
+
+// DeleteUser marks the user deleted and revokes its refresh tokens. The balances and the history
+// of the user are kept, but it can't move money any more.
+func (s *Storage) DeleteUser(ctx context.Context, id int64) (*model.User, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, dbError("begin transaction", err)
+	}
+	defer tx.Rollback() // no-op after a successful commit
+
+	var ans model.User
+	query := `
+		UPDATE users
+		SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING ` + userColumns
+	err = tx.GetContext(ctx, &ans, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "user with ID %d does not exist", id)
+	}
+	if err != nil {
+		return nil, dbError("delete user", err)
+	}
+
+	query = `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err = tx.ExecContext(ctx, query, id); err != nil {
+		return nil, dbError("revoke refresh tokens", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError("commit user deletion", err)
+	}
+	return &ans, nil
+}
+
+// FindUser returns the user by the username or the email, deleted users are not found.
+func (s *Storage) FindUser(ctx context.Context, login string) (*model.User, error) {
+	var ans model.User
+	query := "SELECT " + userColumns + " FROM users WHERE (username = $1 OR email = $1) AND deleted_at IS NULL"
+	err := s.db.GetContext(ctx, &ans, query, login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.Errorf(model.ErrNotFound, "user %q does not exist", login)
+	}
+	if err != nil {
+		return nil, dbError("get user", err)
+	}
+	return &ans, nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)`
+	if _, err := s.db.ExecContext(ctx, query, userID, tokenHash, expiresAt); err != nil {
+		return dbError("save refresh token", err)
+	}
+	return nil
+}
+
+// UseRefreshToken revokes the refresh token and returns its user. Each token is used once,
+// revoked and expired tokens are rejected.
+func (s *Storage) UseRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id`
+	var userID int64
+	err := s.db.QueryRowxContext(ctx, query, tokenHash).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, model.Errorf(model.ErrUnauthorized, "refresh token is expired or already used")
+	}
+	if err != nil {
+		return 0, dbError("use refresh token", err)
+	}
+	return userID, nil
+}
@@ -0,0 +1,194 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cronnoss/avitotech/internal/model"
+	"github.com/jackc/pgx"
+	sqlmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var userRows = []string{
+	"id", "name", "username", "email", "password_hash", "status", "created_at", "updated_at", "deleted_at",
+}
+
+func TestStorage_CreateUser(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	u := &model.User{Name: "John Doe", Username: "johndoe", Email: "john@example.com", PasswordHash: "$2a$10$hash"}
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs(u.Name, u.Username, u.Email, u.PasswordHash).
+		WillReturnRows(sqlmock.NewRows(userRows).
+			AddRow(4, u.Name, u.Username, u.Email, u.PasswordHash, model.AccountActive, time.Now(), time.Now(), nil))
+	// the username or the email is taken
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs(u.Name, u.Username, u.Email, u.PasswordHash).
+		WillReturnRows(sqlmock.NewRows(userRows))
+
+	got, err := s.CreateUser(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Storage.CreateUser() error = %v", err)
+	}
+	if got.ID != 4 {
+		t.Errorf("Storage.CreateUser() ID = %v, want 4", got.ID)
+	}
+	if _, err = s.CreateUser(context.Background(), u); !errors.Is(err, model.ErrConflict) {
+		t.Errorf("Storage.CreateUser() error = %v, want %v", err, model.ErrConflict)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_UpdateUser(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	name, email := "Jane Doe", "jane@example.com"
+	update := &model.UserUpdate{Name: &name, Email: &email}
+	mock.ExpectQuery("UPDATE users").
+		WithArgs(int64(4), update.Name, nil, update.Email).
+		WillReturnRows(sqlmock.NewRows(userRows).
+			AddRow(4, name, "johndoe", email, "$2a$10$hash", model.AccountActive, time.Now(), time.Now(), nil))
+	// the email is taken by another user
+	mock.ExpectQuery("UPDATE users").
+		WithArgs(int64(4), update.Name, nil, update.Email).
+		WillReturnError(pgx.PgError{Code: uniqueViolation})
+	// the user is deleted
+	mock.ExpectQuery("UPDATE users").
+		WithArgs(int64(4), update.Name, nil, update.Email).
+		WillReturnRows(sqlmock.NewRows(userRows))
+
+	got, err := s.UpdateUser(context.Background(), 4, update)
+	if err != nil {
+		t.Fatalf("Storage.UpdateUser() error = %v", err)
+	}
+	if got.Name != name || got.Username != "johndoe" || got.Email != email {
+		t.Errorf("Storage.UpdateUser() = %+v, want the new name and email", got)
+	}
+	if _, err = s.UpdateUser(context.Background(), 4, update); !errors.Is(err, model.ErrConflict) {
+		t.Errorf("Storage.UpdateUser() error = %v, want %v", err, model.ErrConflict)
+	}
+	if _, err = s.UpdateUser(context.Background(), 4, update); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("Storage.UpdateUser() error = %v, want %v", err, model.ErrNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_SearchUsers(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	// wildcards of the filter are matched literally
+	mock.ExpectQuery("SELECT (.+) FROM users (.+) deleted_at IS NULL").
+		WithArgs(`john\_%`, "%", 20).
+		WillReturnRows(sqlmock.NewRows(userRows).
+			AddRow(4, "John Doe", "john_doe", "john@example.com", "$2a$10$hash", model.AccountActive,
+				time.Now(), time.Now(), nil))
+
+	got, err := s.SearchUsers(context.Background(), &model.UserFilter{Username: "john_", Limit: 20})
+	if err != nil {
+		t.Fatalf("Storage.SearchUsers() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 4 {
+		t.Errorf("Storage.SearchUsers() = %+v, want user 4", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_DeleteUser(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	deletedAt := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET deleted_at").
+		WithArgs(int64(4)).
+		WillReturnRows(sqlmock.NewRows(userRows).
+			AddRow(4, "John Doe", "johndoe", "john@example.com", "$2a$10$hash", model.AccountActive,
+				time.Now(), deletedAt, deletedAt))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at").
+		WithArgs(int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	// the user is already deleted
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET deleted_at").
+		WithArgs(int64(4)).
+		WillReturnRows(sqlmock.NewRows(userRows))
+	mock.ExpectRollback()
+
+	got, err := s.DeleteUser(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("Storage.DeleteUser() error = %v", err)
+	}
+	if got.DeletedAt == nil {
+		t.Errorf("Storage.DeleteUser() DeletedAt = nil, want the time of deletion")
+	}
+	if _, err = s.DeleteUser(context.Background(), 4); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("Storage.DeleteUser() error = %v, want %v", err, model.ErrNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStorage_UseRefreshToken(t *testing.T) {
+	s := New(testDSN)
+	db, mock, err := sqlmock.Newx()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s.db = db
+	defer db.Close()
+
+	mock.ExpectQuery("UPDATE refresh_tokens").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(4))
+	// the token is revoked by the first use
+	mock.ExpectQuery("UPDATE refresh_tokens").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	userID, err := s.UseRefreshToken(context.Background(), "hash")
+	if err != nil {
+		t.Fatalf("Storage.UseRefreshToken() error = %v", err)
+	}
+	if userID != 4 {
+		t.Errorf("Storage.UseRefreshToken() = %v, want 4", userID)
+	}
+	if _, err = s.UseRefreshToken(context.Background(), "hash"); !errors.Is(err, model.ErrUnauthorized) {
+		t.Errorf("Storage.UseRefreshToken() error = %v, want %v", err, model.ErrUnauthorized)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/cronnoss/avitotech/internal/model"
 	sqlstorage "github.com/cronnoss/avitotech/internal/storage/sql"
@@ -19,11 +20,45 @@ type Storage interface {
 	Connect(context.Context) error
 	Close(context.Context) error
 	GetBalance(context.Context, *model.Balance) (*model.Balance, error)
-	TopUp(context.Context, int64, decimal.Decimal, string) (*model.Balance, error)
-	Debit(context.Context, int64, decimal.Decimal, string) (*model.Balance, error)
-	GetTransactions(context.Context, int64) ([]model.Transaction, error)
-	GetTransactionsByDate(context.Context, int64) ([]model.Transaction, error)
-	GetTransactionsByAmount(context.Context, int64) ([]model.Transaction, error)
+	ListWallets(context.Context, int64) ([]model.Balance, error)
+	TopUp(context.Context, *model.Transaction) (*model.Balance, error)
+	Debit(context.Context, *model.Transaction) (*model.Balance, error)
+	Transfer(context.Context, *model.Transfer) (*model.Transfer, error)
+	GetTransfer(context.Context, int64) (*model.Transfer, error)
+	ListTransfers(context.Context, *model.TransferFilter) ([]model.Transfer, error)
+	ListTransactions(context.Context, *model.TransactionFilter) ([]model.Transaction, error)
+	GetTransaction(context.Context, int64) (*model.Transaction, error)
+	Refund(context.Context, *model.Refund) (*model.Transaction, error)
+	AcquireIdempotencyKey(context.Context, string, string, time.Duration) (*model.IdempotencyKey, error)
+	SaveIdempotencyResponse(context.Context, string, int, []byte) error
+	ReleaseIdempotencyKey(context.Context, string) error
+	DeleteExpiredIdempotencyKeys(context.Context) (int64, error)
+	Reserve(context.Context, *model.Reservation, time.Duration) (*model.Reservation, error)
+	CaptureReservation(context.Context, *model.Reservation, decimal.Decimal) (*model.Balance, error)
+	ReleaseReservation(context.Context, *model.Reservation) (*model.Balance, error)
+	ReleaseExpiredReservations(context.Context) (int64, error)
+	SaveExchangeRates(context.Context, []model.ExchangeRate) ([]model.ExchangeRate, error)
+	GetExchangeRate(context.Context, string, time.Time) (*model.ExchangeRate, error)
+	ListExchangeRates(context.Context, time.Time) ([]model.ExchangeRate, error)
+	CreateQuote(context.Context, *model.Quote, time.Duration) (*model.Quote, error)
+	CreateAPIKey(context.Context, *model.APIKey) (*model.APIKey, error)
+	GetAPIKey(context.Context, int64) (*model.APIKey, error)
+	FindAPIKey(context.Context, string) (*model.APIKey, error)
+	UseNonce(context.Context, int64, string, time.Duration) error
+	DeleteExpiredNonces(context.Context) (int64, error)
+	CreateUser(context.Context, *model.User) (*model.User, error)
+	GetUser(context.Context, int64) (*model.User, error)
+	UpdateUser(context.Context, int64, *model.UserUpdate) (*model.User, error)
+	SearchUsers(context.Context, *model.UserFilter) ([]model.User, error)
+	DeleteUser(context.Context, int64) (*model.User, error)
+	FindUser(context.Context, string) (*model.User, error)
+	SetAccountStatus(context.Context, *model.AccountStatusChange) (*model.User, error)
+	ListAccountStatusChanges(context.Context, int64) ([]model.AccountStatusChange, error)
+	CloseAccount(context.Context, *model.AccountClosure) (*model.User, error)
+	RecordAudit(context.Context, *model.AuditRecord) error
+	ListAudit(context.Context, *model.AuditFilter) ([]model.AuditRecord, error)
+	SaveRefreshToken(context.Context, int64, string, time.Time) error
+	UseRefreshToken(context.Context, string) (int64, error)
 }
 
 func NewStorage(conf Conf) Storage {
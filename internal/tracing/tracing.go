@@ -0,0 +1,133 @@
+// Package tracing sets up OpenTelemetry tracing of the service, the spans follow
+// the W3C trace context of the incoming requests.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "avitotech"
+
+// propagator reads and writes the traceparent header of W3C trace context.
+var propagator = propagation.TraceContext{}
+
+type Conf struct {
+	// Exporter is "otlp" for a collector, "stdout" or "file" for local use, no spans are exported without it.
+	Exporter string `toml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector like http://localhost:4318,
+	// OTEL_EXPORTER_OTLP_ENDPOINT is used without it.
+	Endpoint string `toml:"endpoint"`
+	// File is where the "file" exporter appends the spans as JSON.
+	File string `toml:"file"`
+	// SampleRatio is the part of new traces that are recorded, all of them without it.
+	// Traces continued from a caller follow its decision.
+	SampleRatio float64 `toml:"sample_ratio"`
+}
+
+// Tracer returns the tracer of the package of the service, it may be taken before Setup.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Extract returns ctx with the span of the caller from the traceparent header, if there is one.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// Inject writes the traceparent header of the span of ctx to the outgoing request.
+func Inject(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Fail records the error on the span and marks the span failed.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Setup installs the tracer provider of the config and the W3C trace context propagator.
+// The returned function flushes the spans left and stops the exporter.
+func Setup(ctx context.Context, conf Conf) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	exporter, closer, err := newExporter(ctx, conf)
+	if err != nil || exporter == nil {
+		return func(context.Context) error { return nil }, err
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %w", err)
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if conf.SampleRatio > 0 && conf.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(conf.SampleRatio)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// newExporter returns the exporter of the config and the file it writes to, if any.
+func newExporter(ctx context.Context, conf Conf) (sdktrace.SpanExporter, io.Closer, error) {
+	switch conf.Exporter {
+	case "":
+		return nil, nil, nil
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(conf.Endpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case "file":
+		f, err := os.OpenFile(conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open traces file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		return exporter, f, nil
+	}
+	return nil, nil, fmt.Errorf("unknown tracing exporter %q", conf.Exporter)
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup_File(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), Conf{Exporter: "file", File: filename})
+	require.NoError(t, err)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	_, span := Tracer("test").Start(context.Background(), "Avitotech.Transfer")
+	span.End()
+	// the spans are written on the shutdown at the latest
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"Name":"Avitotech.Transfer"`)
+	require.Contains(t, string(data), `"Value":"avitotech"`)
+}
+
+func TestSetup_Errors(t *testing.T) {
+	_, err := Setup(context.Background(), Conf{Exporter: "jaeger"})
+	require.Error(t, err)
+
+	missing := filepath.Join(t.TempDir(), "missing", "traces.json")
+	_, err = Setup(context.Background(), Conf{Exporter: "file", File: missing})
+	require.Error(t, err)
+
+	// no exporter means no tracing
+	shutdown, err := Setup(context.Background(), Conf{})
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func TestPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	in := http.Header{}
+	in.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracer.Start(Extract(context.Background(), in), "GET /api/v1/users/{id}/balance")
+	Fail(span, errors.New("no balance"))
+	span.End()
+
+	out := http.Header{}
+	Inject(ctx, out)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext().SpanID().String()+"-01",
+		out.Get("traceparent"))
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 1)
+	require.Equal(t, "00f067aa0ba902b7", ended[0].Parent().SpanID().String())
+	require.Equal(t, codes.Error, ended[0].Status().Code)
+	require.Equal(t, "no balance", ended[0].Status().Description)
+}
@@ -1,7 +1,6 @@
 -- +goose Up
 -- +goose Down
 -- +goose StatementBegin
-DROP TABLE transfer_results;
 DROP TABLE transactions;
 DROP TABLE balances;
 DROP TABLE users;
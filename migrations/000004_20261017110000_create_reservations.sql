@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE balances
+    ADD COLUMN reserved NUMERIC(15, 2) NOT NULL DEFAULT 0 CHECK (reserved >= 0);
+
+CREATE TABLE reservations
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INT REFERENCES users (id) ON DELETE CASCADE,
+    service_id   BIGINT         NOT NULL,
+    order_id     BIGINT         NOT NULL,
+    amount       NUMERIC(15, 2) NOT NULL CHECK (amount > 0),
+    captured     NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    status       VARCHAR(16)    NOT NULL DEFAULT 'held',
+    created_at   TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at   TIMESTAMP      NOT NULL,
+    completed_at TIMESTAMP,
+    UNIQUE (service_id, order_id)
+);
+
+CREATE INDEX reservations_held_expires_at_idx ON reservations (expires_at) WHERE status = 'held';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE reservations;
+ALTER TABLE balances
+    DROP COLUMN reserved;
+-- +goose StatementEnd
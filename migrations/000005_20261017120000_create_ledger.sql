@@ -0,0 +1,95 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE accounts
+(
+    id      SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users (id) ON DELETE CASCADE UNIQUE,
+    code    VARCHAR(64) UNIQUE,
+    kind    VARCHAR(16) NOT NULL,
+    CHECK ((kind = 'wallet' AND user_id IS NOT NULL AND code IS NULL) OR
+           (kind = 'system' AND user_id IS NULL AND code IS NOT NULL))
+);
+
+INSERT INTO accounts (code, kind)
+VALUES ('external_bank_card', 'system'),
+       ('purchases_revenue', 'system'),
+       ('reservations_hold', 'system'),
+       ('opening_balances', 'system');
+
+CREATE TABLE journal_entries
+(
+    id         SERIAL PRIMARY KEY,
+    kind       VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE postings
+(
+    id         SERIAL PRIMARY KEY,
+    entry_id   INT            NOT NULL REFERENCES journal_entries (id),
+    account_id INT            NOT NULL REFERENCES accounts (id),
+    amount     NUMERIC(15, 2) NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX postings_entry_id_idx ON postings (entry_id);
+CREATE INDEX postings_account_id_idx ON postings (account_id);
+
+-- postings of every journal entry must sum to zero, checked at commit
+CREATE FUNCTION check_journal_entry_balanced() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF (SELECT SUM(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT OR UPDATE
+    ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+EXECUTE FUNCTION check_journal_entry_balanced();
+
+ALTER TABLE transactions
+    ADD COLUMN entry_id INT REFERENCES journal_entries (id);
+
+-- move existing balances into the ledger as opening entries
+DO
+$$
+    DECLARE
+        b        RECORD;
+        entry    INT;
+        wallet   INT;
+        hold     INT := (SELECT id FROM accounts WHERE code = 'reservations_hold');
+        opening  INT := (SELECT id FROM accounts WHERE code = 'opening_balances');
+    BEGIN
+        FOR b IN SELECT user_id, amount, reserved FROM balances
+            LOOP
+                INSERT INTO accounts (user_id, kind) VALUES (b.user_id, 'wallet') RETURNING id INTO wallet;
+                CONTINUE WHEN b.amount = 0 AND b.reserved = 0;
+
+                INSERT INTO journal_entries (kind) VALUES ('opening') RETURNING id INTO entry;
+                INSERT INTO postings (entry_id, account_id, amount)
+                VALUES (entry, opening, -(b.amount + b.reserved));
+                IF b.amount <> 0 THEN
+                    INSERT INTO postings (entry_id, account_id, amount) VALUES (entry, wallet, b.amount);
+                END IF;
+                IF b.reserved <> 0 THEN
+                    INSERT INTO postings (entry_id, account_id, amount) VALUES (entry, hold, b.reserved);
+                END IF;
+            END LOOP;
+    END
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions
+    DROP COLUMN entry_id;
+DROP TABLE postings;
+DROP FUNCTION check_journal_entry_balanced;
+DROP TABLE journal_entries;
+DROP TABLE accounts;
+-- +goose StatementEnd
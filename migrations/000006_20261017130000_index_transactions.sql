@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX transactions_user_id_id_idx ON transactions (user_id, id);
+CREATE INDEX transactions_user_id_date_idx ON transactions (user_id, date DESC, id DESC);
+CREATE INDEX transactions_user_id_amount_idx ON transactions (user_id, amount DESC, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX transactions_user_id_amount_idx;
+DROP INDEX transactions_user_id_date_idx;
+DROP INDEX transactions_user_id_id_idx;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE transactions
+    ADD COLUMN kind                 VARCHAR(16),
+    ADD COLUMN source               VARCHAR(16),
+    ADD COLUMN counterparty_user_id INT REFERENCES users (id),
+    ADD COLUMN service_id           BIGINT,
+    ADD COLUMN order_id             BIGINT,
+    ADD COLUMN comment              VARCHAR(255) NOT NULL DEFAULT '';
+
+UPDATE transactions
+SET kind       = CASE
+                     WHEN operation LIKE 'Top-up by transfer%' THEN 'transfer_in'
+                     WHEN operation LIKE 'Debit by transfer%' THEN 'transfer_out'
+                     WHEN operation LIKE 'Top-up%' THEN 'top_up'
+                     ELSE 'purchase'
+    END,
+    source     = CASE
+                     WHEN operation LIKE '% by transfer %' THEN 'transfer'
+                     WHEN operation LIKE '% by bank_card %' THEN 'bank_card'
+                     ELSE 'purchase'
+        END,
+    order_id   = substring(operation FROM 'for order (\d+)')::BIGINT,
+    service_id = substring(operation FROM 'of service (\d+)')::BIGINT;
+
+ALTER TABLE transactions
+    ALTER COLUMN kind SET NOT NULL,
+    ALTER COLUMN source SET NOT NULL,
+    DROP COLUMN operation;
+
+CREATE INDEX transactions_user_id_kind_idx ON transactions (user_id, kind);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions
+    ADD COLUMN operation VARCHAR(255);
+
+UPDATE transactions
+SET operation = CASE
+                    WHEN amount > 0 THEN 'Top-up by ' || source || ' ' || to_char(amount, 'FM999999999990.00') || 'RUB'
+                    ELSE 'Debit by ' || source || ' ' || to_char(amount, 'FM999999999990.00') || 'RUB'
+    END;
+
+ALTER TABLE transactions
+    ALTER COLUMN operation SET NOT NULL,
+    DROP COLUMN comment,
+    DROP COLUMN order_id,
+    DROP COLUMN service_id,
+    DROP COLUMN counterparty_user_id,
+    DROP COLUMN source,
+    DROP COLUMN kind;
+-- +goose StatementEnd
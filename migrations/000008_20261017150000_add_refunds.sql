@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE transactions
+    ADD COLUMN refund_of INT REFERENCES transactions (id),
+    ADD COLUMN refunded  NUMERIC(15, 2) NOT NULL DEFAULT 0 CHECK (refunded >= 0);
+
+CREATE INDEX transactions_refund_of_idx ON transactions (refund_of);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions
+    DROP COLUMN refunded,
+    DROP COLUMN refund_of;
+-- +goose StatementEnd
@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE transfers
+(
+    id           SERIAL PRIMARY KEY,
+    from_user_id INT            NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    to_user_id   INT            NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount       NUMERIC(15, 2) NOT NULL CHECK (amount > 0),
+    comment      VARCHAR(255)   NOT NULL DEFAULT '',
+    status       VARCHAR(16)    NOT NULL,
+    created_at   TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX transfers_from_user_id_idx ON transfers (from_user_id, id);
+CREATE INDEX transfers_to_user_id_idx ON transfers (to_user_id, id);
+
+ALTER TABLE transactions
+    ADD COLUMN transfer_id INT REFERENCES transfers (id);
+
+-- transfers made since the ledger are the pairs of legs of the same journal entry,
+-- older legs can't be paired and stay without a transfer
+DO
+$$
+    DECLARE
+        l        RECORD;
+        transfer INT;
+    BEGIN
+        FOR l IN SELECT o.entry_id, o.user_id AS from_id, i.user_id AS to_id, i.amount, i.comment, i.date,
+                        o.refunded
+                 FROM transactions o
+                          JOIN transactions i ON i.entry_id = o.entry_id AND i.kind = 'transfer_in'
+                 WHERE o.kind = 'transfer_out'
+            LOOP
+                INSERT INTO transfers (from_user_id, to_user_id, amount, comment, status, created_at, completed_at)
+                VALUES (l.from_id, l.to_id, l.amount, l.comment,
+                        CASE WHEN l.refunded = l.amount THEN 'reversed' ELSE 'completed' END, l.date, l.date)
+                RETURNING id INTO transfer;
+
+                UPDATE transactions
+                SET transfer_id = transfer
+                WHERE entry_id = l.entry_id
+                   OR refund_of IN (SELECT id FROM transactions WHERE entry_id = l.entry_id);
+            END LOOP;
+    END
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions
+    DROP COLUMN transfer_id;
+DROP TABLE transfers;
+-- +goose StatementEnd
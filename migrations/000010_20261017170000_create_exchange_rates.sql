@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- rate is the price of one unit of the currency in rubles. Snapshots of the provider
+-- are valid until the next snapshot, manual overrides win over them until valid_to.
+CREATE TABLE exchange_rates
+(
+    id         SERIAL PRIMARY KEY,
+    currency   VARCHAR(3)  NOT NULL,
+    rate       NUMERIC     NOT NULL CHECK (rate > 0),
+    source     VARCHAR(16) NOT NULL,
+    valid_from TIMESTAMP   NOT NULL,
+    valid_to   TIMESTAMP CHECK (valid_to > valid_from),
+    created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX exchange_rates_currency_valid_from_idx ON exchange_rates (currency, valid_from);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE exchange_rates;
+-- +goose StatementEnd
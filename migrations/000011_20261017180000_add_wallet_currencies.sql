@@ -0,0 +1,93 @@
+-- +goose Up
+-- +goose StatementBegin
+-- wallets are kept per user and currency, the existing ones are ruble wallets
+ALTER TABLE balances
+    ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'RUB',
+    DROP CONSTRAINT balances_user_id_key,
+    ADD CONSTRAINT balances_user_id_currency_key UNIQUE (user_id, currency);
+
+-- every account holds a single currency, system accounts get an account per currency on the first use
+ALTER TABLE accounts
+    ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'RUB',
+    DROP CONSTRAINT accounts_user_id_key,
+    DROP CONSTRAINT accounts_code_key,
+    ADD CONSTRAINT accounts_user_id_currency_key UNIQUE (user_id, currency),
+    ADD CONSTRAINT accounts_code_currency_key UNIQUE (code, currency);
+
+INSERT INTO accounts (code, kind)
+VALUES ('currency_exchange', 'system');
+
+ALTER TABLE transactions
+    ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+
+-- a transfer between wallets of different currencies exchanges amount into to_amount
+-- at rate, spread is the part of the exchanged money kept by the service
+ALTER TABLE transfers
+    ADD COLUMN currency    VARCHAR(3) NOT NULL DEFAULT 'RUB',
+    ADD COLUMN to_currency VARCHAR(3) NOT NULL DEFAULT 'RUB',
+    ADD COLUMN to_amount   NUMERIC(15, 2),
+    ADD COLUMN rate        NUMERIC,
+    ADD COLUMN spread      NUMERIC;
+
+UPDATE transfers
+SET to_amount = amount;
+
+ALTER TABLE transfers
+    ALTER COLUMN to_amount SET NOT NULL;
+
+-- postings of every journal entry must sum to zero in every currency
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF EXISTS(SELECT 1
+              FROM postings p
+                       JOIN accounts a ON a.id = p.account_id
+              WHERE p.entry_id = NEW.entry_id
+              GROUP BY a.currency
+              HAVING SUM(p.amount) <> 0) THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF (SELECT SUM(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE transfers
+    DROP COLUMN currency,
+    DROP COLUMN to_currency,
+    DROP COLUMN to_amount,
+    DROP COLUMN rate,
+    DROP COLUMN spread;
+
+ALTER TABLE transactions
+    DROP COLUMN currency;
+
+-- only ruble wallets fit the old schema, the rollback fails while there are others
+DELETE
+FROM accounts
+WHERE code = 'currency_exchange'
+  AND NOT EXISTS(SELECT 1 FROM postings WHERE account_id = accounts.id);
+ALTER TABLE accounts
+    DROP CONSTRAINT accounts_user_id_currency_key,
+    DROP CONSTRAINT accounts_code_currency_key,
+    DROP COLUMN currency,
+    ADD CONSTRAINT accounts_user_id_key UNIQUE (user_id),
+    ADD CONSTRAINT accounts_code_key UNIQUE (code);
+
+ALTER TABLE balances
+    DROP CONSTRAINT balances_user_id_currency_key,
+    DROP COLUMN currency,
+    ADD CONSTRAINT balances_user_id_key UNIQUE (user_id);
+-- +goose StatementEnd
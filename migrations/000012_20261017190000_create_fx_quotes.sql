@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- quoted_amount is the ruble price of amount in currency locked at rate with the spread,
+-- a debit with the quote charges it once before expires_at.
+CREATE TABLE fx_quotes
+(
+    id            SERIAL PRIMARY KEY,
+    user_id       INT        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount        NUMERIC    NOT NULL CHECK (amount > 0),
+    currency      VARCHAR(3) NOT NULL,
+    rate          NUMERIC    NOT NULL CHECK (rate > 0),
+    spread        NUMERIC    NOT NULL,
+    quoted_amount NUMERIC    NOT NULL CHECK (quoted_amount > 0),
+    created_at    TIMESTAMP  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at    TIMESTAMP  NOT NULL,
+    used_at       TIMESTAMP
+);
+
+ALTER TABLE transactions ADD COLUMN quote_id INT REFERENCES fx_quotes (id);
+CREATE UNIQUE INDEX transactions_quote_id_idx ON transactions (quote_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions DROP COLUMN quote_id;
+DROP TABLE fx_quotes;
+-- +goose StatementEnd
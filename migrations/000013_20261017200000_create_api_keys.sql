@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- key_hash is the SHA-256 of the key, the key itself is shown once on creation.
+-- scopes are separated by spaces.
+CREATE TABLE api_keys
+(
+    id         SERIAL PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL UNIQUE,
+    key_hash   VARCHAR(64)  NOT NULL UNIQUE,
+    scopes     TEXT         NOT NULL DEFAULT '',
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+-- nonces of signed requests are kept until their timestamps leave the allowed window
+CREATE TABLE api_nonces
+(
+    key_id     INT          NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    nonce      VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP    NOT NULL,
+    PRIMARY KEY (key_id, nonce)
+);
+
+ALTER TABLE transactions ADD COLUMN api_key_id INT REFERENCES api_keys (id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transactions DROP COLUMN api_key_id;
+DROP TABLE api_nonces;
+DROP TABLE api_keys;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- token_hash is the SHA-256 of the refresh token, a token is revoked when it is exchanged for new tokens
+CREATE TABLE refresh_tokens
+(
+    id         SERIAL PRIMARY KEY,
+    user_id    INT         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP   NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE refresh_tokens;
+-- +goose StatementEnd
@@ -0,0 +1,70 @@
+-- +goose Up
+-- +goose StatementBegin
+-- users are deleted softly, the financial history of a deleted user stays in place
+ALTER TABLE users
+    ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN deleted_at TIMESTAMP,
+    DROP CONSTRAINT users_username_key,
+    DROP CONSTRAINT users_email_key;
+
+-- the username and the email of a deleted user may be taken again
+CREATE UNIQUE INDEX users_username_key ON users (username) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX users_email_key ON users (email) WHERE deleted_at IS NULL;
+
+-- the rows referencing users are kept, a user with them can't be deleted for real
+ALTER TABLE balances
+    DROP CONSTRAINT balances_user_id_fkey,
+    ADD CONSTRAINT balances_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+ALTER TABLE transactions
+    DROP CONSTRAINT transactions_user_id_fkey,
+    ADD CONSTRAINT transactions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+ALTER TABLE reservations
+    DROP CONSTRAINT reservations_user_id_fkey,
+    ADD CONSTRAINT reservations_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+ALTER TABLE accounts
+    DROP CONSTRAINT accounts_user_id_fkey,
+    ADD CONSTRAINT accounts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+ALTER TABLE transfers
+    DROP CONSTRAINT transfers_from_user_id_fkey,
+    DROP CONSTRAINT transfers_to_user_id_fkey,
+    ADD CONSTRAINT transfers_from_user_id_fkey FOREIGN KEY (from_user_id) REFERENCES users (id) ON DELETE RESTRICT,
+    ADD CONSTRAINT transfers_to_user_id_fkey FOREIGN KEY (to_user_id) REFERENCES users (id) ON DELETE RESTRICT;
+ALTER TABLE fx_quotes
+    DROP CONSTRAINT fx_quotes_user_id_fkey,
+    ADD CONSTRAINT fx_quotes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE fx_quotes
+    DROP CONSTRAINT fx_quotes_user_id_fkey,
+    ADD CONSTRAINT fx_quotes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE transfers
+    DROP CONSTRAINT transfers_from_user_id_fkey,
+    DROP CONSTRAINT transfers_to_user_id_fkey,
+    ADD CONSTRAINT transfers_from_user_id_fkey FOREIGN KEY (from_user_id) REFERENCES users (id) ON DELETE CASCADE,
+    ADD CONSTRAINT transfers_to_user_id_fkey FOREIGN KEY (to_user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE accounts
+    DROP CONSTRAINT accounts_user_id_fkey,
+    ADD CONSTRAINT accounts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE reservations
+    DROP CONSTRAINT reservations_user_id_fkey,
+    ADD CONSTRAINT reservations_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE transactions
+    DROP CONSTRAINT transactions_user_id_fkey,
+    ADD CONSTRAINT transactions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE balances
+    DROP CONSTRAINT balances_user_id_fkey,
+    ADD CONSTRAINT balances_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+-- the rollback fails while a deleted user shares the username or the email with another one
+DROP INDEX users_username_key;
+DROP INDEX users_email_key;
+ALTER TABLE users
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at,
+    DROP COLUMN deleted_at,
+    ADD CONSTRAINT users_username_key UNIQUE (username),
+    ADD CONSTRAINT users_email_key UNIQUE (email);
+-- +goose StatementEnd
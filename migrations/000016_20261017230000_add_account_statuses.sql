@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the status of the account limits the money movement on the wallets of the user
+ALTER TABLE users
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'debit_frozen', 'frozen', 'closed'));
+
+-- the audit trail of account statuses, api_key_id is the service that changed the status
+CREATE TABLE account_status_changes
+(
+    id              SERIAL PRIMARY KEY,
+    user_id         INT          NOT NULL REFERENCES users (id) ON DELETE RESTRICT,
+    status          VARCHAR(16)  NOT NULL,
+    previous_status VARCHAR(16)  NOT NULL,
+    reason          VARCHAR(255) NOT NULL,
+    api_key_id      INT REFERENCES api_keys (id),
+    created_at      TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX account_status_changes_user_id_idx ON account_status_changes (user_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE account_status_changes;
+
+ALTER TABLE users
+    DROP COLUMN status;
+-- +goose StatementEnd
@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every state-changing call of the API, the actor is the service of api_key_id or the end user actor_user_id.
+-- user_id, balance and currency are the resulting balance of the calls answering with one.
+CREATE TABLE audit_log
+(
+    id            BIGSERIAL PRIMARY KEY,
+    api_key_id    INT,
+    actor_user_id INT,
+    route         VARCHAR(255)   NOT NULL,
+    path          TEXT           NOT NULL,
+    payload_hash  VARCHAR(64)    NOT NULL,
+    status        INT            NOT NULL,
+    result        VARCHAR(64)    NOT NULL,
+    user_id       INT,
+    balance       NUMERIC(15, 2),
+    currency      VARCHAR(3),
+    request_id    VARCHAR(255)   NOT NULL DEFAULT '',
+    created_at    TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_log_api_key_id_idx ON audit_log (api_key_id, id);
+CREATE INDEX audit_log_actor_user_id_idx ON audit_log (actor_user_id, id);
+CREATE INDEX audit_log_user_id_idx ON audit_log (user_id, id);
+CREATE INDEX audit_log_request_id_idx ON audit_log (request_id);
+
+-- the log is append-only, its records can't be changed or removed
+CREATE FUNCTION reject_audit_log_change() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE
+    ON audit_log
+    FOR EACH ROW
+EXECUTE FUNCTION reject_audit_log_change();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE
+    ON audit_log
+    FOR EACH STATEMENT
+EXECUTE FUNCTION reject_audit_log_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE audit_log;
+DROP FUNCTION reject_audit_log_change();
+-- +goose StatementEnd
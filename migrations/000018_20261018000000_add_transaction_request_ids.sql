@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- request_id is the X-Request-ID of the call that made the transaction, support finds transactions by it.
+ALTER TABLE transactions ADD COLUMN request_id VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX transactions_request_id_idx ON transactions (request_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX transactions_request_id_idx;
+ALTER TABLE transactions DROP COLUMN request_id;
+-- +goose StatementEnd
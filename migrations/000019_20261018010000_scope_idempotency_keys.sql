@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- keys are prefixed with their caller like "key:<api_key_id>:" or "user:<user_id>:".
+ALTER TABLE idempotency_keys ALTER COLUMN key TYPE VARCHAR(300);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM idempotency_keys WHERE length(key) > 255;
+ALTER TABLE idempotency_keys ALTER COLUMN key TYPE VARCHAR(255);
+-- +goose StatementEnd